@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// AuthorizationPolicy requires that authenticated requests matching a path pattern and method have specific claims.
+type AuthorizationPolicy struct {
+	// Path is an absolute path pattern, e.g. '/admin/**'. Supports the same syntax as 'auto-login-ignore-paths'.
+	Path string `json:"path"`
+	// Methods are the HTTP methods that the policy applies to. Empty means all methods.
+	Methods []string `json:"methods,omitempty"`
+	// Claims maps claim names to accepted values. Each claim must have at least one of its accepted values.
+	// Claims with array values, e.g. 'groups', must contain at least one of the accepted values.
+	Claims map[string][]string `json:"claims,omitempty"`
+	// EmailDomains are the accepted domains for the 'email' claim.
+	EmailDomains []string `json:"email-domains,omitempty"`
+}
+
+func (in AuthorizationPolicy) Validate() error {
+	if !strings.HasPrefix(in.Path, "/") {
+		return fmt.Errorf("'path' must start with '/', was %q", in.Path)
+	}
+
+	if !doublestar.ValidatePattern(in.Path) {
+		return fmt.Errorf("'path' has invalid pattern %q", in.Path)
+	}
+
+	if len(in.Claims) == 0 && len(in.EmailDomains) == 0 {
+		return fmt.Errorf("at least one of 'claims' or 'email-domains' must be set")
+	}
+
+	for claim, values := range in.Claims {
+		if len(values) == 0 {
+			return fmt.Errorf("claim %q must have at least one accepted value", claim)
+		}
+	}
+
+	return nil
+}
+
+// ParseAuthorizationPolicies parses the given JSON array of authorization policies.
+func ParseAuthorizationPolicies(raw string) ([]AuthorizationPolicy, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var policies []AuthorizationPolicy
+	if err := json.Unmarshal([]byte(raw), &policies); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", AuthorizationPolicies, err)
+	}
+
+	for i, policy := range policies {
+		if err := policy.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: policy %d: %w", AuthorizationPolicies, i, err)
+		}
+	}
+
+	return policies, nil
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// AuthMode is the action to take for unauthenticated requests.
+type AuthMode string
+
+const (
+	// AuthModePassthrough proxies unauthenticated requests to the upstream without a token.
+	AuthModePassthrough AuthMode = "passthrough"
+	// AuthModeRedirect redirects unauthenticated GET requests to login. Other methods are denied.
+	AuthModeRedirect AuthMode = "redirect"
+	// AuthModeDeny rejects unauthenticated requests with 401 Unauthorized for all methods.
+	AuthModeDeny AuthMode = "deny"
+)
+
+func (m AuthMode) Valid() bool {
+	switch m {
+	case AuthModePassthrough, AuthModeRedirect, AuthModeDeny:
+		return true
+	}
+
+	return false
+}
+
+// AuthRule maps requests with paths matching a glob-style pattern to an AuthMode.
+type AuthRule struct {
+	// Path is an absolute path pattern, e.g. '/api/**'. Supports the same syntax as 'auto-login-ignore-paths'.
+	Path string   `json:"path"`
+	Mode AuthMode `json:"mode"`
+}
+
+func (in AuthRule) Validate() error {
+	if !strings.HasPrefix(in.Path, "/") {
+		return fmt.Errorf("'path' must start with '/', was %q", in.Path)
+	}
+
+	if !doublestar.ValidatePattern(in.Path) {
+		return fmt.Errorf("'path' has invalid pattern %q", in.Path)
+	}
+
+	if !in.Mode.Valid() {
+		return fmt.Errorf("'mode' must be one of %q, %q or %q, was %q", AuthModePassthrough, AuthModeRedirect, AuthModeDeny, in.Mode)
+	}
+
+	return nil
+}
+
+// ParseAuthRules parses the given JSON array of authentication rules.
+func ParseAuthRules(raw string) ([]AuthRule, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var rules []AuthRule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", AuthRules, err)
+	}
+
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", AuthRules, i, err)
+		}
+	}
+
+	return rules, nil
+}
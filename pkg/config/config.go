@@ -2,8 +2,12 @@ package config
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
+	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/nais/liberator/pkg/conftools"
 	log "github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
@@ -18,13 +22,28 @@ type Config struct {
 	LogLevel           string `json:"log-level"`
 	MetricsBindAddress string `json:"metrics-bind-address"`
 
-	AutoLogin            bool     `json:"auto-login"`
-	AutoLoginIgnorePaths []string `json:"auto-login-ignore-paths"`
-	EncryptionKey        string   `json:"encryption-key"`
-	ErrorPath            string   `json:"error-path"`
-	Ingresses            []string `json:"ingress"`
-	Session              Session  `json:"session"`
-	UpstreamHost         string   `json:"upstream-host"`
+	AuthRules              string   `json:"auth-rules"`
+	AuthorizationPolicies  string   `json:"authorization-policies"`
+	AutoLogin              bool     `json:"auto-login"`
+	AutoLoginIgnorePaths   []string `json:"auto-login-ignore-paths"`
+	AutoLoginIncludePaths  []string `json:"auto-login-include-paths"`
+	AutoLoginMethods       []string `json:"auto-login-methods"`
+	EncryptionKey          string   `json:"encryption-key"`
+	ErrorPath              string   `json:"error-path"`
+	ErrorTemplateDir       string   `json:"error-template-dir"`
+	Ingresses              []string `json:"ingress"`
+	RedirectAllowedOrigins []string `json:"redirect-allowed-origins"`
+	Session                Session  `json:"session"`
+	UpstreamHost           string   `json:"upstream-host"`
+	UpstreamRoutes         string   `json:"upstream-routes"`
+
+	AuthzCallout    AuthzCallout    `json:"authz-callout"`
+	BearerToken     BearerToken     `json:"bearer-token"`
+	CORS            CORS            `json:"cors"`
+	RateLimit       RateLimit       `json:"rate-limit"`
+	UpstreamRequest UpstreamRequest `json:"upstream-request"`
+	UpstreamTLS     UpstreamTLS     `json:"upstream-tls"`
+	UpstreamToken   UpstreamToken   `json:"upstream-token"`
 
 	OpenID OpenID `json:"openid"`
 	Redis  Redis  `json:"redis"`
@@ -32,6 +51,19 @@ type Config struct {
 	Loginstatus Loginstatus `json:"loginstatus"`
 }
 
+type AuthzCallout struct {
+	URL      string        `json:"url"`
+	Timeout  time.Duration `json:"timeout"`
+	CacheTTL time.Duration `json:"cache-ttl"`
+	Headers  []string      `json:"headers"`
+}
+
+type BearerToken struct {
+	Enabled     bool     `json:"enabled"`
+	Audiences   []string `json:"audiences"`
+	SigningAlgs []string `json:"signing-algs"`
+}
+
 type Loginstatus struct {
 	Enabled           bool   `json:"enabled"`
 	CookieDomain      string `json:"cookie-domain"`
@@ -41,29 +73,60 @@ type Loginstatus struct {
 }
 
 type Session struct {
-	Inactivity        bool          `json:"inactivity"`
-	InactivityTimeout time.Duration `json:"inactivity-timeout"`
-	MaxLifetime       time.Duration `json:"max-lifetime"`
-	Refresh           bool          `json:"refresh"`
+	Check                  bool          `json:"check"`
+	Claims                 []string      `json:"claims"`
+	CheckInterval          time.Duration `json:"check-interval"`
+	CheckOnChange          string        `json:"check-on-change"`
+	Inactivity             bool          `json:"inactivity"`
+	InactivityTimeout      time.Duration `json:"inactivity-timeout"`
+	MaxLifetime            time.Duration `json:"max-lifetime"`
+	Refresh                bool          `json:"refresh"`
+	WebSocketCheckInterval time.Duration `json:"websocket-check-interval"`
 }
 
+const (
+	SessionCheckOnChangeLogout         = "logout"
+	SessionCheckOnChangeReauthenticate = "reauthenticate"
+)
+
 const (
 	BindAddress        = "bind-address"
 	LogFormat          = "log-format"
 	LogLevel           = "log-level"
 	MetricsBindAddress = "metrics-bind-address"
 
-	AutoLogin            = "auto-login"
-	AutoLoginIgnorePaths = "auto-login-ignore-paths"
-	EncryptionKey        = "encryption-key"
-	ErrorPath            = "error-path"
-	Ingress              = "ingress"
-	UpstreamHost         = "upstream-host"
-
-	SessionInactivity        = "session.inactivity"
-	SessionInactivityTimeout = "session.inactivity-timeout"
-	SessionMaxLifetime       = "session.max-lifetime"
-	SessionRefresh           = "session.refresh"
+	AuthRules              = "auth-rules"
+	AuthorizationPolicies  = "authorization-policies"
+	AutoLogin              = "auto-login"
+	AutoLoginIgnorePaths   = "auto-login-ignore-paths"
+	AutoLoginIncludePaths  = "auto-login-include-paths"
+	AutoLoginMethods       = "auto-login-methods"
+	EncryptionKey          = "encryption-key"
+	ErrorPath              = "error-path"
+	ErrorTemplateDir       = "error-template-dir"
+	Ingress                = "ingress"
+	RedirectAllowedOrigins = "redirect-allowed-origins"
+	UpstreamHost           = "upstream-host"
+	UpstreamRoutes         = "upstream-routes"
+
+	AuthzCalloutURL      = "authz-callout.url"
+	AuthzCalloutTimeout  = "authz-callout.timeout"
+	AuthzCalloutCacheTTL = "authz-callout.cache-ttl"
+	AuthzCalloutHeaders  = "authz-callout.headers"
+
+	BearerTokenEnabled     = "bearer-token.enabled"
+	BearerTokenAudiences   = "bearer-token.audiences"
+	BearerTokenSigningAlgs = "bearer-token.signing-algs"
+
+	SessionCheck                  = "session.check"
+	SessionCheckInterval          = "session.check-interval"
+	SessionCheckOnChange          = "session.check-on-change"
+	SessionClaims                 = "session.claims"
+	SessionInactivity             = "session.inactivity"
+	SessionInactivityTimeout      = "session.inactivity-timeout"
+	SessionMaxLifetime            = "session.max-lifetime"
+	SessionRefresh                = "session.refresh"
+	SessionWebSocketCheckInterval = "session.websocket-check-interval"
 
 	LoginstatusEnabled           = "loginstatus.enabled"
 	LoginstatusCookieDomain      = "loginstatus.cookie-domain"
@@ -80,17 +143,38 @@ func Initialize() (*Config, error) {
 	flag.String(LogLevel, "info", "Logging verbosity level.")
 	flag.String(MetricsBindAddress, "127.0.0.1:3001", "Listen address for metrics only.")
 
+	flag.String(AuthRules, "", "JSON array of rules that map path patterns to the handling of unauthenticated requests, one of 'passthrough', 'redirect' or 'deny'. The first matching rule applies. Takes precedence over 'auto-login'.")
+	flag.String(AuthorizationPolicies, "", "JSON array of policies that require specific claims for authenticated requests matching a path pattern and method. Requests that fail a policy are rejected with 403.")
 	flag.Bool(AutoLogin, false, "Automatically redirect all HTTP GET requests to login if the user does not have a valid session for all matching upstream paths.")
-	flag.StringSlice(AutoLoginIgnorePaths, []string{}, "Comma separated list of absolute paths to ignore when 'auto-login' is enabled. Supports basic wildcard matching with glob-style asterisks. Invalid patterns are ignored.")
+	flag.StringSlice(AutoLoginIgnorePaths, []string{}, "Comma separated list of absolute paths to ignore when 'auto-login' is enabled. Supports basic wildcard matching with glob-style asterisks. Paths may be prefixed with a host, e.g. 'www.example.com/public/**', to only apply to that host. Invalid patterns are ignored.")
+	flag.StringSlice(AutoLoginIncludePaths, []string{}, "Comma separated list of absolute paths that require login when 'auto-login' is enabled. If set, other paths are not redirected to login. Supports the same patterns as 'auto-login-ignore-paths', which take precedence.")
+	flag.StringSlice(AutoLoginMethods, []string{http.MethodGet}, "Comma separated list of HTTP methods that 'auto-login' applies to. Requests with other methods than GET are denied with 401 instead of redirected.")
 	flag.String(EncryptionKey, "", "Base64 encoded 256-bit cookie encryption key; must be identical in instances that share session store.")
 	flag.String(ErrorPath, "", "Absolute path to redirect user to on errors for custom error handling.")
+	flag.String(ErrorTemplateDir, "", "Path to a directory with templates for error pages, loaded at startup. 'error.gohtml' is the default template, and 'error.<locale>.gohtml' are variants for specific locales, e.g. 'error.en.gohtml'. Empty means the built-in template.")
 	flag.StringSlice(Ingress, []string{}, "Comma separated list of ingresses used to access the main application.")
-	flag.String(UpstreamHost, "127.0.0.1:8080", "Address of upstream host.")
-
+	flag.StringSlice(RedirectAllowedOrigins, []string{}, "Comma separated list of origins that absolute redirect URLs after login and logout may point to, in addition to the configured ingresses, e.g. 'https://app.example'. A '*.' prefix for the host matches any subdomain.")
+	flag.String(UpstreamHost, "127.0.0.1:8080", "Address of upstream host. Supports 'host:port', 'http://host:port', 'https://host:port' and 'unix:///path/to/socket'.")
+	flag.String(UpstreamRoutes, "", "JSON array of routes that map hosts and/or path prefixes to other upstreams than 'upstream-host', with per-route settings.")
+
+	flag.String(AuthzCalloutURL, "", "URL to an external authorization service that decides whether requests are allowed before they are proxied to the upstream. Empty disables the callout.")
+	flag.Duration(AuthzCalloutTimeout, 2*time.Second, "Timeout for requests to the external authorization service.")
+	flag.Duration(AuthzCalloutCacheTTL, 30*time.Second, "How long to cache decisions from the external authorization service for identical inputs. 0 disables caching.")
+	flag.StringSlice(AuthzCalloutHeaders, []string{}, "Comma separated list of request headers to include in requests to the external authorization service.")
+
+	flag.Bool(BearerTokenEnabled, false, "Accept requests with an 'Authorization: Bearer' header containing a JWT issued by the identity provider. Valid tokens are forwarded unchanged to the upstream, invalid tokens are rejected with 401.")
+	flag.StringSlice(BearerTokenAudiences, []string{}, "Comma separated list of accepted audiences for bearer tokens. Required if bearer tokens are enabled.")
+	flag.StringSlice(BearerTokenSigningAlgs, []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}, "Comma separated list of accepted signing algorithms for bearer tokens.")
+
+	flag.Bool(SessionCheck, false, "Enable endpoints for OpenID Connect Session Management, allowing frontends to detect changes to the end-user's session at the identity provider. Requires that the identity provider supports 'check_session_iframe'.")
+	flag.Duration(SessionCheckInterval, 5*time.Second, "Interval between session checks against the identity provider's 'check_session_iframe'.")
+	flag.String(SessionCheckOnChange, SessionCheckOnChangeLogout, "Action to perform when a session change at the identity provider is detected, either 'logout' (local logout) or 'reauthenticate' (silent re-authentication with 'prompt=none').")
+	flag.StringSlice(SessionClaims, []string{}, "Comma separated list of claims from the id_token and userinfo that are returned by the '/oauth2/session/claims' endpoint, e.g. 'sub,name,acr,auth_time'. Empty disables the endpoint.")
 	flag.Bool(SessionInactivity, false, "Automatically expire user sessions if they have not refreshed their tokens within a given duration.")
 	flag.Duration(SessionInactivityTimeout, 30*time.Minute, "Inactivity timeout for user sessions.")
 	flag.Duration(SessionMaxLifetime, time.Hour, "Max lifetime for user sessions.")
 	flag.Bool(SessionRefresh, false, "Automatically refresh the tokens for user sessions if they are expired, as long as the session exists (indicated by the session max lifetime).")
+	flag.Duration(SessionWebSocketCheckInterval, time.Minute, "Interval between checks of the session for proxied WebSocket connections, which are closed when the session ends. Sessions destroyed by this instance close their connections immediately.")
 
 	flag.Bool(LoginstatusEnabled, false, "Feature toggle for Loginstatus, a separate service that should provide an opaque token to indicate that a user has been authenticated previously, e.g. by another application in another subdomain.")
 	flag.String(LoginstatusCookieDomain, "", "The domain that the cookie should be set for.")
@@ -100,6 +184,11 @@ func Initialize() (*Config, error) {
 
 	redisFlags()
 	openIDFlags()
+	corsFlags()
+	rateLimitFlags()
+	upstreamRequestFlags()
+	upstreamTLSFlags()
+	upstreamTokenFlags()
 
 	flag.String(OpenIDProvider, string(ProviderOpenID), "Provider configuration to load and use, either 'openid', 'azure', 'idporten'.")
 	flag.Parse()
@@ -136,6 +225,7 @@ func Initialize() (*Config, error) {
 
 	maskedConfig := []string{
 		OpenIDClientJWK,
+		OpenIDIDTokenDecryptionJWK,
 		EncryptionKey,
 		RedisPassword,
 	}
@@ -157,5 +247,115 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("%q cannot be enabled without %q", SessionInactivity, SessionRefresh)
 	}
 
+	if c.Session.Check {
+		switch c.Session.CheckOnChange {
+		case SessionCheckOnChangeLogout, SessionCheckOnChangeReauthenticate:
+		default:
+			return fmt.Errorf("%q must be one of %q or %q, was %q", SessionCheckOnChange, SessionCheckOnChangeLogout, SessionCheckOnChangeReauthenticate, c.Session.CheckOnChange)
+		}
+
+		if c.Session.CheckInterval < time.Second {
+			return fmt.Errorf("%q must be at least 1s, was %q", SessionCheckInterval, c.Session.CheckInterval)
+		}
+	}
+
+	if c.Session.WebSocketCheckInterval < time.Second {
+		return fmt.Errorf("%q must be at least 1s, was %q", SessionWebSocketCheckInterval, c.Session.WebSocketCheckInterval)
+	}
+
+	if !c.OpenID.ClientAuthMethod.Valid() {
+		return fmt.Errorf("%q has invalid value %q", OpenIDClientAuthMethod, c.OpenID.ClientAuthMethod)
+	}
+
+	if c.OpenID.ClientAuthMethod.IsMutualTLS() && (len(c.OpenID.ClientTLSCertFile) == 0 || len(c.OpenID.ClientTLSKeyFile) == 0) {
+		return fmt.Errorf("%q and %q must be set when %q is %q", OpenIDClientTLSCertFile, OpenIDClientTLSKeyFile, OpenIDClientAuthMethod, c.OpenID.ClientAuthMethod)
+	}
+
+	if _, err := ParseUpstream(c.UpstreamHost); err != nil {
+		return fmt.Errorf("%q: %w", UpstreamHost, err)
+	}
+
+	for _, origin := range c.RedirectAllowedOrigins {
+		if err := ValidateOrigin(origin); err != nil {
+			return fmt.Errorf("%s: %w", RedirectAllowedOrigins, err)
+		}
+	}
+
+	if err := c.CORS.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.RateLimit.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.UpstreamToken.Validate(); err != nil {
+		return fmt.Errorf("upstream-token: %w", err)
+	}
+
+	if err := c.UpstreamTLS.Validate(); err != nil {
+		return fmt.Errorf("upstream-tls: %w", err)
+	}
+
+	if _, err := ParseUpstreamRoutes(c.UpstreamRoutes); err != nil {
+		return err
+	}
+
+	if _, err := ParseAuthRules(c.AuthRules); err != nil {
+		return err
+	}
+
+	if _, err := ParseAuthorizationPolicies(c.AuthorizationPolicies); err != nil {
+		return err
+	}
+
+	if len(c.AuthzCallout.URL) > 0 {
+		if _, err := url.ParseRequestURI(c.AuthzCallout.URL); err != nil {
+			return fmt.Errorf("%q: %w", AuthzCalloutURL, err)
+		}
+
+		if c.AuthzCallout.Timeout <= 0 {
+			return fmt.Errorf("%q must be positive, was %q", AuthzCalloutTimeout, c.AuthzCallout.Timeout)
+		}
+	}
+
+	if c.BearerToken.Enabled {
+		if len(c.BearerToken.Audiences) == 0 {
+			return fmt.Errorf("%q must be set when %q is enabled", BearerTokenAudiences, BearerTokenEnabled)
+		}
+
+		if len(c.BearerToken.SigningAlgs) == 0 {
+			return fmt.Errorf("%q must be set when %q is enabled", BearerTokenSigningAlgs, BearerTokenEnabled)
+		}
+
+		for _, alg := range c.BearerToken.SigningAlgs {
+			if !asymmetricSigningAlg(alg) {
+				return fmt.Errorf("%q must only contain asymmetric signing algorithms, got %q", BearerTokenSigningAlgs, alg)
+			}
+		}
+	}
+
+	if !c.OpenID.ResponseMode.Valid() {
+		return fmt.Errorf("%q has invalid value %q", OpenIDResponseMode, c.OpenID.ResponseMode)
+	}
+
+	if len(c.OpenID.MaxAge) > 0 {
+		maxAge, err := strconv.ParseInt(c.OpenID.MaxAge, 10, 64)
+		if err != nil || maxAge < 0 {
+			return fmt.Errorf("%q must be a non-negative integer, was %q", OpenIDMaxAge, c.OpenID.MaxAge)
+		}
+	}
+
 	return nil
 }
+
+// asymmetricSigningAlg returns true if the given JWS algorithm uses public key cryptography, i.e. excluding 'none' and
+// HMAC algorithms that would require a shared secret with the identity provider.
+func asymmetricSigningAlg(alg string) bool {
+	switch jwa.SignatureAlgorithm(alg) {
+	case jwa.RS256, jwa.RS384, jwa.RS512, jwa.PS256, jwa.PS384, jwa.PS512, jwa.ES256, jwa.ES384, jwa.ES512, jwa.EdDSA:
+		return true
+	default:
+		return false
+	}
+}
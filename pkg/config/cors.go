@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	CORSAllowCredentials = "cors.allow-credentials"
+	CORSAllowedOrigins   = "cors.allowed-origins"
+	CORSEnabled          = "cors.enabled"
+	CORSMaxAge           = "cors.max-age"
+	CORSUpstream         = "cors.upstream"
+)
+
+type CORS struct {
+	AllowCredentials bool          `json:"allow-credentials"`
+	AllowedOrigins   []string      `json:"allowed-origins"`
+	Enabled          bool          `json:"enabled"`
+	MaxAge           time.Duration `json:"max-age"`
+	Upstream         bool          `json:"upstream"`
+}
+
+func (in CORS) Validate() error {
+	if !in.Enabled {
+		return nil
+	}
+
+	for _, origin := range in.AllowedOrigins {
+		if origin == "*" {
+			if in.AllowCredentials {
+				return fmt.Errorf("%q cannot contain '*' when %q is enabled", CORSAllowedOrigins, CORSAllowCredentials)
+			}
+			continue
+		}
+
+		if err := ValidateOrigin(origin); err != nil {
+			return fmt.Errorf("%s: %w", CORSAllowedOrigins, err)
+		}
+	}
+
+	if in.MaxAge < 0 {
+		return fmt.Errorf("%q must be non-negative, was %q", CORSMaxAge, in.MaxAge)
+	}
+
+	return nil
+}
+
+// ValidateOrigin validates the given origin, i.e. a scheme and host with an optional port, e.g. 'https://app.example'.
+// The host may start with a '*.' wildcard that matches any subdomain, e.g. 'https://*.example.com'.
+func ValidateOrigin(origin string) error {
+	u, err := url.Parse(strings.Replace(origin, "*.", "wildcard.", 1))
+	if err != nil {
+		return fmt.Errorf("parsing origin %q: %w", origin, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("origin %q: scheme must be 'http' or 'https'", origin)
+	}
+
+	if len(u.Host) == 0 {
+		return fmt.Errorf("origin %q: missing host", origin)
+	}
+
+	if len(u.Path) > 0 || len(u.RawQuery) > 0 || len(u.Fragment) > 0 || u.User != nil {
+		return fmt.Errorf("origin %q: must only contain scheme, host and port", origin)
+	}
+
+	if strings.Contains(origin, "*") && !strings.HasPrefix(origin, u.Scheme+"://*.") {
+		return fmt.Errorf("origin %q: wildcards are only allowed as the first label of the host", origin)
+	}
+
+	return nil
+}
+
+// MatchOrigin returns true if the given origin, i.e. a scheme and host with an optional port, matches the pattern. The
+// pattern is either an exact origin, or an origin with a '*.' wildcard that matches any subdomain, as validated by
+// ValidateOrigin.
+func MatchOrigin(pattern, origin string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "/"))
+	origin = strings.ToLower(origin)
+
+	scheme, suffix, found := strings.Cut(pattern, "://*")
+	if !found {
+		return pattern == origin
+	}
+
+	originScheme, host, found := strings.Cut(origin, "://")
+	if !found {
+		return false
+	}
+
+	// the suffix starts with '.', so that the wildcard only matches subdomains
+	return originScheme == scheme && strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+}
+
+func corsFlags() {
+	flag.Bool(CORSAllowCredentials, false, "Allow credentials, i.e. cookies, in cross-origin requests. Required for cross-origin requests to the session endpoints.")
+	flag.StringSlice(CORSAllowedOrigins, []string{}, "Comma separated list of origins allowed to make cross-origin requests, e.g. 'https://app.example'. A '*.' prefix for the host matches any subdomain. The origins of the configured ingresses are always allowed.")
+	flag.Bool(CORSEnabled, false, "Enable CORS for the '/oauth2/*' endpoints.")
+	flag.Duration(CORSMaxAge, 10*time.Minute, "How long browsers may cache the results of preflight requests.")
+	flag.Bool(CORSUpstream, false, "Also enable CORS for requests proxied to the upstream. Preflight requests are then answered by Wonderwall.")
+}
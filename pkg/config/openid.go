@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	flag "github.com/spf13/pflag"
 )
 
@@ -8,22 +10,54 @@ const (
 	OpenIDProvider              = "openid.provider"
 	OpenIDClientID              = "openid.client-id"
 	OpenIDClientJWK             = "openid.client-jwk"
+	OpenIDClientJWKFile         = "openid.client-jwk-file"
+	OpenIDClientJWKKeyID        = "openid.client-jwk-kid"
+	OpenIDClientAuthMethod      = "openid.client-auth-method"
+	OpenIDClientTLSCertFile     = "openid.client-tls-cert-file"
+	OpenIDClientTLSKeyFile      = "openid.client-tls-key-file"
 	OpenIDPostLogoutRedirectURI = "openid.post-logout-redirect-uri"
 	OpenIDScopes                = "openid.scopes"
 	OpenIDWellKnownURL          = "openid.well-known-url"
 	OpenIDACRValues             = "openid.acr-values"
 	OpenIDUILocales             = "openid.ui-locales"
+	OpenIDPrompt                = "openid.prompt"
+	OpenIDLoginHint             = "openid.login-hint"
+	OpenIDDomainHint            = "openid.domain-hint"
+	OpenIDMaxAge                = "openid.max-age"
+	OpenIDResponseMode          = "openid.response-mode"
+	OpenIDIDTokenSigningAlgs    = "openid.id-token-signing-algs"
+	OpenIDIDTokenIatMaxAge      = "openid.id-token-iat-max-age"
+	OpenIDIDTokenDecryptionJWK  = "openid.id-token-decryption-jwk"
+	OpenIDUserInfo              = "openid.userinfo"
 )
 
 type OpenID struct {
 	Provider              Provider `json:"provider"`
 	ClientID              string   `json:"client-id"`
 	ClientJWK             string   `json:"client-jwk"`
+	ClientJWKFile         string   `json:"client-jwk-file"`
+	ClientJWKKeyID        string   `json:"client-jwk-kid"`
 	PostLogoutRedirectURI string   `json:"post-logout-redirect-uri"`
 	Scopes                []string `json:"scopes"`
 	WellKnownURL          string   `json:"well-known-url"`
 	ACRValues             string   `json:"acr-values"`
 	UILocales             string   `json:"ui-locales"`
+	Prompt                string   `json:"prompt"`
+	LoginHint             string   `json:"login-hint"`
+	DomainHint            string   `json:"domain-hint"`
+	MaxAge                string   `json:"max-age"`
+
+	ResponseMode ResponseMode `json:"response-mode"`
+
+	ClientAuthMethod  ClientAuthMethod `json:"client-auth-method"`
+	ClientTLSCertFile string           `json:"client-tls-cert-file"`
+	ClientTLSKeyFile  string           `json:"client-tls-key-file"`
+
+	IDTokenSigningAlgs   []string      `json:"id-token-signing-algs"`
+	IDTokenIatMaxAge     time.Duration `json:"id-token-iat-max-age"`
+	IDTokenDecryptionJWK string        `json:"id-token-decryption-jwk"`
+
+	UserInfo bool `json:"userinfo"`
 }
 
 type Provider string
@@ -34,13 +68,70 @@ const (
 	ProviderOpenID   Provider = "openid"
 )
 
+type ClientAuthMethod string
+
+const (
+	ClientAuthMethodPrivateKeyJWT           ClientAuthMethod = "private_key_jwt"
+	ClientAuthMethodTLSClientAuth           ClientAuthMethod = "tls_client_auth"
+	ClientAuthMethodSelfSignedTLSClientAuth ClientAuthMethod = "self_signed_tls_client_auth"
+)
+
+// IsMutualTLS returns true if the client authenticates with a TLS client certificate, see RFC 8705.
+func (in ClientAuthMethod) IsMutualTLS() bool {
+	return in == ClientAuthMethodTLSClientAuth || in == ClientAuthMethodSelfSignedTLSClientAuth
+}
+
+func (in ClientAuthMethod) Valid() bool {
+	return in == ClientAuthMethodPrivateKeyJWT || in.IsMutualTLS()
+}
+
+type ResponseMode string
+
+const (
+	ResponseModeQuery       ResponseMode = "query"
+	ResponseModeFormPost    ResponseMode = "form_post"
+	ResponseModeQueryJWT    ResponseMode = "query.jwt"
+	ResponseModeFormPostJWT ResponseMode = "form_post.jwt"
+)
+
+// IsFormPost returns true if the authorization response is delivered in the body of a POST request to the callback,
+// see OAuth 2.0 Form Post Response Mode.
+func (in ResponseMode) IsFormPost() bool {
+	return in == ResponseModeFormPost || in == ResponseModeFormPostJWT
+}
+
+// IsJWT returns true if the authorization response is delivered as a signed JWT, see JWT Secured Authorization
+// Response Mode for OAuth 2.0 (JARM).
+func (in ResponseMode) IsJWT() bool {
+	return in == ResponseModeQueryJWT || in == ResponseModeFormPostJWT
+}
+
+func (in ResponseMode) Valid() bool {
+	return in == ResponseModeQuery || in.IsFormPost() || in.IsJWT()
+}
+
 func openIDFlags() {
 	flag.String(OpenIDClientID, "", "Client ID for the OpenID client.")
-	flag.String(OpenIDClientJWK, "", "JWK containing the private key for the OpenID client in string format.")
+	flag.String(OpenIDClientJWK, "", "JWK containing the private key for the OpenID client in string format. May also be a JWK set.")
+	flag.String(OpenIDClientJWKFile, "", "Path to a file containing the private key for the OpenID client as a JWK or JWK set. The file is reloaded when changed. Mutually exclusive with 'openid.client-jwk'.")
+	flag.String(OpenIDClientJWKKeyID, "", "Key ID ('kid') of the key to use from the client JWK set. Empty means the last private signing key in the set.")
+	flag.String(OpenIDClientAuthMethod, string(ClientAuthMethodPrivateKeyJWT), "Client authentication method for requests to the token endpoint. One of 'private_key_jwt', 'tls_client_auth' or 'self_signed_tls_client_auth'.")
+	flag.String(OpenIDClientTLSCertFile, "", "Path to a PEM-encoded client certificate for mutual TLS client authentication. Required for 'tls_client_auth' and 'self_signed_tls_client_auth'.")
+	flag.String(OpenIDClientTLSKeyFile, "", "Path to the PEM-encoded private key for the client certificate. Required for 'tls_client_auth' and 'self_signed_tls_client_auth'.")
 	flag.String(OpenIDPostLogoutRedirectURI, "", "URI for redirecting the user after successful logout at the Identity Provider.")
 	flag.StringSlice(OpenIDScopes, []string{}, "List of additional scopes (other than 'openid') that should be used during the login flow.")
 	flag.String(OpenIDWellKnownURL, "", "URI to the well-known OpenID Configuration metadata document.")
 
 	flag.String(OpenIDACRValues, "", "Space separated string that configures the default security level (acr_values) parameter for authorization requests.")
 	flag.String(OpenIDUILocales, "", "Space-separated string that configures the default UI locale (ui_locales) parameter for OAuth2 consent screen.")
+	flag.String(OpenIDPrompt, "", "Space-separated string that configures the default prompt parameter for authorization requests, e.g. 'login' or 'select_account'.")
+	flag.String(OpenIDLoginHint, "", "Configures the default login_hint parameter for authorization requests.")
+	flag.String(OpenIDDomainHint, "", "Configures the default domain_hint parameter for authorization requests.")
+	flag.String(OpenIDResponseMode, string(ResponseModeQuery), "Response mode for authorization responses. One of 'query', 'form_post', 'query.jwt' or 'form_post.jwt'.")
+	flag.String(OpenIDMaxAge, "", "Configures the default max_age parameter for authorization requests, i.e. the allowable elapsed time in seconds since the end-user last actively authenticated.")
+
+	flag.StringSlice(OpenIDIDTokenSigningAlgs, []string{}, "List of accepted signing algorithms for id_tokens. Must be a subset of the identity provider's 'id_token_signing_alg_values_supported'. Empty means all algorithms supported by the identity provider.")
+	flag.Duration(OpenIDIDTokenIatMaxAge, 5*time.Minute, "Maximum allowed age of the 'iat' claim in id_tokens at the time of validation. 0 disables the check.")
+	flag.String(OpenIDIDTokenDecryptionJWK, "", "JWK or JWK set containing the private keys for decrypting encrypted id_tokens. The public keys are published at '/oauth2/jwks'. Empty means that the client JWK is used, unless its 'use' is 'sig'.")
+	flag.Bool(OpenIDUserInfo, false, "Fetch claims from the identity provider's 'userinfo_endpoint' on login and store them in the session, e.g. for use in 'authorization-policies'.")
 }
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	RateLimitEnabled        = "rate-limit.enabled"
+	RateLimitGlobal         = "rate-limit.global"
+	RateLimitPerIP          = "rate-limit.per-ip"
+	RateLimitTrustedProxies = "rate-limit.trusted-proxies"
+	RateLimitWindow         = "rate-limit.window"
+)
+
+type RateLimit struct {
+	Enabled        bool          `json:"enabled"`
+	Global         int           `json:"global"`
+	PerIP          int           `json:"per-ip"`
+	TrustedProxies []string      `json:"trusted-proxies"`
+	Window         time.Duration `json:"window"`
+}
+
+func (in RateLimit) Validate() error {
+	if !in.Enabled {
+		return nil
+	}
+
+	if in.Global < 0 {
+		return fmt.Errorf("%q must be non-negative, was %d", RateLimitGlobal, in.Global)
+	}
+
+	if in.PerIP < 0 {
+		return fmt.Errorf("%q must be non-negative, was %d", RateLimitPerIP, in.PerIP)
+	}
+
+	if in.Window < time.Second {
+		return fmt.Errorf("%q must be at least 1s, was %q", RateLimitWindow, in.Window)
+	}
+
+	if _, err := in.ParseTrustedProxies(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ParseTrustedProxies parses the trusted proxies, i.e. IP addresses or CIDR ranges.
+func (in RateLimit) ParseTrustedProxies() ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(in.TrustedProxies))
+
+	for _, proxy := range in.TrustedProxies {
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("%s: %q is neither an IP address nor a CIDR range", RateLimitTrustedProxies, proxy)
+			}
+
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func rateLimitFlags() {
+	flag.Bool(RateLimitEnabled, false, "Enable rate limiting for the login, callback and front-channel logout endpoints. Limits are shared through Redis if configured.")
+	flag.Int(RateLimitGlobal, 0, "Maximum number of requests per window across all clients. 0 means no global limit.")
+	flag.Int(RateLimitPerIP, 30, "Maximum number of requests per window for each client IP address. 0 means no per-client limit.")
+	flag.StringSlice(RateLimitTrustedProxies, []string{}, "Comma separated list of IP addresses or CIDR ranges of trusted reverse proxies. The client IP address is read from the 'X-Forwarded-For' header for requests from these proxies.")
+	flag.Duration(RateLimitWindow, time.Minute, "Duration of the window that the limits apply to.")
+}
@@ -0,0 +1,259 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	UpstreamTLSCAFile     = "upstream-tls.ca-file"
+	UpstreamTLSCertFile   = "upstream-tls.cert-file"
+	UpstreamTLSKeyFile    = "upstream-tls.key-file"
+	UpstreamTLSServerName = "upstream-tls.server-name"
+)
+
+const (
+	UpstreamRequestAllowedCookies         = "upstream-request.allowed-cookies"
+	UpstreamRequestAllowedHeaders         = "upstream-request.allowed-headers"
+	UpstreamRequestReservedHeaderPrefixes = "upstream-request.reserved-header-prefixes"
+)
+
+const (
+	UpstreamTokenAudience          = "upstream-token.audience"
+	UpstreamTokenHeader            = "upstream-token.header"
+	UpstreamTokenKeepAuthorization = "upstream-token.keep-authorization"
+	UpstreamTokenSessionHeaders    = "upstream-token.session-headers"
+	UpstreamTokenType              = "upstream-token.type"
+)
+
+const (
+	UpstreamSchemeHTTP  = "http"
+	UpstreamSchemeHTTPS = "https"
+	UpstreamSchemeUnix  = "unix"
+)
+
+// UpstreamTLS contains settings for connections to 'https://' upstreams.
+type UpstreamTLS struct {
+	CAFile     string `json:"ca-file,omitempty"`
+	CertFile   string `json:"cert-file,omitempty"`
+	KeyFile    string `json:"key-file,omitempty"`
+	ServerName string `json:"server-name,omitempty"`
+}
+
+func (in UpstreamTLS) Validate() error {
+	if (len(in.CertFile) > 0) != (len(in.KeyFile) > 0) {
+		return fmt.Errorf("both 'cert-file' and 'key-file' must be set for client certificates")
+	}
+
+	return nil
+}
+
+func upstreamTLSFlags() {
+	flag.String(UpstreamTLSCAFile, "", "Path to a PEM-encoded CA bundle for verifying 'https://' upstreams. Empty means the system CA pool.")
+	flag.String(UpstreamTLSCertFile, "", "Path to a PEM-encoded client certificate to present to 'https://' upstreams.")
+	flag.String(UpstreamTLSKeyFile, "", "Path to the PEM-encoded private key for the upstream client certificate.")
+	flag.String(UpstreamTLSServerName, "", "Server name (SNI) to use and verify for 'https://' upstreams. Empty means the upstream's host.")
+}
+
+// UpstreamRequest contains settings for sanitizing requests before they are proxied to the upstream.
+type UpstreamRequest struct {
+	AllowedCookies         []string `json:"allowed-cookies"`
+	AllowedHeaders         []string `json:"allowed-headers"`
+	ReservedHeaderPrefixes []string `json:"reserved-header-prefixes"`
+}
+
+func upstreamRequestFlags() {
+	flag.StringSlice(UpstreamRequestAllowedCookies, []string{}, "Comma separated list of Wonderwall's own cookies that should not be removed from requests to the upstream.")
+	flag.StringSlice(UpstreamRequestAllowedHeaders, []string{}, "Comma separated list of headers that should not be removed from requests to the upstream, even if they match 'upstream-request.reserved-header-prefixes'.")
+	flag.StringSlice(UpstreamRequestReservedHeaderPrefixes, []string{"X-Wonderwall-"}, "Comma separated list of header prefixes that are reserved for headers set by Wonderwall. Client-supplied headers matching these are removed from requests to the upstream.")
+}
+
+type TokenType string
+
+const (
+	TokenTypeAccessToken TokenType = "access_token"
+	TokenTypeIDToken     TokenType = "id_token"
+	TokenTypeExchanged   TokenType = "exchanged"
+	TokenTypeNone        TokenType = "none"
+)
+
+func (t TokenType) Valid() bool {
+	switch t {
+	case TokenTypeAccessToken, TokenTypeIDToken, TokenTypeExchanged, TokenTypeNone:
+		return true
+	}
+
+	return false
+}
+
+// UpstreamToken controls which token is forwarded to the upstream for authenticated requests, and how.
+type UpstreamToken struct {
+	// Type is the token to forward, one of TokenTypeAccessToken, TokenTypeIDToken, TokenTypeExchanged or TokenTypeNone.
+	Type TokenType `json:"type,omitempty"`
+	// Header is the request header that carries the token. Tokens in the 'Authorization' header use the 'Bearer'
+	// scheme, while other headers contain the token as-is.
+	Header string `json:"header,omitempty"`
+	// Audience is the target audience for tokens exchanged with TokenTypeExchanged.
+	Audience string `json:"audience,omitempty"`
+	// KeepAuthorization keeps any 'Authorization' header set by the client, instead of overwriting it with the token.
+	KeepAuthorization *bool `json:"keep-authorization,omitempty"`
+	// SessionHeaders adds headers with metadata about the session, e.g. its ID and expiry.
+	SessionHeaders *bool `json:"session-headers,omitempty"`
+}
+
+func (in UpstreamToken) Validate() error {
+	if len(in.Type) > 0 && !in.Type.Valid() {
+		return fmt.Errorf("'type' must be one of %q, %q, %q or %q, was %q", TokenTypeAccessToken, TokenTypeIDToken, TokenTypeExchanged, TokenTypeNone, in.Type)
+	}
+
+	if in.Type == TokenTypeExchanged && len(in.Audience) == 0 {
+		return fmt.Errorf("'audience' must be set for type %q", TokenTypeExchanged)
+	}
+
+	if strings.ContainsAny(in.Header, " \t\r\n:") {
+		return fmt.Errorf("'header' is not a valid header name: %q", in.Header)
+	}
+
+	return nil
+}
+
+func upstreamTokenFlags() {
+	flag.String(UpstreamTokenAudience, "", "Target audience for tokens exchanged with the identity provider when 'upstream-token.type' is 'exchanged'.")
+	flag.String(UpstreamTokenHeader, "Authorization", "Request header that carries the token forwarded to the upstream. The 'Authorization' header uses the 'Bearer' scheme; other headers contain the token as-is.")
+	flag.Bool(UpstreamTokenKeepAuthorization, false, "Keep any 'Authorization' header set by the client instead of overwriting it with the forwarded token.")
+	flag.Bool(UpstreamTokenSessionHeaders, false, "Add headers with metadata about the session to authenticated requests to the upstream, e.g. the session ID and expiry.")
+	flag.String(UpstreamTokenType, string(TokenTypeAccessToken), "Token forwarded to the upstream for authenticated requests, one of 'access_token', 'id_token', 'exchanged' (RFC 8693 token exchange of the access token) or 'none'.")
+}
+
+// ParseUpstream parses the address of an upstream. Addresses without a scheme, e.g. 'host:port', are treated as
+// 'http://host:port'. Supported schemes are 'http', 'https' and 'unix', e.g. 'unix:///path/to/socket'.
+func ParseUpstream(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = UpstreamSchemeHTTP + "://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing upstream %q: %w", raw, err)
+	}
+
+	switch u.Scheme {
+	case UpstreamSchemeHTTP, UpstreamSchemeHTTPS:
+		if len(u.Host) == 0 {
+			return nil, fmt.Errorf("upstream %q: missing host", raw)
+		}
+	case UpstreamSchemeUnix:
+		if len(u.Path) == 0 {
+			return nil, fmt.Errorf("upstream %q: missing socket path", raw)
+		}
+	default:
+		return nil, fmt.Errorf("upstream %q: unsupported scheme %q", raw, u.Scheme)
+	}
+
+	return u, nil
+}
+
+// UpstreamRoute maps requests matching a host and/or path prefix to an upstream, with per-route settings.
+type UpstreamRoute struct {
+	// Host matches the host of the request, without the port. Empty matches all hosts.
+	Host string `json:"host,omitempty"`
+	// PathPrefix matches the path of the request on segment boundaries, e.g. '/api' matches '/api' and '/api/users',
+	// but not '/apis'. Empty matches all paths.
+	PathPrefix string `json:"path-prefix,omitempty"`
+	// Upstream is the address of the upstream, see ParseUpstream.
+	Upstream string `json:"upstream"`
+	// TLS overrides the global upstream TLS settings for 'https://' upstreams.
+	TLS *UpstreamTLS `json:"tls,omitempty"`
+	// Token overrides the global upstream token settings.
+	Token *UpstreamToken `json:"token,omitempty"`
+	// AutoLogin overrides the global auto-login setting for requests matching this route.
+	AutoLogin *bool `json:"auto-login,omitempty"`
+	// ResponseHeaderTimeout is the maximum time to wait for the upstream's response headers. Zero means no timeout.
+	ResponseHeaderTimeout Duration `json:"response-header-timeout,omitempty"`
+}
+
+func (in UpstreamRoute) Validate() error {
+	if len(in.Upstream) == 0 {
+		return fmt.Errorf("missing 'upstream'")
+	}
+
+	if _, err := ParseUpstream(in.Upstream); err != nil {
+		return err
+	}
+
+	if in.TLS != nil {
+		if err := in.TLS.Validate(); err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+	}
+
+	if in.Token != nil {
+		if err := in.Token.Validate(); err != nil {
+			return fmt.Errorf("token: %w", err)
+		}
+	}
+
+	if len(in.Host) == 0 && len(in.PathPrefix) == 0 {
+		return fmt.Errorf("at least one of 'host' or 'path-prefix' must be set")
+	}
+
+	if len(in.PathPrefix) > 0 && !strings.HasPrefix(in.PathPrefix, "/") {
+		return fmt.Errorf("'path-prefix' must start with '/', was %q", in.PathPrefix)
+	}
+
+	if in.ResponseHeaderTimeout < 0 {
+		return fmt.Errorf("'response-header-timeout' must be non-negative")
+	}
+
+	return nil
+}
+
+// ParseUpstreamRoutes parses the given JSON array of upstream routes.
+func ParseUpstreamRoutes(raw string) ([]UpstreamRoute, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	// Unknown fields are rejected so that misspelled or removed settings, e.g. 'inject-token', are not silently ignored.
+	var routes []UpstreamRoute
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&routes); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", UpstreamRoutes, err)
+	}
+
+	for i, route := range routes {
+		if err := route.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: route %d: %w", UpstreamRoutes, i, err)
+		}
+	}
+
+	return routes, nil
+}
+
+// Duration is a time.Duration that is represented as a string in JSON, e.g. "30s".
+type Duration time.Duration
+
+func (in *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. \"30s\": %w", err)
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*in = Duration(d)
+	return nil
+}
+
+func (in Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(in).String())
+}
@@ -1,21 +1,58 @@
 package cookie
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/nais/wonderwall/pkg/crypto"
 )
 
 const (
-	Session     = "io.nais.wonderwall.session"
+	// Prefix is the common prefix for the names of all cookies set by Wonderwall.
+	Prefix = "io.nais.wonderwall."
+
+	Session = "io.nais.wonderwall.session"
+	// Login and LoginLegacy are the names of login cookies set by older versions. Login cookies are now keyed by the
+	// login flow's state, see LoginName and LoginLegacyName.
 	Login       = "io.nais.wonderwall.callback"
 	LoginLegacy = "io.nais.wonderwall.callback.legacy"
+	Logout      = "io.nais.wonderwall.logout"
 	Retry       = "io.nais.wonderwall.retry"
 )
 
+// LoginName returns the name of the login cookie for the login flow with the given state, so that parallel login flows,
+// e.g. in multiple browser tabs, do not overwrite each other's cookies.
+func LoginName(state string) string {
+	return Login + "." + loginKey(state)
+}
+
+// LoginLegacyName returns the name of the login cookie without the SameSite attribute for the login flow with the given
+// state.
+func LoginLegacyName(state string) string {
+	return LoginLegacy + "." + loginKey(state)
+}
+
+// IsLogin returns true if the given cookie name is the name of a login cookie, keyed or not.
+func IsLogin(name string) bool {
+	return name == Login || name == LoginLegacy || strings.HasPrefix(name, Login+".")
+}
+
+// IsLoginLegacy returns true if the given cookie name is the name of a login cookie without the SameSite attribute.
+func IsLoginLegacy(name string) bool {
+	return name == LoginLegacy || strings.HasPrefix(name, LoginLegacy+".")
+}
+
+// loginKey returns a short key for the given state that is safe to use in cookie names.
+func loginKey(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:8])
+}
+
 type Cookie struct {
 	*http.Cookie
 }
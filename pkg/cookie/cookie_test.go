@@ -3,6 +3,7 @@ package cookie_test
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -194,3 +195,23 @@ func TestCookie_Decrypt(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, value, plaintext)
 }
+
+func TestLoginName(t *testing.T) {
+	name := cookie.LoginName("some-state")
+	legacyName := cookie.LoginLegacyName("some-state")
+
+	assert.Equal(t, name, cookie.LoginName("some-state"))
+	assert.NotEqual(t, name, cookie.LoginName("other-state"))
+	assert.True(t, strings.HasPrefix(name, cookie.Login+"."))
+	assert.True(t, strings.HasPrefix(legacyName, cookie.LoginLegacy+"."))
+
+	assert.True(t, cookie.IsLogin(name))
+	assert.True(t, cookie.IsLogin(legacyName))
+	assert.True(t, cookie.IsLogin(cookie.Login))
+	assert.True(t, cookie.IsLogin(cookie.LoginLegacy))
+	assert.False(t, cookie.IsLogin(cookie.Session))
+
+	assert.False(t, cookie.IsLoginLegacy(name))
+	assert.True(t, cookie.IsLoginLegacy(legacyName))
+	assert.True(t, cookie.IsLoginLegacy(cookie.LoginLegacy))
+}
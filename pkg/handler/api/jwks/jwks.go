@@ -0,0 +1,74 @@
+package jwks
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+
+	"github.com/nais/wonderwall/pkg/jwt"
+	mw "github.com/nais/wonderwall/pkg/middleware"
+	openidconfig "github.com/nais/wonderwall/pkg/openid/config"
+)
+
+type Source interface {
+	GetOpenIDConfig() openidconfig.Config
+}
+
+// Handler publishes the public keys that identity providers should use when encrypting id_tokens to the client.
+func Handler(src Source, w http.ResponseWriter, r *http.Request) {
+	logger := mw.LogEntryFrom(r)
+
+	keys, err := PublicEncryptionKeys(src.GetOpenIDConfig().Client().IDTokenDecryptionKeys())
+	if err != nil {
+		logger.Warnf("jwks: creating public key set: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(keys)
+	if err != nil {
+		logger.Warnf("jwks: marshalling key set: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// PublicEncryptionKeys returns the public keys of the given set of decryption keys, marked for encryption use.
+// Algorithms that are not key encryption algorithms, e.g. when reusing a signing key, are removed.
+func PublicEncryptionKeys(keys jwk.Set) (jwk.Set, error) {
+	publicKeys, err := jwk.PublicSetOf(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < publicKeys.Len(); i++ {
+		key, ok := publicKeys.Key(i)
+		if !ok {
+			continue
+		}
+
+		if err := key.Set(jwk.KeyUsageKey, jwk.ForEncryption); err != nil {
+			return nil, err
+		}
+
+		if alg := key.Algorithm().String(); len(alg) > 0 && !isKeyEncryptionAlgorithm(alg) {
+			if err := key.Remove(jwk.AlgorithmKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return publicKeys, nil
+}
+
+func isKeyEncryptionAlgorithm(alg string) bool {
+	for _, accepted := range jwt.KeyEncryptionAlgorithms {
+		if alg == accepted.String() {
+			return true
+		}
+	}
+
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -19,6 +20,9 @@ import (
 
 const (
 	CookieLifetime = 1 * time.Hour
+	// MaxParallelLogins is the maximum number of login flows in progress at the same time for a user agent, e.g. in
+	// multiple browser tabs. The login cookies for the oldest flows are cleared when the limit is exceeded.
+	MaxParallelLogins = 3
 )
 
 type Source interface {
@@ -31,9 +35,16 @@ type Source interface {
 func Handler(src Source, w http.ResponseWriter, r *http.Request) {
 	login, err := src.GetClient().Login(r)
 	if err != nil {
-		if errors.Is(err, openidclient.ErrInvalidSecurityLevel) || errors.Is(err, openidclient.ErrInvalidLocale) {
+		switch {
+		case errors.Is(err, openidclient.ErrInvalidSecurityLevel),
+			errors.Is(err, openidclient.ErrInvalidLocale),
+			errors.Is(err, openidclient.ErrInvalidPrompt),
+			errors.Is(err, openidclient.ErrInvalidLoginHint),
+			errors.Is(err, openidclient.ErrInvalidDomainHint),
+			errors.Is(err, openidclient.ErrInvalidMaxAge),
+			errors.Is(err, openidclient.ErrInvalidPopup):
 			src.GetErrorHandler().BadRequest(w, r, err)
-		} else {
+		default:
 			src.GetErrorHandler().InternalError(w, r, err)
 		}
 
@@ -64,16 +75,37 @@ func setLoginCookies(src Source, w http.ResponseWriter, r *http.Request, loginCo
 		WithSameSite(http.SameSiteNoneMode)
 	value := string(loginCookieJson)
 
-	err = cookie.EncryptAndSet(w, cookie.Login, value, opts, src.GetCrypter())
+	clearOldestLoginCookies(w, r, opts)
+
+	err = cookie.EncryptAndSet(w, cookie.LoginName(loginCookie.State), value, opts, src.GetCrypter())
 	if err != nil {
 		return err
 	}
 
 	// set a duplicate cookie without the SameSite value set for user agents that do not properly handle SameSite
-	err = cookie.EncryptAndSet(w, cookie.LoginLegacy, value, opts.WithSameSite(http.SameSiteDefaultMode), src.GetCrypter())
+	err = cookie.EncryptAndSet(w, cookie.LoginLegacyName(loginCookie.State), value, opts.WithSameSite(http.SameSiteDefaultMode), src.GetCrypter())
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// clearOldestLoginCookies clears the login cookies for the oldest login flows in progress, so that there is room for a
+// new flow within MaxParallelLogins. User agents send older cookies first.
+func clearOldestLoginCookies(w http.ResponseWriter, r *http.Request, opts cookie.Options) {
+	names := make([]string, 0)
+	for _, c := range r.Cookies() {
+		if cookie.IsLogin(c.Name) && !cookie.IsLoginLegacy(c.Name) {
+			names = append(names, c.Name)
+		}
+	}
+
+	for i := 0; i <= len(names)-MaxParallelLogins; i++ {
+		name := names[i]
+		legacyName := cookie.LoginLegacy + strings.TrimPrefix(name, cookie.Login)
+
+		cookie.Clear(w, name, opts.WithSameSite(http.SameSiteNoneMode))
+		cookie.Clear(w, legacyName, opts.WithSameSite(http.SameSiteDefaultMode))
+	}
+}
@@ -1,10 +1,12 @@
 package logincallback
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/sethvargo/go-retry"
 	log "github.com/sirupsen/logrus"
@@ -13,6 +15,8 @@ import (
 	"github.com/nais/wonderwall/pkg/cookie"
 	"github.com/nais/wonderwall/pkg/crypto"
 	errorhandler "github.com/nais/wonderwall/pkg/handler/error"
+	"github.com/nais/wonderwall/pkg/handler/templates"
+	urlpkg "github.com/nais/wonderwall/pkg/handler/url"
 	"github.com/nais/wonderwall/pkg/loginstatus"
 	"github.com/nais/wonderwall/pkg/metrics"
 	logentry "github.com/nais/wonderwall/pkg/middleware"
@@ -34,10 +38,10 @@ type Source interface {
 }
 
 func Handler(src Source, w http.ResponseWriter, r *http.Request) {
-	// unconditionally clear login cookie
-	clearLoginCookies(src, w, r)
+	// unconditionally clear login cookies set by older versions
+	clearLoginCookies(src, w, r, cookie.Login, cookie.LoginLegacy)
 
-	loginCookie, err := openid.GetLoginCookie(r, src.GetCrypter())
+	loginCookies, err := openid.GetLoginCookies(r, src.GetCrypter())
 	if err != nil {
 		msg := "callback: fetching login cookie"
 		if errors.Is(err, http.ErrNoCookie) {
@@ -47,33 +51,43 @@ func Handler(src Source, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	loginCallback, err := src.GetClient().LoginCallback(r, loginCookie)
+	loginCallback, loginCookie, err := loginCallbackFor(src, r, loginCookies)
 	if err != nil {
+		if errors.Is(err, openidclient.ErrInvalidAuthorizationResponse) {
+			src.GetErrorHandler().Unauthorized(w, r, err)
+			return
+		}
+
 		src.GetErrorHandler().InternalError(w, r, err)
 		return
 	}
 
+	// only clear the cookies for this login flow, so that parallel flows in other tabs can complete
+	if loginCallback.StateMismatchError() == nil {
+		clearLoginCookies(src, w, r, cookie.LoginName(loginCookie.State), cookie.LoginLegacyName(loginCookie.State))
+	}
+
 	if err := loginCallback.IdentityProviderError(); err != nil {
-		src.GetErrorHandler().InternalError(w, r, fmt.Errorf("callback: %w", err))
+		fail(src, w, r, loginCookie, http.StatusInternalServerError, fmt.Errorf("callback: %w", err))
 		return
 	}
 
 	if err := loginCallback.StateMismatchError(); err != nil {
-		src.GetErrorHandler().Unauthorized(w, r, fmt.Errorf("callback: %w", err))
+		fail(src, w, r, loginCookie, http.StatusUnauthorized, fmt.Errorf("callback: %w", err))
 		return
 	}
 
 	tokens, err := redeemValidTokens(r, loginCallback)
 	if err != nil {
-		src.GetErrorHandler().InternalError(w, r, fmt.Errorf("callback: redeeming tokens: %w", err))
+		fail(src, w, r, loginCookie, http.StatusInternalServerError, fmt.Errorf("callback: redeeming tokens: %w", err))
 		return
 	}
 
 	sessionLifetime := src.GetSessionConfig().MaxLifetime
 
-	key, err := src.GetSessions().Create(r, tokens, sessionLifetime)
+	key, err := src.GetSessions().Create(r, tokens, loginCallback.ResponseParams(), sessionLifetime)
 	if err != nil {
-		src.GetErrorHandler().InternalError(w, r, fmt.Errorf("callback: creating session: %w", err))
+		fail(src, w, r, loginCookie, http.StatusInternalServerError, fmt.Errorf("callback: creating session: %w", err))
 		return
 	}
 
@@ -81,14 +95,14 @@ func Handler(src Source, w http.ResponseWriter, r *http.Request) {
 		WithExpiresIn(sessionLifetime)
 	err = cookie.EncryptAndSet(w, cookie.Session, key, opts, src.GetCrypter())
 	if err != nil {
-		src.GetErrorHandler().InternalError(w, r, fmt.Errorf("callback: setting session cookie: %w", err))
+		fail(src, w, r, loginCookie, http.StatusInternalServerError, fmt.Errorf("callback: setting session cookie: %w", err))
 		return
 	}
 
 	if src.GetLoginstatus().Enabled() {
 		tokenResponse, err := getLoginstatusToken(src, r, tokens)
 		if err != nil {
-			src.GetErrorHandler().InternalError(w, r, fmt.Errorf("callback: exchanging loginstatus token: %w", err))
+			fail(src, w, r, loginCookie, http.StatusInternalServerError, fmt.Errorf("callback: exchanging loginstatus token: %w", err))
 			return
 		}
 
@@ -98,13 +112,103 @@ func Handler(src Source, w http.ResponseWriter, r *http.Request) {
 
 	logSuccessfulLogin(r, tokens, loginCookie.Referer)
 	cookie.Clear(w, cookie.Retry, src.GetCookieOptsPathAware(r))
-	http.Redirect(w, r, loginCookie.Referer, http.StatusTemporaryRedirect)
+
+	if loginCookie.Popup {
+		respondPopup(w, r, http.StatusOK, loginCookie.Referer, "")
+		return
+	}
+
+	http.Redirect(w, r, loginCookie.Referer, urlpkg.RedirectStatus(r))
+}
+
+type PopupPage struct {
+	// Error is the error code for failed logins, as returned by errorhandler.ErrorCode, or empty if the login succeeded.
+	Error       string
+	Origin      string
+	RedirectURI string
+}
+
+// fail responds to a failed login callback with the given status code. For popup logins, the window that opened the
+// popup is notified of the failure instead, so that it does not have to wait for the popup to be closed.
+func fail(src Source, w http.ResponseWriter, r *http.Request, loginCookie *openid.LoginCookie, statusCode int, cause error) {
+	if !loginCookie.Popup {
+		if statusCode == http.StatusUnauthorized {
+			src.GetErrorHandler().Unauthorized(w, r, cause)
+		} else {
+			src.GetErrorHandler().InternalError(w, r, cause)
+		}
+		return
+	}
+
+	logentry.LogEntryFrom(r).WithField("status_code", statusCode).Warnf("error in route: %+v", cause)
+	respondPopup(w, r, statusCode, loginCookie.Referer, errorhandler.ErrorCode(statusCode))
+}
+
+// respondPopup serves a page that notifies the window that opened the login popup through postMessage, and then
+// closes the popup. The message is only delivered to windows with the origin of the redirect target if it is an
+// absolute URL, which has been validated against the allowed origins on login, or otherwise of the matching ingress.
+// The error code is empty for successful logins.
+func respondPopup(w http.ResponseWriter, r *http.Request, statusCode int, redirect, errorCode string) {
+	page := PopupPage{
+		Error:       errorCode,
+		RedirectURI: redirect,
+	}
+
+	if parsed, err := url.Parse(redirect); err == nil && parsed.IsAbs() && len(parsed.Host) > 0 {
+		page.Origin = parsed.Scheme + "://" + parsed.Host
+	} else if match, found := logentry.IngressFrom(r.Context()); found {
+		page.Origin = match.Scheme + "://" + match.Host()
+	}
+
+	var buf bytes.Buffer
+	err := templates.LoginPopupTemplate.Execute(&buf, page)
+	if err != nil {
+		logentry.LogEntryFrom(r).Errorf("callback: executing login popup template: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	_, _ = buf.WriteTo(w)
+}
+
+// loginCallbackFor returns the login callback for the login flow that the authorization response belongs to, i.e. the
+// first login cookie with a state matching the response. If no cookie matches, the callback for the first valid cookie is
+// returned, so that the state mismatch is reported.
+func loginCallbackFor(src Source, r *http.Request, loginCookies []*openid.LoginCookie) (*openidclient.LoginCallback, *openid.LoginCookie, error) {
+	var fallback *openidclient.LoginCallback
+	var fallbackCookie *openid.LoginCookie
+	var err error
+
+	for _, loginCookie := range loginCookies {
+		loginCallback, callbackErr := src.GetClient().LoginCallback(r, loginCookie)
+		if callbackErr != nil {
+			err = callbackErr
+			continue
+		}
+
+		if loginCallback.StateMismatchError() == nil {
+			return loginCallback, loginCookie, nil
+		}
+
+		if fallback == nil {
+			fallback, fallbackCookie = loginCallback, loginCookie
+		}
+	}
+
+	if fallback != nil {
+		return fallback, fallbackCookie, nil
+	}
+
+	return nil, nil, err
 }
 
-func clearLoginCookies(src Source, w http.ResponseWriter, r *http.Request) {
+func clearLoginCookies(src Source, w http.ResponseWriter, r *http.Request, name, legacyName string) {
 	opts := src.GetCookieOptsPathAware(r)
-	cookie.Clear(w, cookie.Login, opts.WithSameSite(http.SameSiteNoneMode))
-	cookie.Clear(w, cookie.LoginLegacy, opts.WithSameSite(http.SameSiteDefaultMode))
+	cookie.Clear(w, name, opts.WithSameSite(http.SameSiteNoneMode))
+	cookie.Clear(w, legacyName, opts.WithSameSite(http.SameSiteDefaultMode))
 }
 
 func redeemValidTokens(r *http.Request, loginCallback *openidclient.LoginCallback) (*openid.Tokens, error) {
@@ -4,11 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/nais/wonderwall/pkg/cookie"
+	"github.com/nais/wonderwall/pkg/crypto"
 	errorhandler "github.com/nais/wonderwall/pkg/handler/error"
+	urlpkg "github.com/nais/wonderwall/pkg/handler/url"
 	"github.com/nais/wonderwall/pkg/loginstatus"
 	"github.com/nais/wonderwall/pkg/metrics"
 	logentry "github.com/nais/wonderwall/pkg/middleware"
@@ -16,10 +19,17 @@ import (
 	"github.com/nais/wonderwall/pkg/session"
 )
 
+const (
+	// RedirectCookieLifetime is the lifetime of the cookie that holds the redirect target after global logout. It only
+	// needs to outlive the round trip to the identity provider's end session endpoint.
+	RedirectCookieLifetime = 5 * time.Minute
+)
+
 type Source interface {
 	GetClient() *openidclient.Client
 	GetCookieOptions() cookie.Options
 	GetCookieOptsPathAware(r *http.Request) cookie.Options
+	GetCrypter() crypto.Crypter
 	GetErrorHandler() errorhandler.Handler
 	GetLoginstatus() *loginstatus.Loginstatus
 	GetSessions() *session.Handler
@@ -63,6 +73,20 @@ func Handler(src Source, w http.ResponseWriter, r *http.Request, opts Options) {
 	}
 
 	if opts.GlobalLogout {
+		// the redirect target is kept in a cookie until the identity provider redirects back to the logout callback.
+		// Any target from an earlier, abandoned logout is cleared so that it is not used for this logout.
+		if urlpkg.HasRedirect(r) {
+			redirect := urlpkg.CanonicalRedirect(r)
+			cookieOpts := src.GetCookieOptsPathAware(r).WithExpiresIn(RedirectCookieLifetime)
+			err := cookie.EncryptAndSet(w, cookie.Logout, redirect, cookieOpts, src.GetCrypter())
+			if err != nil {
+				src.GetErrorHandler().InternalError(w, r, fmt.Errorf("logout: setting redirect cookie: %w", err))
+				return
+			}
+		} else {
+			cookie.Clear(w, cookie.Logout, src.GetCookieOptsPathAware(r))
+		}
+
 		logger.Debug("logout: redirecting to identity provider for global/single-logout")
 		metrics.ObserveLogout(metrics.LogoutOperationSelfInitiated)
 		http.Redirect(w, r, logout.SingleLogoutURL(idToken), http.StatusTemporaryRedirect)
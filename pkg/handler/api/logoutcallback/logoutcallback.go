@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/nais/wonderwall/pkg/cookie"
+	"github.com/nais/wonderwall/pkg/crypto"
 	logentry "github.com/nais/wonderwall/pkg/middleware"
 	openidclient "github.com/nais/wonderwall/pkg/openid/client"
 )
@@ -11,11 +12,18 @@ import (
 type Source interface {
 	GetClient() *openidclient.Client
 	GetCookieOptsPathAware(r *http.Request) cookie.Options
+	GetCrypter() crypto.Crypter
 }
 
 func Handler(src Source, w http.ResponseWriter, r *http.Request) {
 	redirect := src.GetClient().LogoutCallback(r).PostLogoutRedirectURI()
 
+	// the redirect target from the logout request takes precedence, and was validated before it was set
+	if target, err := cookie.GetDecrypted(r, cookie.Logout, src.GetCrypter()); err == nil && len(target) > 0 {
+		redirect = target
+	}
+
+	cookie.Clear(w, cookie.Logout, src.GetCookieOptsPathAware(r))
 	cookie.Clear(w, cookie.Retry, src.GetCookieOptsPathAware(r))
 	logentry.LogEntryFrom(r).Debugf("logout/callback: redirecting to %s", redirect)
 	http.Redirect(w, r, redirect, http.StatusTemporaryRedirect)
@@ -0,0 +1,36 @@
+package sessioncheck
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/nais/wonderwall/pkg/handler/templates"
+	mw "github.com/nais/wonderwall/pkg/middleware"
+	"github.com/nais/wonderwall/pkg/router/paths"
+)
+
+type Source interface {
+	GetPath(r *http.Request) string
+}
+
+type Page struct {
+	StatusURL string
+}
+
+// Handler serves the relying party iframe for OpenID Connect Session Management 1.0. The page is meant to be embedded
+// in a hidden iframe by the frontend, and notifies its parent window through postMessage whenever the end-user's
+// session at the identity provider has changed.
+func Handler(src Source, w http.ResponseWriter, r *http.Request) {
+	page := Page{
+		StatusURL: path.Join(src.GetPath(r), paths.OAuth2, paths.SessionStatus),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// only allow embedding from the same origin
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'self'")
+
+	err := templates.SessionCheckTemplate.Execute(w, page)
+	if err != nil {
+		mw.LogEntryFrom(r).Errorf("session/check: executing template: %+v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package sessionclaims
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/nais/wonderwall/pkg/config"
+	mw "github.com/nais/wonderwall/pkg/middleware"
+	"github.com/nais/wonderwall/pkg/session"
+)
+
+type Source interface {
+	GetSessions() *session.Handler
+	GetSessionConfig() config.Session
+}
+
+// Handler returns the claims from the id_token and userinfo for the current user's session, limited to the claims in
+// the configured allow-list. Claims that are not present are omitted.
+func Handler(src Source, w http.ResponseWriter, r *http.Request) {
+	logger := mw.LogEntryFrom(r)
+
+	data, err := src.GetSessions().Get(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, session.ErrCookieNotFound), errors.Is(err, session.ErrKeyNotFound), errors.Is(err, session.ErrSessionInactive):
+			logger.Infof("session/claims: getting session: %+v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			logger.Warnf("session/claims: getting session: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	claims, err := data.Claims(r.Context())
+	if err != nil {
+		logger.Warnf("session/claims: getting claims: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	allowed := make(map[string]any)
+	for _, claim := range src.GetSessionConfig().Claims {
+		if value, found := claims[claim]; found {
+			allowed[claim] = value
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(allowed)
+	if err != nil {
+		logger.Warnf("session/claims: marshalling claims: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
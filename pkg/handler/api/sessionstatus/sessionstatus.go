@@ -0,0 +1,97 @@
+package sessionstatus
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/nais/wonderwall/pkg/config"
+	mw "github.com/nais/wonderwall/pkg/middleware"
+	openidconfig "github.com/nais/wonderwall/pkg/openid/config"
+	"github.com/nais/wonderwall/pkg/router/paths"
+	"github.com/nais/wonderwall/pkg/session"
+)
+
+type Source interface {
+	GetOpenIDConfig() openidconfig.Config
+	GetPath(r *http.Request) string
+	GetSessions() *session.Handler
+	GetSessionConfig() config.Session
+}
+
+// Status contains the parameters needed by a relying party iframe to perform session checks against the identity
+// provider's check_session_iframe, see OpenID Connect Session Management 1.0, section 3.
+type Status struct {
+	CheckSessionIframe string `json:"check_session_iframe"`
+	ClientID           string `json:"client_id"`
+	IntervalSeconds    int64  `json:"interval_seconds"`
+	LoginURL           string `json:"login_url"`
+	LogoutURL          string `json:"logout_url"`
+	OnChange           string `json:"on_change"`
+	Origin             string `json:"origin"`
+	SessionState       string `json:"session_state"`
+}
+
+func Handler(src Source, w http.ResponseWriter, r *http.Request) {
+	logger := mw.LogEntryFrom(r)
+
+	data, err := src.GetSessions().Get(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, session.ErrCookieNotFound), errors.Is(err, session.ErrKeyNotFound), errors.Is(err, session.ErrSessionInactive):
+			logger.Infof("session/status: getting session: %+v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			logger.Warnf("session/status: getting session: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	checkSessionIframe := src.GetOpenIDConfig().Provider().CheckSessionIframe()
+	origin, err := originOf(checkSessionIframe)
+	if err != nil {
+		logger.Warnf("session/status: parsing check_session_iframe: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	prefix := src.GetPath(r)
+	cfg := src.GetSessionConfig()
+
+	status := Status{
+		CheckSessionIframe: checkSessionIframe,
+		ClientID:           src.GetOpenIDConfig().Client().ClientID(),
+		IntervalSeconds:    int64(cfg.CheckInterval.Seconds()),
+		LoginURL:           path.Join(prefix, paths.OAuth2, paths.Login),
+		LogoutURL:          path.Join(prefix, paths.OAuth2, paths.LogoutLocal),
+		OnChange:           cfg.CheckOnChange,
+		Origin:             origin,
+		SessionState:       data.SessionState,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(status)
+	if err != nil {
+		logger.Warnf("session/status: marshalling status: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// originOf returns the origin of the given URL, i.e. the scheme and host.
+func originOf(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+
+	origin := url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+	}
+
+	return origin.String(), nil
+}
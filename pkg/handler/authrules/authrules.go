@@ -0,0 +1,41 @@
+package authrules
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/handler/url"
+)
+
+type Rules struct {
+	rules []config.AuthRule
+}
+
+// Match returns the mode of the first rule with a pattern matching the given request's path.
+func (in *Rules) Match(r *http.Request) (config.AuthMode, bool) {
+	for _, rule := range in.rules {
+		if url.MatchPath(rule.Path, r) {
+			return rule.Mode, true
+		}
+	}
+
+	return "", false
+}
+
+func New(cfg *config.Config) (*Rules, error) {
+	rules, err := config.ParseAuthRules(cfg.AuthRules)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rule := range rules {
+		if rule.Path != "/" {
+			rules[i].Path = strings.TrimSuffix(rule.Path, "/")
+		}
+	}
+
+	return &Rules{
+		rules: rules,
+	}, nil
+}
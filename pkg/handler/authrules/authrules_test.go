@@ -0,0 +1,117 @@
+package authrules_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/handler/authrules"
+)
+
+func TestRules_Match(t *testing.T) {
+	rules, err := authrules.New(&config.Config{
+		AuthRules: `[
+			{"path": "/api/public/**", "mode": "passthrough"},
+			{"path": "/api/**", "mode": "deny"},
+			{"path": "/admin/", "mode": "redirect"},
+			{"path": "/**", "mode": "passthrough"}
+		]`,
+	})
+	assert.NoError(t, err)
+
+	for _, test := range []struct {
+		name   string
+		method string
+		path   string
+		want   config.AuthMode
+	}{
+		{
+			name: "first matching rule wins",
+			path: "/api/public/docs",
+			want: config.AuthModePassthrough,
+		},
+		{
+			name: "later rule matches when earlier rules do not",
+			path: "/api/users",
+			want: config.AuthModeDeny,
+		},
+		{
+			name:   "rules apply to all methods",
+			method: http.MethodPost,
+			path:   "/api/users",
+			want:   config.AuthModeDeny,
+		},
+		{
+			name:   "rules apply to unsafe methods",
+			method: http.MethodDelete,
+			path:   "/api/users/1",
+			want:   config.AuthModeDeny,
+		},
+		{
+			name: "trailing slash in pattern is ignored",
+			path: "/admin",
+			want: config.AuthModeRedirect,
+		},
+		{
+			name: "trailing slash in path is ignored",
+			path: "/admin/",
+			want: config.AuthModeRedirect,
+		},
+		{
+			name: "dot segments are resolved before matching",
+			path: "/api/public/../users",
+			want: config.AuthModeDeny,
+		},
+		{
+			name: "repeated slashes are resolved before matching",
+			path: "/api//public/docs",
+			want: config.AuthModePassthrough,
+		},
+		{
+			name: "catch-all rule",
+			path: "/index.html",
+			want: config.AuthModePassthrough,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if len(method) == 0 {
+				method = http.MethodGet
+			}
+
+			r := httptest.NewRequest(method, "/", nil)
+			r.URL.Path = test.path
+
+			mode, found := rules.Match(r)
+			assert.True(t, found)
+			assert.Equal(t, test.want, mode)
+		})
+	}
+}
+
+func TestRules_Match_NoRules(t *testing.T) {
+	rules, err := authrules.New(&config.Config{})
+	assert.NoError(t, err)
+
+	mode, found := rules.Match(httptest.NewRequest(http.MethodGet, "/api", nil))
+	assert.False(t, found)
+	assert.Empty(t, mode)
+}
+
+func TestRules_Match_NoMatchingRule(t *testing.T) {
+	rules, err := authrules.New(&config.Config{
+		AuthRules: `[{"path": "/api/**", "mode": "deny"}]`,
+	})
+	assert.NoError(t, err)
+
+	for _, path := range []string{"/apis", "/", "/api/../other"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path = path
+
+		_, found := rules.Match(r)
+		assert.False(t, found, path)
+	}
+}
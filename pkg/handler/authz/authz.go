@@ -0,0 +1,135 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/handler/url"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+type Policies struct {
+	policies []config.AuthorizationPolicy
+}
+
+// Applies returns true if any policy matches the given request's path and method.
+func (in *Policies) Applies(r *http.Request) bool {
+	for _, policy := range in.policies {
+		if matches(policy, r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authorize evaluates all policies matching the given request against the given claims. An error wrapping
+// ErrForbidden is returned for the first policy that is not satisfied.
+func (in *Policies) Authorize(r *http.Request, claims map[string]any) error {
+	for _, policy := range in.policies {
+		if !matches(policy, r) {
+			continue
+		}
+
+		for claim, accepted := range policy.Claims {
+			if !hasAnyValue(claims[claim], accepted) {
+				return fmt.Errorf("%w: policy for %q: claim %q does not have any of %q", ErrForbidden, policy.Path, claim, accepted)
+			}
+		}
+
+		if len(policy.EmailDomains) > 0 && !hasEmailDomain(claims["email"], policy.EmailDomains) {
+			return fmt.Errorf("%w: policy for %q: claim \"email\" does not have any of the domains %q", ErrForbidden, policy.Path, policy.EmailDomains)
+		}
+	}
+
+	return nil
+}
+
+func New(cfg *config.Config) (*Policies, error) {
+	policies, err := config.ParseAuthorizationPolicies(cfg.AuthorizationPolicies)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, policy := range policies {
+		if policy.Path != "/" {
+			policies[i].Path = strings.TrimSuffix(policy.Path, "/")
+		}
+	}
+
+	return &Policies{
+		policies: policies,
+	}, nil
+}
+
+func matches(policy config.AuthorizationPolicy, r *http.Request) bool {
+	if !url.MatchPath(policy.Path, r) {
+		return false
+	}
+
+	if len(policy.Methods) == 0 {
+		return true
+	}
+
+	for _, method := range policy.Methods {
+		if strings.EqualFold(method, r.Method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasAnyValue returns true if the given claim value is one of the accepted values. Array claims, e.g. 'groups' or
+// 'roles', must contain at least one of the accepted values.
+func hasAnyValue(value any, accepted []string) bool {
+	var values []any
+	switch v := value.(type) {
+	case []any:
+		values = v
+	case []string:
+		for _, s := range v {
+			values = append(values, s)
+		}
+	default:
+		values = []any{v}
+	}
+
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+
+		for _, a := range accepted {
+			if fmt.Sprint(v) == a {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func hasEmailDomain(value any, domains []string) bool {
+	email, ok := value.(string)
+	if !ok {
+		return false
+	}
+
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+
+	for _, domain := range domains {
+		if strings.EqualFold(email[i+1:], domain) {
+			return true
+		}
+	}
+
+	return false
+}
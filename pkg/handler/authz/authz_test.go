@@ -0,0 +1,154 @@
+package authz_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/handler/authz"
+)
+
+func TestPolicies_Authorize(t *testing.T) {
+	policies, err := authz.New(&config.Config{
+		AuthorizationPolicies: `[
+			{"path": "/admin/**", "claims": {"groups": ["admins"]}},
+			{"path": "/api/**", "methods": ["POST", "delete"], "claims": {"roles": ["writer"]}},
+			{"path": "/api/**", "claims": {"acr": ["Level4"]}},
+			{"path": "/internal/**", "email-domains": ["example.com"]}
+		]`,
+	})
+	assert.NoError(t, err)
+
+	for _, test := range []struct {
+		name    string
+		method  string
+		path    string
+		claims  map[string]any
+		allowed bool
+	}{
+		{
+			name:    "array claim contains accepted value",
+			path:    "/admin/users",
+			claims:  map[string]any{"groups": []any{"users", "admins"}},
+			allowed: true,
+		},
+		{
+			name:    "string array claim contains accepted value",
+			path:    "/admin/users",
+			claims:  map[string]any{"groups": []string{"admins"}},
+			allowed: true,
+		},
+		{
+			name:   "array claim without accepted value",
+			path:   "/admin/users",
+			claims: map[string]any{"groups": []any{"users"}},
+		},
+		{
+			name:   "empty array claim",
+			path:   "/admin/users",
+			claims: map[string]any{"groups": []any{}},
+		},
+		{
+			name:   "missing claim",
+			path:   "/admin/users",
+			claims: map[string]any{"sub": "some-subject"},
+		},
+		{
+			name: "no claims",
+			path: "/admin/users",
+		},
+		{
+			name:   "dot segments are resolved before matching",
+			path:   "/public/../admin/users",
+			claims: map[string]any{"groups": []any{"users"}},
+		},
+		{
+			name:    "policy for other methods does not apply",
+			method:  http.MethodGet,
+			path:    "/api/items",
+			claims:  map[string]any{"acr": "Level4"},
+			allowed: true,
+		},
+		{
+			name:   "policy for method applies",
+			method: http.MethodPost,
+			path:   "/api/items",
+			claims: map[string]any{"acr": "Level4"},
+		},
+		{
+			name:    "methods are case-insensitive",
+			method:  http.MethodDelete,
+			path:    "/api/items/1",
+			claims:  map[string]any{"acr": "Level4", "roles": []any{"writer"}},
+			allowed: true,
+		},
+		{
+			name:   "all matching policies must be satisfied",
+			method: http.MethodPost,
+			path:   "/api/items",
+			claims: map[string]any{"acr": "Level3", "roles": []any{"writer"}},
+		},
+		{
+			name:    "accepted email domain",
+			path:    "/internal/reports",
+			claims:  map[string]any{"email": "user@Example.com"},
+			allowed: true,
+		},
+		{
+			name:   "other email domain",
+			path:   "/internal/reports",
+			claims: map[string]any{"email": "user@example.com.evil"},
+		},
+		{
+			name:   "missing email claim",
+			path:   "/internal/reports",
+			claims: map[string]any{},
+		},
+		{
+			name:    "no matching policy",
+			path:    "/public",
+			allowed: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if len(method) == 0 {
+				method = http.MethodGet
+			}
+
+			r := httptest.NewRequest(method, "/", nil)
+			r.URL.Path = test.path
+
+			err := policies.Authorize(r, test.claims)
+			if test.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, authz.ErrForbidden)
+			}
+		})
+	}
+}
+
+func TestPolicies_Applies(t *testing.T) {
+	policies, err := authz.New(&config.Config{
+		AuthorizationPolicies: `[{"path": "/api/**", "methods": ["POST"], "claims": {"roles": ["writer"]}}]`,
+	})
+	assert.NoError(t, err)
+
+	for _, test := range []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{method: http.MethodPost, path: "/api/items", want: true},
+		{method: http.MethodGet, path: "/api/items", want: false},
+		{method: http.MethodPost, path: "/other", want: false},
+	} {
+		r := httptest.NewRequest(test.method, "/", nil)
+		r.URL.Path = test.path
+		assert.Equal(t, test.want, policies.Applies(r), test.method+" "+test.path)
+	}
+}
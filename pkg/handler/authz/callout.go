@@ -0,0 +1,200 @@
+package authz
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/handler/url"
+)
+
+const (
+	// maxCacheEntries limits the number of cached decisions. Decisions are not cached when the limit is reached.
+	maxCacheEntries = 10_000
+)
+
+// Decision is the result from the external authorization service.
+type Decision struct {
+	Allow bool `json:"allow"`
+	// Headers are added to the request before it is proxied to the upstream.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type CalloutInput struct {
+	Method string `json:"method"`
+	Host   string `json:"host"`
+	// Path is the canonical request path, see url.CanonicalPath.
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	// Claims are the validated claims for the session or bearer token, or nil for unauthenticated requests.
+	Claims map[string]any `json:"claims"`
+}
+
+type calloutRequest struct {
+	Input CalloutInput `json:"input"`
+}
+
+type calloutResponse struct {
+	Result *Decision `json:"result"`
+}
+
+// Callout requests decisions from an external authorization service, similar to the data API in Open Policy Agent.
+type Callout struct {
+	config     config.AuthzCallout
+	httpClient *http.Client
+	cache      *decisionCache
+}
+
+func NewCallout(cfg *config.Config) *Callout {
+	return &Callout{
+		config: cfg.AuthzCallout,
+		httpClient: &http.Client{
+			Timeout: cfg.AuthzCallout.Timeout,
+		},
+		cache: &decisionCache{
+			entries: make(map[string]cacheEntry),
+			ttl:     cfg.AuthzCallout.CacheTTL,
+		},
+	}
+}
+
+func (c *Callout) Enabled() bool {
+	return len(c.config.URL) > 0
+}
+
+// Decide returns the decision for the given request and claims. Decisions are cached for identical inputs.
+func (c *Callout) Decide(ctx context.Context, r *http.Request, claims map[string]any) (*Decision, error) {
+	input := CalloutInput{
+		Method:  r.Method,
+		Host:    r.Host,
+		Path:    url.CanonicalPath(r),
+		Headers: make(map[string]string),
+		Claims:  claims,
+	}
+
+	for _, header := range c.config.Headers {
+		if value := r.Header.Get(header); len(value) > 0 {
+			input.Headers[http.CanonicalHeaderKey(header)] = value
+		}
+	}
+
+	body, err := json.Marshal(calloutRequest{Input: input})
+	if err != nil {
+		return nil, fmt.Errorf("marshalling input: %w", err)
+	}
+
+	sum := sha256.Sum256(body)
+	key := hex.EncodeToString(sum[:])
+
+	if decision, ok := c.cache.get(key); ok {
+		return decision, nil
+	}
+
+	decision, err := c.request(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.cache.set(key, decision)
+	return decision, nil
+}
+
+func (c *Callout) request(ctx context.Context, body []byte) (*Decision, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("performing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status: HTTP %d: %s", resp.StatusCode, respBody)
+	}
+
+	var response calloutResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("unmarshalling response: %w", err)
+	}
+
+	// an undefined result, e.g. from a policy that does not apply, is treated as a denial
+	if response.Result == nil {
+		return &Decision{Allow: false}, nil
+	}
+
+	return response.Result, nil
+}
+
+type cacheEntry struct {
+	decision  *Decision
+	expiresAt time.Time
+}
+
+type decisionCache struct {
+	lock    sync.Mutex
+	entries map[string]cacheEntry
+	ttl     time.Duration
+}
+
+func (c *decisionCache) get(key string) (*Decision, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return entry.decision, true
+}
+
+func (c *decisionCache) set(key string, decision *Decision) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+	}
+
+	if len(c.entries) >= maxCacheEntries {
+		return
+	}
+
+	c.entries[key] = cacheEntry{
+		decision:  decision,
+		expiresAt: now.Add(c.ttl),
+	}
+}
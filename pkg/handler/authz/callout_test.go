@@ -0,0 +1,163 @@
+package authz_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/handler/authz"
+)
+
+type stubPolicyService struct {
+	*httptest.Server
+	requests atomic.Int32
+	inputs   chan authz.CalloutInput
+}
+
+// newStubPolicyService returns a policy service that responds with the given body, and records the inputs it receives.
+func newStubPolicyService(t *testing.T, body string) *stubPolicyService {
+	s := &stubPolicyService{
+		inputs: make(chan authz.CalloutInput, 10),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+
+		var request struct {
+			Input authz.CalloutInput `json:"input"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		s.inputs <- request.Input
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func newCallout(url string, cacheTTL time.Duration) *authz.Callout {
+	return authz.NewCallout(&config.Config{
+		AuthzCallout: config.AuthzCallout{
+			URL:      url,
+			Timeout:  time.Second,
+			CacheTTL: cacheTTL,
+			Headers:  []string{"x-tenant"},
+		},
+	})
+}
+
+func TestCallout_Decide(t *testing.T) {
+	service := newStubPolicyService(t, `{"result": {"allow": true, "headers": {"X-User-Role": "admin"}}}`)
+	callout := newCallout(service.URL, 0)
+	assert.True(t, callout.Enabled())
+
+	r := httptest.NewRequest(http.MethodPost, "http://app.example/", nil)
+	r.URL.Path = "/public/../admin"
+	r.Header.Set("X-Tenant", "some-tenant")
+	r.Header.Set("Cookie", "some-cookie")
+
+	decision, err := callout.Decide(context.Background(), r, map[string]any{"sub": "some-subject"})
+	assert.NoError(t, err)
+	assert.True(t, decision.Allow)
+	assert.Equal(t, map[string]string{"X-User-Role": "admin"}, decision.Headers)
+
+	input := <-service.inputs
+	assert.Equal(t, http.MethodPost, input.Method)
+	assert.Equal(t, "app.example", input.Host)
+	assert.Equal(t, "/admin", input.Path)
+	assert.Equal(t, map[string]string{"X-Tenant": "some-tenant"}, input.Headers)
+	assert.Equal(t, map[string]any{"sub": "some-subject"}, input.Claims)
+}
+
+func TestCallout_Decide_Undefined(t *testing.T) {
+	for name, body := range map[string]string{
+		"missing result": `{}`,
+		"null result":    `{"result": null}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			service := newStubPolicyService(t, body)
+			callout := newCallout(service.URL, 0)
+
+			decision, err := callout.Decide(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil)
+			assert.NoError(t, err)
+			assert.False(t, decision.Allow)
+		})
+	}
+}
+
+func TestCallout_Decide_Errors(t *testing.T) {
+	t.Run("unexpected status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		_, err := newCallout(server.URL, 0).Decide(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("malformed response", func(t *testing.T) {
+		service := newStubPolicyService(t, `not json`)
+
+		_, err := newCallout(service.URL, 0).Decide(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestCallout_Decide_Cache(t *testing.T) {
+	decide := func(t *testing.T, callout *authz.Callout, path string, claims map[string]any) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path = path
+
+		decision, err := callout.Decide(context.Background(), r, claims)
+		assert.NoError(t, err)
+		assert.True(t, decision.Allow)
+	}
+
+	t.Run("identical inputs are cached", func(t *testing.T) {
+		service := newStubPolicyService(t, `{"result": {"allow": true}}`)
+		callout := newCallout(service.URL, time.Minute)
+
+		decide(t, callout, "/admin", map[string]any{"sub": "some-subject"})
+		decide(t, callout, "/admin", map[string]any{"sub": "some-subject"})
+		assert.Equal(t, int32(1), service.requests.Load())
+	})
+
+	t.Run("cache key includes claims", func(t *testing.T) {
+		service := newStubPolicyService(t, `{"result": {"allow": true}}`)
+		callout := newCallout(service.URL, time.Minute)
+
+		decide(t, callout, "/admin", map[string]any{"sub": "some-subject"})
+		decide(t, callout, "/admin", map[string]any{"sub": "other-subject"})
+		decide(t, callout, "/admin", map[string]any{"sub": "some-subject", "groups": []any{"admins"}})
+		decide(t, callout, "/admin", nil)
+		assert.Equal(t, int32(4), service.requests.Load())
+	})
+
+	t.Run("cache key includes path", func(t *testing.T) {
+		service := newStubPolicyService(t, `{"result": {"allow": true}}`)
+		callout := newCallout(service.URL, time.Minute)
+
+		decide(t, callout, "/admin", nil)
+		decide(t, callout, "/other", nil)
+		assert.Equal(t, int32(2), service.requests.Load())
+	})
+
+	t.Run("disabled without ttl", func(t *testing.T) {
+		service := newStubPolicyService(t, `{"result": {"allow": true}}`)
+		callout := newCallout(service.URL, 0)
+
+		decide(t, callout, "/admin", nil)
+		decide(t, callout, "/admin", nil)
+		assert.Equal(t, int32(2), service.requests.Load())
+	})
+}
@@ -1,12 +1,12 @@
 package autologin
 
 import (
+	"net"
 	"net/http"
 	"strings"
 
-	"github.com/bmatcuk/doublestar/v4"
-
 	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/handler/url"
 )
 
 var DefaultIgnorePatterns = []string{
@@ -14,56 +14,142 @@ var DefaultIgnorePatterns = []string{
 	"/robots.txt",
 }
 
+var DefaultMethods = []string{
+	http.MethodGet,
+}
+
 type AutoLogin struct {
-	Enabled        bool
-	IgnorePatterns []string
+	Enabled         bool
+	IgnorePatterns  []Pattern
+	IncludePatterns []Pattern
+	Methods         []string
+}
+
+// Pattern is a glob-style path pattern, optionally scoped to a single host.
+type Pattern struct {
+	// Host is the host without port that the pattern applies to. Empty means all hosts.
+	Host string
+	Path string
+}
+
+// ParsePattern parses the given pattern. Patterns that do not start with '/' are scoped to the host preceding the
+// first '/', e.g. 'www.example.com/public/**'.
+func ParsePattern(raw string) Pattern {
+	var p Pattern
+
+	if !strings.HasPrefix(raw, "/") {
+		host, path, _ := strings.Cut(raw, "/")
+		p.Host = strings.ToLower(host)
+		raw = "/" + path
+	}
+
+	if raw != "/" {
+		raw = strings.TrimSuffix(raw, "/")
+	}
+
+	p.Path = raw
+	return p
+}
+
+func (p Pattern) Matches(r *http.Request) bool {
+	if len(p.Host) > 0 && !strings.EqualFold(p.Host, hostWithoutPort(r.Host)) {
+		return false
+	}
+
+	return url.MatchPath(p.Path, r)
 }
 
+// NeedsLogin returns true if the given unauthenticated request should be redirected to login. Ignore patterns take
+// precedence over include patterns. If there are include patterns, only requests matching any of them need login.
 func (a *AutoLogin) NeedsLogin(r *http.Request, isAuthenticated bool) bool {
-	if isAuthenticated || !a.Enabled || r.Method != http.MethodGet {
+	if isAuthenticated || !a.Enabled || !a.matchesMethod(r) {
 		return false
 	}
 
 	for _, pattern := range a.IgnorePatterns {
-		path := r.URL.Path
-		if !strings.HasPrefix(path, "/") {
-			path = "/" + path
+		if pattern.Matches(r) {
+			return false
 		}
+	}
 
-		if path != "/" {
-			path = strings.TrimSuffix(path, "/")
+	if len(a.IncludePatterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range a.IncludePatterns {
+		if pattern.Matches(r) {
+			return true
 		}
+	}
 
-		match, _ := doublestar.Match(pattern, path)
-		if match {
-			return false
+	return false
+}
+
+// WithEnabled returns a copy of the AutoLogin with the given enabled state, e.g. to override the global setting.
+func (a *AutoLogin) WithEnabled(enabled bool) *AutoLogin {
+	return &AutoLogin{
+		Enabled:         enabled,
+		IgnorePatterns:  a.IgnorePatterns,
+		IncludePatterns: a.IncludePatterns,
+		Methods:         a.Methods,
+	}
+}
+
+func (a *AutoLogin) matchesMethod(r *http.Request) bool {
+	for _, method := range a.Methods {
+		if strings.EqualFold(method, r.Method) {
+			return true
 		}
 	}
 
-	return true
+	return false
 }
 
 func New(cfg *config.Config) (*AutoLogin, error) {
-	seen := make(map[string]bool)
-	patterns := make([]string, 0)
+	methods := make([]string, 0)
+	for _, method := range cfg.AutoLoginMethods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if len(method) > 0 {
+			methods = append(methods, method)
+		}
+	}
+
+	if len(methods) == 0 {
+		methods = DefaultMethods
+	}
 
-	for _, path := range append(DefaultIgnorePatterns, cfg.AutoLoginIgnorePaths...) {
+	return &AutoLogin{
+		Enabled:         cfg.AutoLogin,
+		IgnorePatterns:  parsePatterns(append(DefaultIgnorePatterns, cfg.AutoLoginIgnorePaths...)),
+		IncludePatterns: parsePatterns(cfg.AutoLoginIncludePaths),
+		Methods:         methods,
+	}, nil
+}
+
+func parsePatterns(raw []string) []Pattern {
+	seen := make(map[Pattern]bool)
+	patterns := make([]Pattern, 0)
+
+	for _, path := range raw {
 		if len(path) == 0 {
 			continue
 		}
 
-		if path != "/" {
-			path = strings.TrimSuffix(path, "/")
+		pattern := ParsePattern(path)
+		if _, found := seen[pattern]; !found {
+			seen[pattern] = true
+			patterns = append(patterns, pattern)
 		}
+	}
 
-		if _, found := seen[path]; !found {
-			seen[path] = true
-			patterns = append(patterns, path)
-		}
+	return patterns
+}
+
+func hostWithoutPort(host string) string {
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
 	}
 
-	return &AutoLogin{
-		Enabled:        cfg.AutoLogin,
-		IgnorePatterns: patterns,
-	}, nil
+	return h
 }
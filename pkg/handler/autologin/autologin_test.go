@@ -0,0 +1,143 @@
+package autologin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/handler/autologin"
+)
+
+func TestAutoLogin_NeedsLogin(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		cfg    config.Config
+		method string
+		url    string
+		authed bool
+		want   bool
+	}{
+		{
+			name: "disabled",
+			url:  "http://www.example.com/",
+		},
+		{
+			name: "enabled",
+			cfg:  config.Config{AutoLogin: true},
+			url:  "http://www.example.com/",
+			want: true,
+		},
+		{
+			name:   "authenticated",
+			cfg:    config.Config{AutoLogin: true},
+			url:    "http://www.example.com/",
+			authed: true,
+		},
+		{
+			name: "default ignore pattern",
+			cfg:  config.Config{AutoLogin: true},
+			url:  "http://www.example.com/favicon.ico",
+		},
+		{
+			name:   "post is ignored by default",
+			cfg:    config.Config{AutoLogin: true},
+			method: http.MethodPost,
+			url:    "http://www.example.com/",
+		},
+		{
+			name:   "configured methods",
+			cfg:    config.Config{AutoLogin: true, AutoLoginMethods: []string{"get", "post"}},
+			method: http.MethodPost,
+			url:    "http://www.example.com/",
+			want:   true,
+		},
+		{
+			name: "ignore pattern",
+			cfg:  config.Config{AutoLogin: true, AutoLoginIgnorePaths: []string{"/public/**"}},
+			url:  "http://www.example.com/public/page",
+		},
+		{
+			name: "host-scoped ignore pattern",
+			cfg:  config.Config{AutoLogin: true, AutoLoginIgnorePaths: []string{"www.example.com/public/**"}},
+			url:  "http://www.example.com:8080/public/page",
+		},
+		{
+			name: "host-scoped ignore pattern for other host",
+			cfg:  config.Config{AutoLogin: true, AutoLoginIgnorePaths: []string{"www.example.com/public/**"}},
+			url:  "http://admin.example.com/public/page",
+			want: true,
+		},
+		{
+			name: "include pattern",
+			cfg:  config.Config{AutoLogin: true, AutoLoginIncludePaths: []string{"/minside/**"}},
+			url:  "http://www.example.com/minside/page",
+			want: true,
+		},
+		{
+			name: "include pattern with dot segments",
+			cfg:  config.Config{AutoLogin: true, AutoLoginIncludePaths: []string{"/minside/**"}},
+			url:  "http://www.example.com/public/../minside/page",
+			want: true,
+		},
+		{
+			name: "include pattern with encoded dot segments",
+			cfg:  config.Config{AutoLogin: true, AutoLoginIncludePaths: []string{"/minside/**"}},
+			url:  "http://www.example.com/public/%2e%2e/minside/page",
+			want: true,
+		},
+		{
+			name: "include pattern with repeated slashes",
+			cfg:  config.Config{AutoLogin: true, AutoLoginIncludePaths: []string{"/minside/**"}},
+			url:  "http://www.example.com//minside//page",
+			want: true,
+		},
+		{
+			name: "ignore pattern with dot segments",
+			cfg:  config.Config{AutoLogin: true, AutoLoginIgnorePaths: []string{"/public/**"}},
+			url:  "http://www.example.com/public/../minside/page",
+			want: true,
+		},
+		{
+			name: "path not matching include pattern",
+			cfg:  config.Config{AutoLogin: true, AutoLoginIncludePaths: []string{"/minside/**"}},
+			url:  "http://www.example.com/about",
+		},
+		{
+			name: "host-scoped include pattern for other host",
+			cfg:  config.Config{AutoLogin: true, AutoLoginIncludePaths: []string{"www.example.com/minside/**"}},
+			url:  "http://admin.example.com/minside/page",
+		},
+		{
+			name: "ignore pattern takes precedence over include pattern",
+			cfg: config.Config{
+				AutoLogin:             true,
+				AutoLoginIgnorePaths:  []string{"/minside/public/**"},
+				AutoLoginIncludePaths: []string{"/minside/**"},
+			},
+			url: "http://www.example.com/minside/public/page",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			autoLogin, err := autologin.New(&test.cfg)
+			assert.NoError(t, err)
+
+			method := test.method
+			if len(method) == 0 {
+				method = http.MethodGet
+			}
+
+			r := httptest.NewRequest(method, test.url, nil)
+			assert.Equal(t, test.want, autoLogin.NeedsLogin(r, test.authed))
+		})
+	}
+}
+
+func TestParsePattern(t *testing.T) {
+	assert.Equal(t, autologin.Pattern{Path: "/"}, autologin.ParsePattern("/"))
+	assert.Equal(t, autologin.Pattern{Path: "/path"}, autologin.ParsePattern("/path/"))
+	assert.Equal(t, autologin.Pattern{Host: "www.example.com", Path: "/path/**"}, autologin.ParsePattern("WWW.example.com/path/**"))
+	assert.Equal(t, autologin.Pattern{Host: "www.example.com", Path: "/"}, autologin.ParsePattern("www.example.com"))
+}
@@ -1,6 +1,8 @@
 package error
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -27,12 +29,31 @@ type Source interface {
 	GetCookieOptsPathAware(r *http.Request) cookie.Options
 	GetCrypter() crypto.Crypter
 	GetErrorPath() string
+	GetErrorTemplates() *templates.ErrorTemplates
 	GetPath(r *http.Request) string
+	GetProviderName() string
 }
 
+// Page is the data available to error page templates.
 type Page struct {
 	CorrelationID string
+	Forbidden     bool
 	RetryURI      string
+	StatusCode    int
+	// Category is one of 'bad_request', 'unauthenticated', 'forbidden', 'service_unavailable' or 'internal_error'.
+	Category string
+	Provider string
+	// Locale is the locale of the template variant, or empty for the default template.
+	Locale string
+}
+
+// Response is the body of error responses to non-navigational requests, e.g. from fetch() or XMLHttpRequest.
+type Response struct {
+	Error         string `json:"error"`
+	CorrelationID string `json:"correlation_id"`
+	StatusCode    int    `json:"status_code"`
+	// LoginURL is set for 401 Unauthorized responses.
+	LoginURL string `json:"login_url,omitempty"`
 }
 
 type Handler struct {
@@ -55,6 +76,37 @@ func (h Handler) Unauthorized(w http.ResponseWriter, r *http.Request, cause erro
 	h.respondError(w, r, http.StatusUnauthorized, cause, log.WarnLevel)
 }
 
+// Forbidden responds with 403 Forbidden for authenticated requests that are not authorized. Retrying the request or
+// logging in again would not change the outcome, so the user is not redirected to retry.
+func (h Handler) Forbidden(w http.ResponseWriter, r *http.Request, cause error) {
+	mw.LogEntryFrom(r).Warnf("error in route: %+v", cause)
+	h.respondWithoutRetry(w, r, http.StatusForbidden, Page{Forbidden: true})
+}
+
+// ServiceUnavailable responds with 503 Service Unavailable for requests that cannot be handled because a dependency,
+// e.g. the external authorization service, is unavailable. Logging in again would not help, so the user is not
+// redirected to login, but may retry the request itself.
+func (h Handler) ServiceUnavailable(w http.ResponseWriter, r *http.Request, cause error) {
+	mw.LogEntryFrom(r).Errorf("error in route: %+v", cause)
+	h.respondWithoutRetry(w, r, http.StatusServiceUnavailable, Page{RetryURI: r.URL.RequestURI()})
+}
+
+func (h Handler) respondWithoutRetry(w http.ResponseWriter, r *http.Request, statusCode int, page Page) {
+	if !urlpkg.IsNavigation(r) {
+		h.jsonErrorResponse(w, r, statusCode)
+		return
+	}
+
+	if len(h.GetErrorPath()) > 0 {
+		err := h.customErrorRedirect(w, r, statusCode)
+		if err == nil {
+			return
+		}
+	}
+
+	h.errorPage(w, r, statusCode, page, nil)
+}
+
 // Retry returns a URI that should retry the desired route that failed.
 // It only handles the routes exposed by Wonderwall, i.e. `/oauth2/*`. As these routes
 // are related to the authentication flow, we default to redirecting back to the handled
@@ -80,6 +132,13 @@ func (h Handler) respondError(w http.ResponseWriter, r *http.Request, statusCode
 	logger := mw.LogEntryFrom(r)
 	msg := "error in route: %+v"
 
+	// non-navigational requests cannot be retried by redirecting, and should not receive HTML
+	if !urlpkg.IsNavigation(r) {
+		logger.WithField("status_code", statusCode).Logf(level, msg, cause)
+		h.jsonErrorResponse(w, r, statusCode)
+		return
+	}
+
 	incrementRetryAttempt(w, r, h.GetCookieOptsPathAware(r))
 
 	attempts, ok := getRetryAttempts(r)
@@ -93,7 +152,7 @@ func (h Handler) respondError(w http.ResponseWriter, r *http.Request, statusCode
 		logger.Warnf(msg, cause)
 
 		logger.Infof("errorhandler: auto-retry (attempt %d/%d) redirecting to %q...", attempts+1, MaxAutoRetryAttempts, retryUri)
-		http.Redirect(w, r, retryUri, http.StatusTemporaryRedirect)
+		http.Redirect(w, r, retryUri, urlpkg.RedirectStatus(r))
 
 		return
 	}
@@ -118,20 +177,81 @@ func (h Handler) respondError(w http.ResponseWriter, r *http.Request, statusCode
 }
 
 func (h Handler) defaultErrorResponse(w http.ResponseWriter, r *http.Request, statusCode int) {
-	w.WriteHeader(statusCode)
-
 	loginCookie, err := openid.GetLoginCookie(r, h.GetCrypter())
 	if err != nil {
 		loginCookie = nil
 	}
 
-	errorPage := Page{
+	h.errorPage(w, r, statusCode, Page{RetryURI: h.Retry(r, loginCookie)}, loginCookie)
+}
+
+// errorPage responds with the error page, using the template variant for the preferred locale of the user. The built-in
+// template is used if the configured template fails to execute.
+func (h Handler) errorPage(w http.ResponseWriter, r *http.Request, statusCode int, page Page, loginCookie *openid.LoginCookie) {
+	tpl, locale := h.GetErrorTemplates().Lookup(Locales(r, loginCookie)...)
+
+	page.CorrelationID = middleware.GetReqID(r.Context())
+	page.StatusCode = statusCode
+	page.Category = ErrorCode(statusCode)
+	page.Provider = h.GetProviderName()
+	page.Locale = locale
+
+	var buf bytes.Buffer
+	err := tpl.Execute(&buf, page)
+	if err != nil {
+		mw.LogEntryFrom(r).Errorf("errorhandler: executing error template: %+v; falling back to built-in template", err)
+
+		buf.Reset()
+		page.Locale = ""
+		err = templates.ErrorTemplate.Execute(&buf, page)
+		if err != nil {
+			mw.LogEntryFrom(r).Errorf("errorhandler: executing built-in error template: %+v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(statusCode)
+	_, _ = buf.WriteTo(w)
+}
+
+func (h Handler) jsonErrorResponse(w http.ResponseWriter, r *http.Request, statusCode int) {
+	response := Response{
+		Error:         ErrorCode(statusCode),
 		CorrelationID: middleware.GetReqID(r.Context()),
-		RetryURI:      h.Retry(r, loginCookie),
+		StatusCode:    statusCode,
 	}
-	err = templates.ErrorTemplate.Execute(w, errorPage)
+
+	if statusCode == http.StatusUnauthorized {
+		loginCookie, err := openid.GetLoginCookie(r, h.GetCrypter())
+		if err != nil {
+			loginCookie = nil
+		}
+
+		response.LoginURL = h.Retry(r, loginCookie)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	err := json.NewEncoder(w).Encode(response)
 	if err != nil {
-		mw.LogEntryFrom(r).Errorf("errorhandler: executing error template: %+v", err)
+		mw.LogEntryFrom(r).Errorf("errorhandler: marshalling error response: %+v", err)
+	}
+}
+
+// ErrorCode returns a machine-readable error code for the given status code.
+func ErrorCode(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusUnauthorized:
+		return "unauthenticated"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusServiceUnavailable:
+		return "service_unavailable"
+	default:
+		return "internal_error"
 	}
 }
 
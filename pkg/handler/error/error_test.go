@@ -1,9 +1,13 @@
 package error_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -71,6 +75,173 @@ func TestHandler_Error(t *testing.T) {
 	}
 }
 
+func TestHandler_Error_NonNavigational(t *testing.T) {
+	cfg := mock.Config()
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	r := idp.GetRequest(idp.RelyingPartyServer.URL + "/oauth2/login")
+	r.Header.Set("Sec-Fetch-Mode", "cors")
+	w := httptest.NewRecorder()
+
+	// should not be redirected to retry
+	idp.RelyingPartyHandler.GetErrorHandler().Unauthorized(w, r, fmt.Errorf("some error"))
+	assert.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+	assert.Equal(t, "application/json", w.Result().Header.Get("Content-Type"))
+	assert.Empty(t, w.Result().Cookies())
+
+	var response errorhandler.Response
+	err := json.NewDecoder(w.Body).Decode(&response)
+	assert.NoError(t, err)
+	assert.Equal(t, "unauthenticated", response.Error)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	assert.Equal(t, "/oauth2/login?redirect-encoded="+urlpkg.RedirectEncoded("/"), response.LoginURL)
+
+	w = httptest.NewRecorder()
+	idp.RelyingPartyHandler.GetErrorHandler().Forbidden(w, r, fmt.Errorf("some error"))
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+
+	response = errorhandler.Response{}
+	err = json.NewDecoder(w.Body).Decode(&response)
+	assert.NoError(t, err)
+	assert.Equal(t, "forbidden", response.Error)
+	assert.Empty(t, response.LoginURL)
+}
+
+func TestHandler_Forbidden(t *testing.T) {
+	t.Run("default error page", func(t *testing.T) {
+		cfg := mock.Config()
+		idp := mock.NewIdentityProvider(cfg)
+		defer idp.Close()
+
+		r := idp.GetRequest(idp.RelyingPartyServer.URL + "/admin")
+		w := httptest.NewRecorder()
+
+		// should not be redirected to retry
+		idp.RelyingPartyHandler.GetErrorHandler().Forbidden(w, r, fmt.Errorf("some error"))
+		assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+		assert.Contains(t, w.Body.String(), "ikke tilgang")
+		assert.NotContains(t, w.Body.String(), "prøve igjen")
+	})
+
+	t.Run("custom error path", func(t *testing.T) {
+		cfg := mock.Config()
+		cfg.ErrorPath = "/error"
+		idp := mock.NewIdentityProvider(cfg)
+		defer idp.Close()
+
+		r := idp.GetRequest(idp.RelyingPartyServer.URL + "/admin")
+		w := httptest.NewRecorder()
+
+		idp.RelyingPartyHandler.GetErrorHandler().Forbidden(w, r, fmt.Errorf("some error"))
+		assert.Equal(t, http.StatusFound, w.Result().StatusCode)
+
+		location, err := w.Result().Location()
+		assert.NoError(t, err)
+		assert.Equal(t, "/error", location.Path)
+		assert.Equal(t, "403", location.Query().Get("status_code"))
+	})
+}
+
+func TestHandler_ErrorTemplates(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "error.gohtml", "default {{.StatusCode}} {{.Category}} {{.Provider}} {{.CorrelationID}}")
+	writeTemplate(t, dir, "error.en.gohtml", "en {{.StatusCode}} {{.Category}} {{.Locale}}")
+	writeTemplate(t, dir, "error.nb.gohtml", "nb {{.StatusCode}} {{.RetryURI}} {{.Missing}}")
+
+	cfg := mock.Config()
+	cfg.ErrorTemplateDir = dir
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	errorHandler := idp.RelyingPartyHandler.GetErrorHandler()
+
+	t.Run("default template", func(t *testing.T) {
+		r := idp.GetRequest(idp.RelyingPartyServer.URL + "/admin")
+		w := httptest.NewRecorder()
+
+		errorHandler.Forbidden(w, r, fmt.Errorf("some error"))
+		assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+		assert.Equal(t, "text/html; charset=utf-8", w.Result().Header.Get("Content-Type"))
+		assert.True(t, strings.HasPrefix(w.Body.String(), "default 403 forbidden test "))
+	})
+
+	t.Run("locale from accept-language", func(t *testing.T) {
+		r := idp.GetRequest(idp.RelyingPartyServer.URL + "/admin")
+		r.Header.Set("Accept-Language", "de;q=0.9, en-GB;q=0.8")
+		w := httptest.NewRecorder()
+
+		errorHandler.Forbidden(w, r, fmt.Errorf("some error"))
+		assert.Equal(t, "en 403 forbidden en", w.Body.String())
+	})
+
+	t.Run("locale from login parameter", func(t *testing.T) {
+		r := idp.GetRequest(idp.RelyingPartyServer.URL + "/oauth2/login?locale=en")
+		r.Header.Set("Accept-Language", "nb")
+		r.Header.Set("Cookie", fmt.Sprintf("%s=%d", cookie.Retry, errorhandler.MaxAutoRetryAttempts))
+		w := httptest.NewRecorder()
+
+		errorHandler.InternalError(w, r, fmt.Errorf("some error"))
+		assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+		assert.Equal(t, "en 500 internal_error en", w.Body.String())
+	})
+
+	t.Run("falls back to built-in template on execution errors", func(t *testing.T) {
+		r := idp.GetRequest(idp.RelyingPartyServer.URL + "/admin")
+		r.Header.Set("Accept-Language", "nb")
+		w := httptest.NewRecorder()
+
+		errorHandler.Forbidden(w, r, fmt.Errorf("some error"))
+		assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+		assert.Contains(t, w.Body.String(), "ikke tilgang")
+	})
+}
+
+func TestLocales(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		url            string
+		acceptLanguage string
+		loginCookie    *openid.LoginCookie
+		want           []string
+	}{
+		{
+			name: "none",
+			url:  "/",
+			want: []string{},
+		},
+		{
+			name:           "accept-language ordered by quality",
+			url:            "/",
+			acceptLanguage: "en;q=0.5, nb-NO, nn;q=0.8, *;q=0.1, de;q=0",
+			want:           []string{"nb-NO", "nn", "en"},
+		},
+		{
+			name:           "login cookie takes precedence",
+			url:            "/oauth2/callback",
+			acceptLanguage: "nb",
+			loginCookie:    &openid.LoginCookie{Locale: "en"},
+			want:           []string{"en", "nb"},
+		},
+		{
+			name:           "login parameter takes precedence",
+			url:            "/oauth2/login?locale=se",
+			acceptLanguage: "nb",
+			loginCookie:    &openid.LoginCookie{Locale: "en"},
+			want:           []string{"se", "nb"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.url, nil)
+			if len(test.acceptLanguage) > 0 {
+				r.Header.Set("Accept-Language", test.acceptLanguage)
+			}
+
+			assert.Equal(t, test.want, errorhandler.Locales(r, test.loginCookie))
+		})
+	}
+}
+
 func TestHandler_Retry(t *testing.T) {
 	cfg := mock.Config()
 	idp := mock.NewIdentityProvider(cfg)
@@ -176,7 +347,8 @@ func TestHandler_Retry(t *testing.T) {
 			name:    "login with redirect parameter set to absolute url on non-default ingress",
 			request: get("/domene/oauth2/login?redirect=http://localhost:8080/"),
 			ingress: "https://test.nav.no/domene",
-			want:    "/domene/oauth2/login?redirect-encoded=" + urlpkg.RedirectEncoded("/"),
+			// absolute urls for other hosts that are not allowed fall back to the ingress path
+			want: "/domene/oauth2/login?redirect-encoded=" + urlpkg.RedirectEncoded("/domene"),
 		},
 		{
 			name:        "login with cookie referer takes precedence over redirect parameter",
@@ -202,3 +374,8 @@ func TestHandler_Retry(t *testing.T) {
 		})
 	}
 }
+
+func writeTemplate(t *testing.T, dir, name, content string) {
+	err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,68 @@
+package error
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nais/wonderwall/pkg/openid"
+	openidclient "github.com/nais/wonderwall/pkg/openid/client"
+)
+
+// Locales returns the preferred locales of the user for the given request, in order of preference. The 'locale' login
+// parameter in the request or in the login cookie takes precedence over the 'Accept-Language' header.
+func Locales(r *http.Request, loginCookie *openid.LoginCookie) []string {
+	locales := make([]string, 0)
+
+	if locale := r.URL.Query().Get(openidclient.LocaleURLParameter); len(locale) > 0 {
+		locales = append(locales, strings.Fields(locale)...)
+	} else if loginCookie != nil && len(loginCookie.Locale) > 0 {
+		locales = append(locales, strings.Fields(loginCookie.Locale)...)
+	}
+
+	return append(locales, acceptLanguage(r)...)
+}
+
+// acceptLanguage returns the languages in the 'Accept-Language' header, ordered by their quality values.
+func acceptLanguage(r *http.Request) []string {
+	type language struct {
+		tag     string
+		quality float64
+	}
+
+	languages := make([]language, 0)
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.TrimSpace(tag)
+		if len(tag) == 0 || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+
+		if quality <= 0 {
+			continue
+		}
+
+		languages = append(languages, language{tag: tag, quality: quality})
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].quality > languages[j].quality
+	})
+
+	result := make([]string, 0, len(languages))
+	for _, l := range languages {
+		result = append(result, l.tag)
+	}
+
+	return result
+}
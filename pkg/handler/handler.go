@@ -1,18 +1,26 @@
 package handler
 
 import (
+	"crypto/tls"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/go-redis/redis/v8"
+
 	"github.com/nais/wonderwall/pkg/config"
 	"github.com/nais/wonderwall/pkg/cookie"
 	"github.com/nais/wonderwall/pkg/crypto"
+	"github.com/nais/wonderwall/pkg/handler/authrules"
+	"github.com/nais/wonderwall/pkg/handler/authz"
 	"github.com/nais/wonderwall/pkg/handler/autologin"
 	"github.com/nais/wonderwall/pkg/handler/reverseproxy"
+	"github.com/nais/wonderwall/pkg/handler/templates"
 	"github.com/nais/wonderwall/pkg/ingress"
 	"github.com/nais/wonderwall/pkg/loginstatus"
 	"github.com/nais/wonderwall/pkg/openid/client"
 	openidconfig "github.com/nais/wonderwall/pkg/openid/config"
+	"github.com/nais/wonderwall/pkg/ratelimit"
 	"github.com/nais/wonderwall/pkg/session"
 )
 
@@ -23,6 +31,16 @@ func NewHandler(
 	openidConfig openidconfig.Config,
 	crypter crypto.Crypter,
 ) (*StandardHandler, error) {
+	authRules, err := authrules.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	authorizationPolicies, err := authz.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	autoLogin, err := autologin.New(cfg)
 	if err != nil {
 		return nil, err
@@ -35,9 +53,18 @@ func NewHandler(
 	loginstatusClient := loginstatus.NewClient(cfg.Loginstatus, httpClient)
 
 	openidClient := client.NewClient(openidConfig, loginstatusClient, jwksProvider)
-	openidClient.SetHttpClient(httpClient)
+	openidClient.SetHttpClient(openidHttpClient(openidConfig, httpClient))
+
+	// the Redis client, if any, is shared by the session store and rate limiting to avoid separate connection pools
+	var redisClient *redis.Client
+	if len(cfg.Redis.Address) > 0 {
+		redisClient, err = cfg.Redis.Client()
+		if err != nil {
+			return nil, fmt.Errorf("creating Redis client: %w", err)
+		}
+	}
 
-	sessionHandler, err := session.NewHandler(cfg, openidConfig, crypter, openidClient)
+	sessionHandler, err := session.NewHandler(cfg, openidConfig, crypter, openidClient, redisClient)
 	if err != nil {
 		return nil, err
 	}
@@ -47,16 +74,63 @@ func NewHandler(
 		return nil, err
 	}
 
+	upstreamProxy, err := reverseproxy.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimit, err := ratelimit.New(cfg, redisClient)
+	if err != nil {
+		return nil, err
+	}
+
+	errorTemplates, err := templates.LoadErrorTemplates(cfg.ErrorTemplateDir)
+	if err != nil {
+		return nil, err
+	}
+
 	return &StandardHandler{
-		autoLogin:     autoLogin,
-		client:        openidClient,
-		config:        cfg,
-		cookieOptions: cookieOpts,
-		crypter:       crypter,
-		ingresses:     ingresses,
-		loginstatus:   loginstatusClient,
-		openidConfig:  openidConfig,
-		sessions:      sessionHandler,
-		upstreamProxy: reverseproxy.New(cfg.UpstreamHost),
+		authRules:      authRules,
+		authzCallout:   authz.NewCallout(cfg),
+		authzPolicies:  authorizationPolicies,
+		autoLogin:      autoLogin,
+		client:         openidClient,
+		config:         cfg,
+		cookieOptions:  cookieOpts,
+		crypter:        crypter,
+		errorTemplates: errorTemplates,
+		ingresses:      ingresses,
+		loginstatus:    loginstatusClient,
+		openidConfig:   openidConfig,
+		rateLimit:      rateLimit,
+		sessions:       sessionHandler,
+		upstreamProxy:  upstreamProxy,
 	}, nil
 }
+
+// openidHttpClient returns the client used for requests to the identity provider. If the client authenticates with
+// mutual TLS, the client certificate is presented in all TLS handshakes. The given client's transport and TLS
+// configuration are otherwise kept.
+func openidHttpClient(openidConfig openidconfig.Config, httpClient *http.Client) *http.Client {
+	certificate := openidConfig.Client().ClientCertificate()
+	if certificate == nil {
+		return httpClient
+	}
+
+	base, ok := httpClient.Transport.(*http.Transport)
+	if !ok || base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+
+	transport := base.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+	transport.TLSClientConfig.Certificates = append([]tls.Certificate{*certificate}, transport.TLSClientConfig.Certificates...)
+
+	client := *httpClient
+	client.Transport = transport
+	return &client
+}
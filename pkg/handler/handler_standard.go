@@ -6,21 +6,29 @@ import (
 	"github.com/nais/wonderwall/pkg/config"
 	"github.com/nais/wonderwall/pkg/cookie"
 	"github.com/nais/wonderwall/pkg/crypto"
+	apijwks "github.com/nais/wonderwall/pkg/handler/api/jwks"
 	apilogin "github.com/nais/wonderwall/pkg/handler/api/login"
 	apilogincallback "github.com/nais/wonderwall/pkg/handler/api/logincallback"
 	apilogout "github.com/nais/wonderwall/pkg/handler/api/logout"
 	apilogoutcallback "github.com/nais/wonderwall/pkg/handler/api/logoutcallback"
 	apilogoutfrontchannel "github.com/nais/wonderwall/pkg/handler/api/logoutfrontchannel"
 	apisession "github.com/nais/wonderwall/pkg/handler/api/session"
+	apisessioncheck "github.com/nais/wonderwall/pkg/handler/api/sessioncheck"
+	apisessionclaims "github.com/nais/wonderwall/pkg/handler/api/sessionclaims"
 	apisessionrefresh "github.com/nais/wonderwall/pkg/handler/api/sessionrefresh"
+	apisessionstatus "github.com/nais/wonderwall/pkg/handler/api/sessionstatus"
+	"github.com/nais/wonderwall/pkg/handler/authrules"
+	"github.com/nais/wonderwall/pkg/handler/authz"
 	"github.com/nais/wonderwall/pkg/handler/autologin"
 	errorhandler "github.com/nais/wonderwall/pkg/handler/error"
 	"github.com/nais/wonderwall/pkg/handler/reverseproxy"
+	"github.com/nais/wonderwall/pkg/handler/templates"
 	"github.com/nais/wonderwall/pkg/ingress"
 	"github.com/nais/wonderwall/pkg/loginstatus"
 	"github.com/nais/wonderwall/pkg/middleware"
 	openidclient "github.com/nais/wonderwall/pkg/openid/client"
 	openidconfig "github.com/nais/wonderwall/pkg/openid/config"
+	"github.com/nais/wonderwall/pkg/ratelimit"
 	"github.com/nais/wonderwall/pkg/router"
 	"github.com/nais/wonderwall/pkg/session"
 )
@@ -28,16 +36,33 @@ import (
 var _ router.Source = &StandardHandler{}
 
 type StandardHandler struct {
-	autoLogin     *autologin.AutoLogin
-	client        *openidclient.Client
-	config        *config.Config
-	cookieOptions cookie.Options
-	crypter       crypto.Crypter
-	ingresses     *ingress.Ingresses
-	loginstatus   *loginstatus.Loginstatus
-	openidConfig  openidconfig.Config
-	sessions      *session.Handler
-	upstreamProxy *reverseproxy.ReverseProxy
+	authRules      *authrules.Rules
+	authzCallout   *authz.Callout
+	authzPolicies  *authz.Policies
+	autoLogin      *autologin.AutoLogin
+	client         *openidclient.Client
+	config         *config.Config
+	cookieOptions  cookie.Options
+	crypter        crypto.Crypter
+	errorTemplates *templates.ErrorTemplates
+	ingresses      *ingress.Ingresses
+	loginstatus    *loginstatus.Loginstatus
+	openidConfig   openidconfig.Config
+	rateLimit      *ratelimit.RateLimit
+	sessions       *session.Handler
+	upstreamProxy  *reverseproxy.ReverseProxy
+}
+
+func (s *StandardHandler) GetAuthRules() *authrules.Rules {
+	return s.authRules
+}
+
+func (s *StandardHandler) GetAuthorizationCallout() *authz.Callout {
+	return s.authzCallout
+}
+
+func (s *StandardHandler) GetAuthorizationPolicies() *authz.Policies {
+	return s.authzPolicies
 }
 
 func (s *StandardHandler) GetAutoLogin() *autologin.AutoLogin {
@@ -69,6 +94,14 @@ func (s *StandardHandler) GetErrorPath() string {
 	return s.config.ErrorPath
 }
 
+func (s *StandardHandler) GetErrorTemplates() *templates.ErrorTemplates {
+	return s.errorTemplates
+}
+
+func (s *StandardHandler) GetCORSConfig() config.CORS {
+	return s.config.CORS
+}
+
 func (s *StandardHandler) GetIngresses() *ingress.Ingresses {
 	return s.ingresses
 }
@@ -81,6 +114,10 @@ func (s *StandardHandler) GetLoginstatus() *loginstatus.Loginstatus {
 	return s.loginstatus
 }
 
+func (s *StandardHandler) GetOpenIDConfig() openidconfig.Config {
+	return s.openidConfig
+}
+
 func (s *StandardHandler) GetPath(r *http.Request) string {
 	path, ok := middleware.PathFrom(r.Context())
 	if !ok {
@@ -94,6 +131,10 @@ func (s *StandardHandler) GetProviderName() string {
 	return s.openidConfig.Provider().Name()
 }
 
+func (s *StandardHandler) GetRateLimit() *ratelimit.RateLimit {
+	return s.rateLimit
+}
+
 func (s *StandardHandler) GetSessions() *session.Handler {
 	return s.sessions
 }
@@ -136,6 +177,15 @@ func (s *StandardHandler) Session(w http.ResponseWriter, r *http.Request) {
 	apisession.Handler(s, w, r)
 }
 
+func (s *StandardHandler) SessionClaims(w http.ResponseWriter, r *http.Request) {
+	if len(s.config.Session.Claims) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	apisessionclaims.Handler(s, w, r)
+}
+
 func (s *StandardHandler) SessionRefresh(w http.ResponseWriter, r *http.Request) {
 	if !s.config.Session.Refresh {
 		http.NotFound(w, r)
@@ -145,6 +195,28 @@ func (s *StandardHandler) SessionRefresh(w http.ResponseWriter, r *http.Request)
 	apisessionrefresh.Handler(s, w, r)
 }
 
+func (s *StandardHandler) SessionCheck(w http.ResponseWriter, r *http.Request) {
+	if !s.config.Session.Check {
+		http.NotFound(w, r)
+		return
+	}
+
+	apisessioncheck.Handler(s, w, r)
+}
+
+func (s *StandardHandler) SessionStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.config.Session.Check {
+		http.NotFound(w, r)
+		return
+	}
+
+	apisessionstatus.Handler(s, w, r)
+}
+
+func (s *StandardHandler) Jwks(w http.ResponseWriter, r *http.Request) {
+	apijwks.Handler(s, w, r)
+}
+
 func (s *StandardHandler) ReverseProxy(w http.ResponseWriter, r *http.Request) {
 	s.upstreamProxy.Handler(s, w, r)
 }
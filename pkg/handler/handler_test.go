@@ -1,21 +1,45 @@
 package handler_test
 
 import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"html"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	jwtlib "github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/nais/wonderwall/pkg/config"
 	"github.com/nais/wonderwall/pkg/cookie"
+	"github.com/nais/wonderwall/pkg/crypto"
+	loginhandler "github.com/nais/wonderwall/pkg/handler/api/login"
+	"github.com/nais/wonderwall/pkg/handler/api/sessionstatus"
+	"github.com/nais/wonderwall/pkg/handler/authz"
+	errorhandler "github.com/nais/wonderwall/pkg/handler/error"
+	"github.com/nais/wonderwall/pkg/handler/reverseproxy"
 	urlpkg "github.com/nais/wonderwall/pkg/handler/url"
 	"github.com/nais/wonderwall/pkg/mock"
 	"github.com/nais/wonderwall/pkg/session"
@@ -65,6 +89,283 @@ func TestHandler_Callback(t *testing.T) {
 	login(t, rpClient, idp)
 }
 
+func TestHandler_Callback_ParallelLogins(t *testing.T) {
+	cfg := mock.Config()
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+
+	// start login flows in two tabs before completing either of them
+	first := authorize(t, rpClient, idp)
+	second := authorize(t, rpClient, idp)
+	assert.Len(t, getLoginCookiesFromJar(rpClient.Jar.Cookies(first.Location)), 4)
+
+	resp := get(t, rpClient, first.Location.String())
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.NotEqual(t, "/oauth2/login", resp.Location.Path)
+
+	// the other flow's cookies are kept
+	secondState := second.Location.Query().Get("state")
+	cookies := rpClient.Jar.Cookies(second.Location)
+	assert.NotNil(t, getCookieFromJar(cookie.LoginName(secondState), cookies))
+	assert.Len(t, getLoginCookiesFromJar(cookies), 2)
+
+	resp = get(t, rpClient, second.Location.String())
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.NotEqual(t, "/oauth2/login", resp.Location.Path)
+
+	cookies = rpClient.Jar.Cookies(second.Location)
+	assert.NotNil(t, getCookieFromJar(cookie.Session, cookies))
+	assert.Empty(t, getLoginCookiesFromJar(cookies))
+
+	t.Run("oldest flows are discarded", func(t *testing.T) {
+		rpClient := idp.RelyingPartyClient()
+
+		flows := make([]response, 0)
+		for i := 0; i <= loginhandler.MaxParallelLogins; i++ {
+			flows = append(flows, authorize(t, rpClient, idp))
+		}
+
+		cookies := rpClient.Jar.Cookies(flows[0].Location)
+		assert.Len(t, getLoginCookiesFromJar(cookies), 2*loginhandler.MaxParallelLogins)
+		assert.Nil(t, getCookieFromJar(cookie.LoginName(flows[0].Location.Query().Get("state")), cookies))
+
+		resp := get(t, rpClient, flows[0].Location.String())
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		assert.Equal(t, "/oauth2/login", resp.Location.Path)
+
+		resp = get(t, rpClient, flows[len(flows)-1].Location.String())
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		assert.NotEqual(t, "/oauth2/login", resp.Location.Path)
+	})
+}
+
+func TestHandler_Callback_WithMutualTLS(t *testing.T) {
+	for _, method := range []config.ClientAuthMethod{
+		config.ClientAuthMethodTLSClientAuth,
+		config.ClientAuthMethodSelfSignedTLSClientAuth,
+	} {
+		t.Run(string(method), func(t *testing.T) {
+			cfg := mock.Config()
+			cfg.OpenID.ClientAuthMethod = method
+			cfg.Session.Refresh = true
+
+			idp := mock.NewIdentityProvider(cfg)
+			idp.ProviderHandler.TokenDuration = 5 * time.Second
+			defer idp.Close()
+
+			rpClient := idp.RelyingPartyClient()
+			login(t, rpClient, idp)
+
+			// wait until refresh cooldown has reached zero before refresh
+			waitForRefreshCooldownTimer(t, idp, rpClient)
+
+			resp := sessionRefresh(t, idp, rpClient)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestHandler_Callback_WithResponseModes(t *testing.T) {
+	for _, mode := range []config.ResponseMode{
+		config.ResponseModeFormPost,
+		config.ResponseModeQueryJWT,
+		config.ResponseModeFormPostJWT,
+	} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := mock.Config()
+			cfg.OpenID.ResponseMode = mode
+
+			idp := mock.NewIdentityProvider(cfg)
+			defer idp.Close()
+
+			rpClient := idp.RelyingPartyClient()
+			resp := localLogin(t, rpClient, idp)
+			assert.Equal(t, string(mode), resp.Location.Query().Get("response_mode"))
+
+			// Follow redirect to authorize with identity provider
+			resp = get(t, rpClient, resp.Location.String())
+
+			var callbackURL *url.URL
+			if mode.IsFormPost() {
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+				action, params := parseFormPost(t, resp.Body)
+				callbackURL = action
+				assert.Empty(t, callbackURL.RawQuery)
+				assert.Equal(t, mode.IsJWT(), params.Has("response"))
+
+				resp = postForm(t, rpClient, action.String(), params)
+				assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+			} else {
+				assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+				callbackURL = resp.Location
+				assert.NotEmpty(t, callbackURL.Query().Get("response"))
+				assert.Empty(t, callbackURL.Query().Get("code"))
+
+				resp = get(t, rpClient, callbackURL.String())
+				assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+			}
+
+			cookies := rpClient.Jar.Cookies(callbackURL)
+			assert.NotNil(t, getCookieFromJar(cookie.Session, cookies))
+			assert.Empty(t, getLoginCookiesFromJar(cookies))
+		})
+	}
+
+	t.Run("response mode mismatch", func(t *testing.T) {
+		cfg := mock.Config()
+		cfg.OpenID.ResponseMode = config.ResponseModeFormPost
+
+		idp := mock.NewIdentityProvider(cfg)
+		defer idp.Close()
+
+		rpClient := idp.RelyingPartyClient()
+		resp := localLogin(t, rpClient, idp)
+		state := resp.Location.Query().Get("state")
+
+		// a query response should not be accepted when form_post was requested
+		resp = get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/callback?code=some-code&state="+state)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		assert.Equal(t, "/oauth2/login", resp.Location.Path)
+
+		cookies := rpClient.Jar.Cookies(resp.Location)
+		assert.Nil(t, getCookieFromJar(cookie.Session, cookies))
+	})
+
+	t.Run("invalid response signature", func(t *testing.T) {
+		cfg := mock.Config()
+		cfg.OpenID.ResponseMode = config.ResponseModeQueryJWT
+
+		idp := mock.NewIdentityProvider(cfg)
+		defer idp.Close()
+
+		rpClient := idp.RelyingPartyClient()
+		resp := authorize(t, rpClient, idp)
+
+		// tamper with the signature of the response
+		callbackURL := resp.Location
+		query := callbackURL.Query()
+		parts := strings.Split(query.Get("response"), ".")
+		parts[2] = base64.RawURLEncoding.EncodeToString([]byte("invalid"))
+		query.Set("response", strings.Join(parts, "."))
+		callbackURL.RawQuery = query.Encode()
+
+		resp = get(t, rpClient, callbackURL.String())
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		assert.Equal(t, "/oauth2/login", resp.Location.Path)
+
+		cookies := rpClient.Jar.Cookies(callbackURL)
+		assert.Nil(t, getCookieFromJar(cookie.Session, cookies))
+	})
+}
+
+func TestHandler_Callback_WithEncryptedIDToken(t *testing.T) {
+	cfg := mock.Config()
+	cfg.Session.Refresh = true
+	_, cfg.OpenID.IDTokenDecryptionJWK = newDecryptionJwk(t)
+
+	idp := mock.NewIdentityProvider(cfg)
+	idp.ProviderHandler.EncryptIDTokens = true
+	idp.ProviderHandler.TokenDuration = 5 * time.Second
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	// wait until refresh cooldown has reached zero before refresh
+	waitForRefreshCooldownTimer(t, idp, rpClient)
+
+	resp := sessionRefresh(t, idp, rpClient)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestHandler_Callback_WithIDTokenEncryptedToClientJWK(t *testing.T) {
+	cfg := mock.Config()
+	idp := mock.NewIdentityProvider(cfg)
+	idp.ProviderHandler.EncryptIDTokens = true
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	resp := sessionInfo(t, idp, rpClient)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestHandler_Jwks(t *testing.T) {
+	t.Run("publishes decryption keys", func(t *testing.T) {
+		cfg := mock.Config()
+		decryptionKey, decryptionJwk := newDecryptionJwk(t)
+		cfg.OpenID.IDTokenDecryptionJWK = decryptionJwk
+
+		idp := mock.NewIdentityProvider(cfg)
+		defer idp.Close()
+
+		rpClient := idp.RelyingPartyClient()
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/jwks")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		set, err := jwk.Parse([]byte(resp.Body))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, set.Len())
+
+		key, ok := set.Key(0)
+		assert.True(t, ok)
+		assert.Equal(t, decryptionKey.KeyID(), key.KeyID())
+		assert.NotEqual(t, idp.OpenIDConfig.Client().ClientJWK().KeyID(), key.KeyID())
+		assert.Equal(t, string(jwk.ForEncryption), key.KeyUsage())
+		assert.Empty(t, key.Algorithm().String())
+
+		_, isPrivate := key.(jwk.RSAPrivateKey)
+		assert.False(t, isPrivate)
+	})
+
+	t.Run("publishes client jwk without decryption keys", func(t *testing.T) {
+		cfg := mock.Config()
+		idp := mock.NewIdentityProvider(cfg)
+		defer idp.Close()
+
+		rpClient := idp.RelyingPartyClient()
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/jwks")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		set, err := jwk.Parse([]byte(resp.Body))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, set.Len())
+
+		key, ok := set.Key(0)
+		assert.True(t, ok)
+		assert.Equal(t, idp.OpenIDConfig.Client().ClientJWK().KeyID(), key.KeyID())
+		assert.Equal(t, string(jwk.ForEncryption), key.KeyUsage())
+
+		_, isPrivate := key.(jwk.RSAPrivateKey)
+		assert.False(t, isPrivate)
+	})
+
+	t.Run("not served without decryption keys or client jwk", func(t *testing.T) {
+		cfg := mock.Config()
+		cfg.OpenID.ClientAuthMethod = config.ClientAuthMethodSelfSignedTLSClientAuth
+		idp := mock.NewIdentityProvider(cfg)
+		defer idp.Close()
+
+		rpClient := idp.RelyingPartyClient()
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/jwks")
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func newDecryptionJwk(t *testing.T) (jwk.Key, string) {
+	key, err := crypto.NewJwk()
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(key)
+	assert.NoError(t, err)
+	return key, string(data)
+}
+
 func TestHandler_Logout(t *testing.T) {
 	cfg := mock.Config()
 	idp := mock.NewIdentityProvider(cfg)
@@ -102,6 +403,82 @@ func TestHandler_LogoutCallback(t *testing.T) {
 	logout(t, rpClient, idp)
 }
 
+func TestHandler_AbsoluteRedirects(t *testing.T) {
+	cfg := mock.Config()
+	cfg.RedirectAllowedOrigins = []string{"https://portal.example"}
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+
+	// relative locations are resolved against the request URL
+	t.Run("login", func(t *testing.T) {
+		for _, test := range []struct {
+			redirect string
+			want     string
+		}{
+			{redirect: "https://portal.example/home", want: "https://portal.example/home"},
+			{redirect: idp.RelyingPartyServer.URL + "/path", want: idp.RelyingPartyServer.URL + "/path"},
+			{redirect: "https://evil.example/home", want: idp.RelyingPartyServer.URL + "/"},
+		} {
+			resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/login?redirect="+url.QueryEscape(test.redirect))
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+			resp = get(t, rpClient, resp.Location.String())
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+			resp = get(t, rpClient, resp.Location.String())
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+			assert.Equal(t, test.want, resp.Location.String())
+		}
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		for _, test := range []struct {
+			redirect string
+			want     string
+		}{
+			{redirect: "https://portal.example/bye", want: "https://portal.example/bye"},
+			{redirect: "https://evil.example/bye", want: idp.RelyingPartyServer.URL + "/"},
+			// falls back to the post-logout redirect URI
+			{redirect: "", want: "https://google.com"},
+		} {
+			logoutURL := idp.RelyingPartyServer.URL + "/oauth2/logout"
+			if len(test.redirect) > 0 {
+				logoutURL += "?redirect=" + url.QueryEscape(test.redirect)
+			}
+
+			resp := get(t, rpClient, logoutURL)
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+			// follow redirect to end session endpoint, and then back to the logout callback
+			resp = get(t, rpClient, resp.Location.String())
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+			resp = get(t, rpClient, resp.Location.String())
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+			assert.Equal(t, test.want, resp.Location.String())
+		}
+	})
+
+	t.Run("logout without redirect after abandoned logout", func(t *testing.T) {
+		// logout with a redirect, but never return from the identity provider
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/logout?redirect="+url.QueryEscape("https://portal.example/bye"))
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+		resp = get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/logout")
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+		resp = get(t, rpClient, resp.Location.String())
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+		// falls back to the post-logout redirect URI, rather than the redirect from the abandoned logout
+		resp = get(t, rpClient, resp.Location.String())
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		assert.Equal(t, "https://google.com", resp.Location.String())
+	})
+}
+
 func TestHandler_FrontChannelLogout(t *testing.T) {
 	cfg := mock.Config()
 	idp := mock.NewIdentityProvider(cfg)
@@ -139,6 +516,34 @@ func TestHandler_FrontChannelLogout(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
+func TestHandler_RateLimit(t *testing.T) {
+	cfg := mock.Config()
+	cfg.RateLimit.Enabled = true
+	cfg.RateLimit.PerIP = 2
+	cfg.RateLimit.Window = time.Hour
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+
+	loginURL := idp.RelyingPartyServer.URL + "/oauth2/login"
+	for i := 0; i < 2; i++ {
+		resp := get(t, rpClient, loginURL)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	}
+
+	resp := get(t, rpClient, loginURL)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// the callback shares the budget with the login endpoint
+	resp = get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/callback")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// other endpoints are not limited
+	resp = get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/session")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
 func TestHandler_LogoutLocal(t *testing.T) {
 	cfg := mock.Config()
 	idp := mock.NewIdentityProvider(cfg)
@@ -166,6 +571,110 @@ func TestHandler_SessionStateRequired(t *testing.T) {
 	assert.NotEmpty(t, sessionState)
 }
 
+func TestHandler_SessionStatus(t *testing.T) {
+	cfg := mock.Config()
+	cfg.Session.Check = true
+	cfg.Session.CheckInterval = 5 * time.Second
+	cfg.Session.CheckOnChange = config.SessionCheckOnChangeLogout
+
+	idp := mock.NewIdentityProvider(cfg)
+	checkSessionIframe := idp.ProviderServer.URL + "/checksession"
+	idp.OpenIDConfig.TestProvider.WithCheckSessionIFrameSupport(checkSessionIframe)
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+
+	resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/session/status")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	authorizeResponse := authorize(t, rpClient, idp)
+	sessionState := authorizeResponse.Location.Query().Get("session_state")
+	assert.NotEmpty(t, sessionState)
+	callback(t, rpClient, authorizeResponse)
+
+	resp = get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/session/status")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var status sessionstatus.Status
+	err := json.Unmarshal([]byte(resp.Body), &status)
+	assert.NoError(t, err)
+
+	assert.Equal(t, checkSessionIframe, status.CheckSessionIframe)
+	assert.Equal(t, cfg.OpenID.ClientID, status.ClientID)
+	assert.Equal(t, int64(5), status.IntervalSeconds)
+	assert.Equal(t, "/oauth2/login", status.LoginURL)
+	assert.Equal(t, "/oauth2/logout/local", status.LogoutURL)
+	assert.Equal(t, config.SessionCheckOnChangeLogout, status.OnChange)
+	assert.Equal(t, idp.ProviderServer.URL, status.Origin)
+	assert.Equal(t, sessionState, status.SessionState)
+
+	resp = get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/session/check")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Body, "/oauth2/session/status")
+}
+
+func TestHandler_SessionStatus_Disabled(t *testing.T) {
+	cfg := mock.Config()
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/session/status")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/session/check")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHandler_SessionClaims(t *testing.T) {
+	cfg := mock.Config()
+	cfg.OpenID.UserInfo = true
+	cfg.Session.Claims = []string{"sub", "name", "acr", "email"}
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	idp.ProviderHandler.IDTokenClaims["name"] = "Ola Nordmann"
+	idp.ProviderHandler.UserInfoClaims["email"] = "ola@example.com"
+	idp.ProviderHandler.UserInfoClaims["phone_number"] = "12345678"
+
+	rpClient := idp.RelyingPartyClient()
+
+	resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/session/claims")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	login(t, rpClient, idp)
+
+	resp = get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/session/claims")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var claims map[string]any
+	err := json.Unmarshal([]byte(resp.Body), &claims)
+	assert.NoError(t, err)
+
+	assert.NotEmpty(t, claims["sub"])
+	assert.NotEmpty(t, claims["acr"])
+	assert.Equal(t, "Ola Nordmann", claims["name"])
+	assert.Equal(t, "ola@example.com", claims["email"])
+	// only allow-listed claims are returned
+	assert.Len(t, claims, 4)
+	assert.NotContains(t, claims, "phone_number")
+	assert.NotContains(t, claims, "nonce")
+}
+
+func TestHandler_SessionClaims_Disabled(t *testing.T) {
+	cfg := mock.Config()
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/session/claims")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
 func TestHandler_SessionInfo(t *testing.T) {
 	cfg := mock.Config()
 	cfg.Session.Refresh = true
@@ -214,211 +723,1355 @@ func TestHandler_SessionInfo_WithInactivity(t *testing.T) {
 	idp := mock.NewIdentityProvider(cfg)
 	defer idp.Close()
 
-	rpClient := idp.RelyingPartyClient()
-	login(t, rpClient, idp)
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	resp := sessionInfo(t, idp, rpClient)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data session.MetadataVerbose
+	err := json.Unmarshal([]byte(resp.Body), &data)
+	assert.NoError(t, err)
+
+	maxDelta := 5 * time.Second
+
+	assert.True(t, data.Session.Active)
+	assert.False(t, data.Session.TimeoutAt.IsZero())
+
+	expectedTimeoutAt := time.Now().Add(cfg.Session.InactivityTimeout)
+	assert.WithinDuration(t, expectedTimeoutAt, data.Session.TimeoutAt, maxDelta)
+
+	actualTimeoutDuration := time.Duration(data.Session.TimeoutInSeconds) * time.Second
+	assert.WithinDuration(t, expectedTimeoutAt, time.Now().Add(actualTimeoutDuration), maxDelta)
+}
+
+func TestHandler_SessionInfo_WithRefresh(t *testing.T) {
+	cfg := mock.Config()
+	cfg.Session.Refresh = true
+
+	idp := mock.NewIdentityProvider(cfg)
+	idp.ProviderHandler.TokenDuration = 5 * time.Minute
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	resp := sessionInfo(t, idp, rpClient)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data session.MetadataVerboseWithRefresh
+	err := json.Unmarshal([]byte(resp.Body), &data)
+	assert.NoError(t, err)
+
+	allowedSkew := 5 * time.Second
+	assert.WithinDuration(t, time.Now(), data.Session.CreatedAt, allowedSkew)
+	assert.WithinDuration(t, time.Now().Add(cfg.Session.MaxLifetime), data.Session.EndsAt, allowedSkew)
+	assert.WithinDuration(t, time.Now().Add(idp.ProviderHandler.TokenDuration), data.Tokens.ExpireAt, allowedSkew)
+	assert.WithinDuration(t, time.Now(), data.Tokens.RefreshedAt, allowedSkew)
+
+	sessionEndDuration := time.Duration(data.Session.EndsInSeconds) * time.Second
+	// 1 second < time until session ends <= configured max session lifetime
+	assert.LessOrEqual(t, sessionEndDuration, cfg.Session.MaxLifetime)
+	assert.Greater(t, sessionEndDuration, time.Second)
+
+	tokenExpiryDuration := time.Duration(data.Tokens.ExpireInSeconds) * time.Second
+	// 1 second < time until token expires <= max duration for tokens from IDP
+	assert.LessOrEqual(t, tokenExpiryDuration, idp.ProviderHandler.TokenDuration)
+	assert.Greater(t, tokenExpiryDuration, time.Second)
+
+	// 1 second < next token refresh <= seconds until token expires
+	assert.LessOrEqual(t, data.Tokens.NextAutoRefreshInSeconds, data.Tokens.ExpireInSeconds)
+	assert.Greater(t, data.Tokens.NextAutoRefreshInSeconds, int64(1))
+
+	assert.True(t, data.Tokens.RefreshCooldown)
+	// 1 second < refresh cooldown <= minimum refresh interval
+	assert.LessOrEqual(t, data.Tokens.RefreshCooldownSeconds, session.RefreshMinInterval)
+	assert.Greater(t, data.Tokens.RefreshCooldownSeconds, int64(1))
+
+	assert.True(t, data.Session.Active)
+	assert.True(t, data.Session.TimeoutAt.IsZero())
+	assert.Equal(t, int64(-1), data.Session.TimeoutInSeconds)
+}
+
+func TestHandler_SessionRefresh(t *testing.T) {
+	cfg := mock.Config()
+	cfg.Session.Refresh = true
+
+	idp := mock.NewIdentityProvider(cfg)
+	idp.ProviderHandler.TokenDuration = 5 * time.Second
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	// get initial session info
+	resp := sessionInfo(t, idp, rpClient)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data session.MetadataVerboseWithRefresh
+	err := json.Unmarshal([]byte(resp.Body), &data)
+	assert.NoError(t, err)
+
+	// wait until refresh cooldown has reached zero before refresh
+	waitForRefreshCooldownTimer(t, idp, rpClient)
+
+	resp = sessionRefresh(t, idp, rpClient)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var refreshedData session.MetadataVerboseWithRefresh
+	err = json.Unmarshal([]byte(resp.Body), &refreshedData)
+	assert.NoError(t, err)
+
+	// session create and end times should be unchanged
+	assert.WithinDuration(t, data.Session.CreatedAt, refreshedData.Session.CreatedAt, 0)
+	assert.WithinDuration(t, data.Session.EndsAt, refreshedData.Session.EndsAt, 0)
+
+	// token expiration and refresh times should be later than before
+	assert.True(t, refreshedData.Tokens.ExpireAt.After(data.Tokens.ExpireAt))
+	assert.True(t, refreshedData.Tokens.RefreshedAt.After(data.Tokens.RefreshedAt))
+
+	allowedSkew := 5 * time.Second
+	assert.WithinDuration(t, time.Now().Add(idp.ProviderHandler.TokenDuration), refreshedData.Tokens.ExpireAt, allowedSkew)
+	assert.WithinDuration(t, time.Now(), refreshedData.Tokens.RefreshedAt, allowedSkew)
+
+	sessionEndDuration := time.Duration(refreshedData.Session.EndsInSeconds) * time.Second
+	// 1 second < time until session ends <= configured max session lifetime
+	assert.LessOrEqual(t, sessionEndDuration, cfg.Session.MaxLifetime)
+	assert.Greater(t, sessionEndDuration, time.Second)
+
+	tokenExpiryDuration := time.Duration(refreshedData.Tokens.ExpireInSeconds) * time.Second
+	// 1 second < time until token expires <= max duration for tokens from IDP
+	assert.LessOrEqual(t, tokenExpiryDuration, idp.ProviderHandler.TokenDuration)
+	assert.Greater(t, tokenExpiryDuration, time.Second)
+
+	// 1 second < next token refresh <= seconds until token expires
+	assert.LessOrEqual(t, refreshedData.Tokens.NextAutoRefreshInSeconds, refreshedData.Tokens.ExpireInSeconds)
+	assert.Greater(t, refreshedData.Tokens.NextAutoRefreshInSeconds, int64(1))
+
+	assert.True(t, refreshedData.Tokens.RefreshCooldown)
+	// 1 second < refresh cooldown <= minimum refresh interval
+	assert.LessOrEqual(t, refreshedData.Tokens.RefreshCooldownSeconds, session.RefreshMinInterval)
+	assert.Greater(t, refreshedData.Tokens.RefreshCooldownSeconds, int64(1))
+
+	assert.True(t, data.Session.Active)
+	assert.True(t, refreshedData.Session.Active)
+
+	assert.True(t, data.Session.TimeoutAt.IsZero())
+	assert.True(t, refreshedData.Session.TimeoutAt.IsZero())
+
+	assert.Equal(t, int64(-1), data.Session.TimeoutInSeconds)
+	assert.Equal(t, int64(-1), refreshedData.Session.TimeoutInSeconds)
+}
+
+func TestHandler_SessionRefresh_SubjectMismatch(t *testing.T) {
+	cfg := mock.Config()
+	cfg.Session.Refresh = true
+
+	idp := mock.NewIdentityProvider(cfg)
+	idp.ProviderHandler.TokenDuration = 5 * time.Second
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	// wait until refresh cooldown has reached zero before refresh
+	waitForRefreshCooldownTimer(t, idp, rpClient)
+
+	idp.ProviderHandler.IDTokenClaims["sub"] = "some-other-subject"
+
+	resp := sessionRefresh(t, idp, rpClient)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// session should be invalidated
+	resp = sessionInfo(t, idp, rpClient)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestHandler_SessionRefresh_InvalidIDToken(t *testing.T) {
+	cfg := mock.Config()
+	cfg.Session.Refresh = true
+
+	idp := mock.NewIdentityProvider(cfg)
+	idp.ProviderHandler.TokenDuration = 5 * time.Second
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	// wait until refresh cooldown has reached zero before refresh
+	waitForRefreshCooldownTimer(t, idp, rpClient)
+
+	idp.ProviderHandler.IDTokenClaims["aud"] = "some-other-client"
+
+	resp := sessionRefresh(t, idp, rpClient)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// session should be invalidated rather than keeping the spent refresh token
+	resp = sessionInfo(t, idp, rpClient)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestHandler_SessionRefresh_RotatedSigningKeys(t *testing.T) {
+	cfg := mock.Config()
+	cfg.Session.Refresh = true
+
+	idp := mock.NewIdentityProvider(cfg)
+	idp.ProviderHandler.TokenDuration = 5 * time.Second
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	// wait until refresh cooldown has reached zero before refresh
+	waitForRefreshCooldownTimer(t, idp, rpClient)
+
+	idp.ProviderHandler.Provider.RotateKeys()
+
+	resp := sessionRefresh(t, idp, rpClient)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestHandler_SessionRefresh_JwksUnavailable(t *testing.T) {
+	cfg := mock.Config()
+	cfg.Session.Refresh = true
+
+	idp := mock.NewIdentityProvider(cfg)
+	idp.ProviderHandler.TokenDuration = 5 * time.Second
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	// wait until refresh cooldown has reached zero before refresh
+	waitForRefreshCooldownTimer(t, idp, rpClient)
+
+	idp.ProviderHandler.Provider.SetError(errors.New("jwks unavailable"))
+
+	resp := sessionRefresh(t, idp, rpClient)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	// transient failures should not invalidate the session
+	idp.ProviderHandler.Provider.SetError(nil)
+
+	resp = sessionInfo(t, idp, rpClient)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestHandler_SessionRefresh_Disabled(t *testing.T) {
+	cfg := mock.Config()
+	cfg.Session.Refresh = false
+
+	idp := mock.NewIdentityProvider(cfg)
+	idp.ProviderHandler.TokenDuration = 5 * time.Second
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	resp := sessionRefresh(t, idp, rpClient)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHandler_SessionRefresh_WithInactivity(t *testing.T) {
+	cfg := mock.Config()
+	cfg.Session.Refresh = true
+	cfg.Session.Inactivity = true
+	cfg.Session.InactivityTimeout = 10 * time.Minute
+
+	idp := mock.NewIdentityProvider(cfg)
+	idp.ProviderHandler.TokenDuration = 5 * time.Second
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	// get initial session info
+	resp := sessionInfo(t, idp, rpClient)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data session.MetadataVerboseWithRefresh
+	err := json.Unmarshal([]byte(resp.Body), &data)
+	assert.NoError(t, err)
+
+	// wait until refresh cooldown has reached zero before refresh
+	waitForRefreshCooldownTimer(t, idp, rpClient)
+
+	resp = sessionRefresh(t, idp, rpClient)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var refreshedData session.MetadataVerboseWithRefresh
+	err = json.Unmarshal([]byte(resp.Body), &refreshedData)
+	assert.NoError(t, err)
+
+	maxDelta := 5 * time.Second
+
+	assert.True(t, data.Session.Active)
+	assert.True(t, refreshedData.Session.Active)
+
+	assert.False(t, data.Session.TimeoutAt.IsZero())
+	assert.False(t, refreshedData.Session.TimeoutAt.IsZero())
+
+	expectedTimeoutAt := time.Now().Add(cfg.Session.InactivityTimeout)
+	assert.WithinDuration(t, expectedTimeoutAt, data.Session.TimeoutAt, maxDelta)
+	assert.WithinDuration(t, expectedTimeoutAt, refreshedData.Session.TimeoutAt, maxDelta)
+
+	assert.True(t, refreshedData.Session.TimeoutAt.After(data.Session.TimeoutAt))
+
+	previousTimeoutDuration := time.Duration(data.Session.TimeoutInSeconds) * time.Second
+	assert.WithinDuration(t, expectedTimeoutAt, time.Now().Add(previousTimeoutDuration), maxDelta)
+
+	refreshedTimeoutDuration := time.Duration(refreshedData.Session.TimeoutInSeconds) * time.Second
+	assert.WithinDuration(t, expectedTimeoutAt, time.Now().Add(refreshedTimeoutDuration), maxDelta)
+}
+
+func TestHandler_UpstreamRoutes(t *testing.T) {
+	defaultUpstream := newNamedUpstream(t, "default")
+	defer defaultUpstream.Close()
+	apiUpstream := newNamedUpstream(t, "api")
+	defer apiUpstream.Close()
+	staticUpstream := newNamedUpstream(t, "static")
+	defer staticUpstream.Close()
+	otherHostUpstream := newNamedUpstream(t, "other-host")
+	defer otherHostUpstream.Close()
+
+	routes := []config.UpstreamRoute{
+		{
+			PathPrefix: "/api",
+			Upstream:   hostOf(t, apiUpstream.URL),
+			Token:      &config.UpstreamToken{Type: config.TokenTypeNone},
+		},
+		{
+			Host:       "127.0.0.1",
+			PathPrefix: "/static/",
+			Upstream:   hostOf(t, staticUpstream.URL),
+			AutoLogin:  new(bool),
+		},
+		{
+			Host:      "not-wonderwall.example.com",
+			Upstream:  hostOf(t, otherHostUpstream.URL),
+			AutoLogin: new(bool),
+		},
+	}
+	rawRoutes, err := json.Marshal(routes)
+	assert.NoError(t, err)
+
+	cfg := mock.Config()
+	cfg.AutoLogin = true
+	cfg.UpstreamHost = hostOf(t, defaultUpstream.URL)
+	cfg.UpstreamRoutes = string(rawRoutes)
+
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+
+	// auto-login is disabled for the static route
+	resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/static/index.html")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "static: no token", resp.Body)
+
+	// auto-login still applies to other routes
+	resp = get(t, rpClient, idp.RelyingPartyServer.URL+"/api/users")
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.Equal(t, "/oauth2/login", resp.Location.Path)
+
+	login(t, rpClient, idp)
+
+	for _, test := range []struct {
+		path     string
+		expected string
+	}{
+		{path: "/api", expected: "api: no token"},
+		{path: "/api/users", expected: "api: no token"},
+		{path: "/apis", expected: "default: token"},
+		{path: "/api/../users", expected: "default: token"},
+		{path: "/api//users", expected: "api: no token"},
+		{path: "/static/index.html", expected: "static: token"},
+		{path: "/", expected: "default: token"},
+	} {
+		resp = get(t, rpClient, idp.RelyingPartyServer.URL+test.path)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, test.path)
+		assert.Equal(t, test.expected, resp.Body, test.path)
+	}
+
+	// routes with a host only match requests for that host
+	req, err := http.NewRequest(http.MethodGet, idp.RelyingPartyServer.URL+"/static/index.html", nil)
+	assert.NoError(t, err)
+	req.Host = "not-wonderwall.example.com"
+
+	hostResp, err := rpClient.Do(req)
+	assert.NoError(t, err)
+	defer hostResp.Body.Close()
+
+	body, err := io.ReadAll(hostResp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "other-host: no token", string(body))
+}
+
+func TestHandler_UpstreamTLSAndUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	tlsUpstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		_, _ = fmt.Fprintf(w, "tls: sni=%s, client-certs=%d, token=%t", r.TLS.ServerName, len(r.TLS.PeerCertificates), len(token) > 0)
+	}))
+	tlsUpstream.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	tlsUpstream.StartTLS()
+	defer tlsUpstream.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsUpstream.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(caFile, caPem, 0o600))
+	certFile, keyFile := writeClientCertificate(t, dir)
+
+	socket := filepath.Join(dir, "upstream.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	unixUpstream := httptest.NewUnstartedServer(namedUpstreamHandler("unix"))
+	unixUpstream.Listener = listener
+	unixUpstream.Start()
+	defer unixUpstream.Close()
+
+	routes := []config.UpstreamRoute{
+		{
+			PathPrefix: "/tls",
+			Upstream:   tlsUpstream.URL,
+			TLS: &config.UpstreamTLS{
+				ServerName: "example.com",
+			},
+		},
+	}
+	rawRoutes, err := json.Marshal(routes)
+	assert.NoError(t, err)
+
+	cfg := mock.Config()
+	cfg.UpstreamHost = "unix://" + socket
+	cfg.UpstreamRoutes = string(rawRoutes)
+	cfg.UpstreamTLS = config.UpstreamTLS{
+		CAFile:   caFile,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+	login(t, rpClient, idp)
+
+	resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/tls/resource")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "tls: sni=example.com, client-certs=1, token=true", resp.Body)
+
+	resp = get(t, rpClient, idp.RelyingPartyServer.URL+"/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "unix: token", resp.Body)
+
+	t.Run("untrusted upstream certificate", func(t *testing.T) {
+		cfg := mock.Config()
+		cfg.UpstreamHost = tlsUpstream.URL
+
+		idp := mock.NewIdentityProvider(cfg)
+		defer idp.Close()
+
+		resp := get(t, idp.RelyingPartyClient(), idp.RelyingPartyServer.URL+"/")
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	})
+}
+
+func TestHandler_AuthRules(t *testing.T) {
+	up := newNamedUpstream(t, "upstream")
+	defer up.Close()
+
+	rules := []config.AuthRule{
+		{Path: "/api/public/**", Mode: config.AuthModePassthrough},
+		{Path: "/api/**", Mode: config.AuthModeDeny},
+		{Path: "/app/", Mode: config.AuthModeRedirect},
+	}
+	rawRules, err := json.Marshal(rules)
+	assert.NoError(t, err)
+
+	cfg := mock.Config()
+	cfg.AuthRules = string(rawRules)
+	cfg.UpstreamHost = hostOf(t, up.URL)
+
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+
+	t.Run("passthrough", func(t *testing.T) {
+		resp := postForm(t, rpClient, idp.RelyingPartyServer.URL+"/api/public/health", url.Values{})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "upstream: no token", resp.Body)
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		expectedLocation := "/oauth2/login?redirect-encoded=" + urlpkg.RedirectEncoded("/api/users")
+
+		for _, resp := range []response{
+			get(t, rpClient, idp.RelyingPartyServer.URL+"/api/users"),
+			postForm(t, rpClient, idp.RelyingPartyServer.URL+"/api/users", url.Values{}),
+		} {
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+			assert.Equal(t, expectedLocation, resp.Location.RequestURI())
+			assert.JSONEq(t, `{"error": "unauthenticated", "login_url": "`+expectedLocation+`"}`, resp.Body)
+		}
+	})
+
+	t.Run("non-canonical paths", func(t *testing.T) {
+		for _, path := range []string{
+			"/api/public/../users",
+			"/public/../api/users",
+			"//api/users",
+			"/api//users",
+			"/public/%2e%2e/api/users",
+			"/api/public/%2E%2E/users",
+		} {
+			t.Run(path, func(t *testing.T) {
+				resp := get(t, rpClient, idp.RelyingPartyServer.URL+path)
+				assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+				assert.NotContains(t, resp.Body, "upstream")
+			})
+		}
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/app")
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		assert.Equal(t, "/oauth2/login", resp.Location.Path)
+
+		// other methods than GET cannot be redirected
+		resp = postForm(t, rpClient, idp.RelyingPartyServer.URL+"/app", url.Values{})
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("unmatched paths fall back to auto-login", func(t *testing.T) {
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/other")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "upstream: no token", resp.Body)
+	})
+
+	t.Run("authenticated", func(t *testing.T) {
+		login(t, rpClient, idp)
+
+		resp := postForm(t, rpClient, idp.RelyingPartyServer.URL+"/api/users", url.Values{})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "upstream: token", resp.Body)
+	})
+}
+
+func TestHandler_AuthorizationPolicies(t *testing.T) {
+	up := newNamedUpstream(t, "upstream")
+	defer up.Close()
+
+	policies := []config.AuthorizationPolicy{
+		{
+			Path:   "/admin/**",
+			Claims: map[string][]string{"groups": {"group-admin"}},
+		},
+		{
+			Path:    "/reports/**",
+			Methods: []string{http.MethodPost},
+			Claims:  map[string][]string{"roles": {"writer"}},
+		},
+		{
+			Path:         "/app/**",
+			Claims:       map[string][]string{"groups": {"group-a", "group-b"}, "acr": {"Level4"}},
+			EmailDomains: []string{"example.com"},
+		},
+		{
+			Path:   "/reader/**",
+			Claims: map[string][]string{"roles": {"reader"}},
+		},
+	}
+	rawPolicies, err := json.Marshal(policies)
+	assert.NoError(t, err)
+
+	cfg := mock.Config()
+	cfg.AuthorizationPolicies = string(rawPolicies)
+	cfg.OpenID.UserInfo = true
+	cfg.UpstreamHost = hostOf(t, up.URL)
+
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	idp.ProviderHandler.IDTokenClaims["groups"] = []string{"group-a"}
+	idp.ProviderHandler.IDTokenClaims["email"] = "user@Example.com"
+	idp.ProviderHandler.UserInfoClaims["roles"] = []string{"reader"}
+
+	rpClient := idp.RelyingPartyClient()
+
+	// unauthenticated requests matching a policy require login
+	resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/admin")
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.Equal(t, "/oauth2/login", resp.Location.Path)
+
+	resp = postForm(t, rpClient, idp.RelyingPartyServer.URL+"/reports", url.Values{})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	login(t, rpClient, idp)
+
+	for _, test := range []struct {
+		name     string
+		method   string
+		path     string
+		expected int
+	}{
+		{name: "missing group", method: http.MethodGet, path: "/admin/users", expected: http.StatusForbidden},
+		{name: "missing group with dot segments", method: http.MethodGet, path: "/x/../admin/users", expected: http.StatusForbidden},
+		{name: "missing group with encoded dot segments", method: http.MethodGet, path: "/x/%2e%2e/admin/users", expected: http.StatusForbidden},
+		{name: "missing group with repeated slashes", method: http.MethodGet, path: "//admin//users", expected: http.StatusForbidden},
+		{name: "method not covered by policy", method: http.MethodGet, path: "/reports", expected: http.StatusOK},
+		{name: "missing role for method", method: http.MethodPost, path: "/reports", expected: http.StatusForbidden},
+		{name: "all claims and email domain", method: http.MethodGet, path: "/app/", expected: http.StatusOK},
+		{name: "claim from userinfo", method: http.MethodGet, path: "/reader/books", expected: http.StatusOK},
+		{name: "no matching policy", method: http.MethodGet, path: "/", expected: http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var resp response
+			if test.method == http.MethodPost {
+				resp = postForm(t, rpClient, idp.RelyingPartyServer.URL+test.path, url.Values{})
+			} else {
+				resp = get(t, rpClient, idp.RelyingPartyServer.URL+test.path)
+			}
+
+			assert.Equal(t, test.expected, resp.StatusCode)
+			if test.expected == http.StatusForbidden {
+				assert.Contains(t, resp.Body, "ikke tilgang")
+			} else {
+				assert.Equal(t, "upstream: token", resp.Body)
+			}
+		})
+	}
+}
+
+func TestHandler_BearerToken(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer up.Close()
+
+	cfg := mock.Config()
+	cfg.AutoLogin = true
+	cfg.UpstreamHost = hostOf(t, up.URL)
+	cfg.BearerToken = config.BearerToken{
+		Enabled:     true,
+		Audiences:   []string{"some-api", "other-api"},
+		SigningAlgs: []string{"RS256"},
+	}
+	cfg.AuthorizationPolicies = `[{"path": "/api", "claims": {"scope": ["api:read"]}}]`
+
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	bearerToken := func(modify func(token jwtlib.Token)) string {
+		token := jwtlib.New()
+		token.Set("iss", idp.OpenIDConfig.Provider().Issuer())
+		token.Set("aud", "other-api")
+		token.Set("sub", "machine-client")
+		token.Set("scope", "api:read")
+		token.Set("iat", time.Now().Unix())
+		token.Set("exp", time.Now().Add(time.Minute).Unix())
+		if modify != nil {
+			modify(token)
+		}
+
+		signed, err := idp.ProviderHandler.SignToken(token)
+		assert.NoError(t, err)
+		return signed
+	}
+
+	requestWithToken := func(token string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, idp.RelyingPartyServer.URL+"/api", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		client := idp.RelyingPartyClient()
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	t.Run("valid token is forwarded unchanged", func(t *testing.T) {
+		token := bearerToken(nil)
+
+		resp := requestWithToken(token)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer "+token, string(body))
+	})
+
+	for _, test := range []struct {
+		name   string
+		token  string
+		modify func(token jwtlib.Token)
+	}{
+		{
+			name: "unaccepted audience",
+			modify: func(token jwtlib.Token) {
+				token.Set("aud", "client-id")
+			},
+		},
+		{
+			name: "wrong issuer",
+			modify: func(token jwtlib.Token) {
+				token.Set("iss", "https://not-the-idp.example.com")
+			},
+		},
+		{
+			name: "expired",
+			modify: func(token jwtlib.Token) {
+				token.Set("exp", time.Now().Add(-time.Hour).Unix())
+			},
+		},
+		{
+			name:  "invalid signature",
+			token: "eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJtYWNoaW5lLWNsaWVudCJ9.invalid",
+		},
+		{
+			name:  "unaccepted signing algorithm",
+			token: "eyJhbGciOiJub25lIn0.eyJzdWIiOiJtYWNoaW5lLWNsaWVudCJ9.",
+		},
+		{
+			name: "id_token",
+			modify: func(token jwtlib.Token) {
+				token.Set("nonce", "some-nonce")
+				token.Set("at_hash", "some-hash")
+			},
+		},
+		{
+			name: "JWT secured authorization response",
+			modify: func(token jwtlib.Token) {
+				token.Remove("sub")
+				token.Remove("scope")
+				token.Set("code", "some-code")
+				token.Set("state", "some-state")
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			token := test.token
+			if len(token) == 0 {
+				token = bearerToken(test.modify)
+			}
+
+			resp := requestWithToken(token)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+			assert.Equal(t, `Bearer error="invalid_token"`, resp.Header.Get("WWW-Authenticate"))
+		})
+	}
+
+	t.Run("token without claims required by authorization policy", func(t *testing.T) {
+		token := bearerToken(func(token jwtlib.Token) {
+			token.Set("scope", "api:write")
+		})
+
+		resp := requestWithToken(token)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Equal(t, `Bearer error="insufficient_scope"`, resp.Header.Get("WWW-Authenticate"))
+	})
+
+	t.Run("requests without bearer token use sessions", func(t *testing.T) {
+		rpClient := idp.RelyingPartyClient()
+
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/api")
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		assert.Equal(t, "/oauth2/login", resp.Location.Path)
+	})
+}
+
+func TestHandler_AuthzCallout(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Tenant")))
+	}))
+	defer up.Close()
+
+	var calls atomic.Int32
+	policyService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+
+		var request struct {
+			Input authz.CalloutInput `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		input := request.Input
+		switch {
+		case input.Path == "/unavailable":
+			w.WriteHeader(http.StatusInternalServerError)
+		case input.Path == "/public":
+			_, _ = w.Write([]byte(`{"result": {"allow": true}}`))
+		case input.Claims["sub"] == nil:
+			_, _ = w.Write([]byte(`{"result": {"allow": false}}`))
+		case input.Path == "/admin" && input.Headers["X-Department"] != "it":
+			_, _ = w.Write([]byte(`{"result": {"allow": false}}`))
+		default:
+			_, _ = w.Write([]byte(`{"result": {"allow": true, "headers": {"X-Tenant": "tenant-1"}}}`))
+		}
+	}))
+	defer policyService.Close()
+
+	cfg := mock.Config()
+	cfg.UpstreamHost = hostOf(t, up.URL)
+	cfg.AuthzCallout = config.AuthzCallout{
+		URL:      policyService.URL,
+		Timeout:  time.Second,
+		CacheTTL: time.Minute,
+		Headers:  []string{"x-department"},
+	}
+
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
+
+	rpClient := idp.RelyingPartyClient()
+
+	// unauthenticated requests are sent without claims
+	resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/public")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = get(t, rpClient, idp.RelyingPartyServer.URL+"/")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	login(t, rpClient, idp)
+
+	t.Run("allowed with injected headers", func(t *testing.T) {
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "tenant-1", resp.Body)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/admin")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Contains(t, resp.Body, "ikke tilgang")
+	})
+
+	t.Run("canonical path is sent", func(t *testing.T) {
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/x/../admin")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = get(t, rpClient, idp.RelyingPartyServer.URL+"//admin/")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("configured headers are included", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, idp.RelyingPartyServer.URL+"/admin", nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-Department", "it")
+
+		resp, err := rpClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("decisions are cached", func(t *testing.T) {
+		before := calls.Load()
+
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/cached")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = get(t, rpClient, idp.RelyingPartyServer.URL+"/cached")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		assert.Equal(t, before+1, calls.Load())
+	})
+
+	t.Run("unavailable service denies requests", func(t *testing.T) {
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/unavailable")
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Contains(t, resp.Body, `href="/unavailable"`)
+
+		req, err := http.NewRequest(http.MethodGet, idp.RelyingPartyServer.URL+"/unavailable", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Sec-Fetch-Mode", "cors")
+
+		r, err := rpClient.Do(req)
+		assert.NoError(t, err)
+		defer r.Body.Close()
+
+		var body errorhandler.Response
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, http.StatusServiceUnavailable, r.StatusCode)
+		assert.Equal(t, "service_unavailable", body.Error)
+	})
+}
+
+func TestHandler_WebSocket(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		_ = rw.Flush()
+
+		// echo lines until the connection is closed
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			_, _ = rw.WriteString(line)
+			_ = rw.Flush()
+		}
+	}))
+	defer up.Close()
+
+	dial := func(t *testing.T, idp *mock.IdentityProvider, rpClient *http.Client) (net.Conn, *bufio.Reader) {
+		rpURL, err := url.Parse(idp.RelyingPartyServer.URL)
+		assert.NoError(t, err)
+
+		conn, err := net.Dial("tcp", rpURL.Host)
+		assert.NoError(t, err)
+
+		req, err := http.NewRequest(http.MethodGet, idp.RelyingPartyServer.URL+"/ws", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		for _, c := range rpClient.Jar.Cookies(rpURL) {
+			req.AddCookie(c)
+		}
+		assert.NoError(t, req.Write(conn))
+
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+		_, err = conn.Write([]byte("ping\n"))
+		assert.NoError(t, err)
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "ping\n", line)
+
+		return conn, reader
+	}
+
+	assertClosed := func(t *testing.T, conn net.Conn, reader *bufio.Reader) {
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		_, err := reader.ReadString('\n')
+		assert.ErrorIs(t, err, io.EOF)
+	}
+
+	t.Run("closed on logout", func(t *testing.T) {
+		cfg := mock.Config()
+		cfg.UpstreamHost = hostOf(t, up.URL)
+
+		idp := mock.NewIdentityProvider(cfg)
+		defer idp.Close()
+
+		rpClient := idp.RelyingPartyClient()
+		login(t, rpClient, idp)
+
+		conn, reader := dial(t, idp, rpClient)
+		defer conn.Close()
 
-	resp := sessionInfo(t, idp, rpClient)
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
+		localLogout(t, rpClient, idp)
+		assertClosed(t, conn, reader)
+	})
 
-	var data session.MetadataVerbose
-	err := json.Unmarshal([]byte(resp.Body), &data)
-	assert.NoError(t, err)
+	t.Run("closed when session ends", func(t *testing.T) {
+		cfg := mock.Config()
+		cfg.UpstreamHost = hostOf(t, up.URL)
+		cfg.Session.MaxLifetime = 2 * time.Second
 
-	maxDelta := 5 * time.Second
+		idp := mock.NewIdentityProvider(cfg)
+		defer idp.Close()
 
-	assert.True(t, data.Session.Active)
-	assert.False(t, data.Session.TimeoutAt.IsZero())
+		rpClient := idp.RelyingPartyClient()
+		login(t, rpClient, idp)
 
-	expectedTimeoutAt := time.Now().Add(cfg.Session.InactivityTimeout)
-	assert.WithinDuration(t, expectedTimeoutAt, data.Session.TimeoutAt, maxDelta)
+		conn, reader := dial(t, idp, rpClient)
+		defer conn.Close()
 
-	actualTimeoutDuration := time.Duration(data.Session.TimeoutInSeconds) * time.Second
-	assert.WithinDuration(t, expectedTimeoutAt, time.Now().Add(actualTimeoutDuration), maxDelta)
+		assertClosed(t, conn, reader)
+	})
 }
 
-func TestHandler_SessionInfo_WithRefresh(t *testing.T) {
+func TestHandler_UpstreamRequestSanitization(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(r.Header)
+	}))
+	defer up.Close()
+
 	cfg := mock.Config()
-	cfg.Session.Refresh = true
+	cfg.UpstreamHost = hostOf(t, up.URL)
+	cfg.UpstreamRequest = config.UpstreamRequest{
+		AllowedHeaders:         []string{"x-wonderwall-allowed"},
+		ReservedHeaderPrefixes: []string{"X-Wonderwall-", "x-auth-"},
+	}
 
 	idp := mock.NewIdentityProvider(cfg)
-	idp.ProviderHandler.TokenDuration = 5 * time.Minute
 	defer idp.Close()
 
 	rpClient := idp.RelyingPartyClient()
 	login(t, rpClient, idp)
 
-	resp := sessionInfo(t, idp, rpClient)
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
-
-	var data session.MetadataVerboseWithRefresh
-	err := json.Unmarshal([]byte(resp.Body), &data)
+	rpURL, err := url.Parse(idp.RelyingPartyServer.URL)
 	assert.NoError(t, err)
+	rpClient.Jar.SetCookies(rpURL, []*http.Cookie{
+		{Name: "other", Value: "value"},
+		{Name: cookie.Retry, Value: "/"},
+	})
 
-	allowedSkew := 5 * time.Second
-	assert.WithinDuration(t, time.Now(), data.Session.CreatedAt, allowedSkew)
-	assert.WithinDuration(t, time.Now().Add(cfg.Session.MaxLifetime), data.Session.EndsAt, allowedSkew)
-	assert.WithinDuration(t, time.Now().Add(idp.ProviderHandler.TokenDuration), data.Tokens.ExpireAt, allowedSkew)
-	assert.WithinDuration(t, time.Now(), data.Tokens.RefreshedAt, allowedSkew)
-
-	sessionEndDuration := time.Duration(data.Session.EndsInSeconds) * time.Second
-	// 1 second < time until session ends <= configured max session lifetime
-	assert.LessOrEqual(t, sessionEndDuration, cfg.Session.MaxLifetime)
-	assert.Greater(t, sessionEndDuration, time.Second)
+	req, err := http.NewRequest(http.MethodGet, idp.RelyingPartyServer.URL+"/", nil)
+	assert.NoError(t, err)
+	req.Header.Set("X-Wonderwall-User", "spoofed")
+	req.Header.Set("X-Auth-Email", "spoofed@example.com")
+	req.Header.Set("X-Wonderwall-Allowed", "allowed")
+	req.Header.Set("X-Other", "other")
 
-	tokenExpiryDuration := time.Duration(data.Tokens.ExpireInSeconds) * time.Second
-	// 1 second < time until token expires <= max duration for tokens from IDP
-	assert.LessOrEqual(t, tokenExpiryDuration, idp.ProviderHandler.TokenDuration)
-	assert.Greater(t, tokenExpiryDuration, time.Second)
+	resp, err := rpClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-	// 1 second < next token refresh <= seconds until token expires
-	assert.LessOrEqual(t, data.Tokens.NextAutoRefreshInSeconds, data.Tokens.ExpireInSeconds)
-	assert.Greater(t, data.Tokens.NextAutoRefreshInSeconds, int64(1))
+	var headers http.Header
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&headers))
 
-	assert.True(t, data.Tokens.RefreshCooldown)
-	// 1 second < refresh cooldown <= minimum refresh interval
-	assert.LessOrEqual(t, data.Tokens.RefreshCooldownSeconds, session.RefreshMinInterval)
-	assert.Greater(t, data.Tokens.RefreshCooldownSeconds, int64(1))
+	assert.Empty(t, headers.Get("X-Wonderwall-User"))
+	assert.Empty(t, headers.Get("X-Auth-Email"))
+	assert.Equal(t, "allowed", headers.Get("X-Wonderwall-Allowed"))
+	assert.Equal(t, "other", headers.Get("X-Other"))
+	assert.NotEmpty(t, headers.Get("Authorization"))
 
-	assert.True(t, data.Session.Active)
-	assert.True(t, data.Session.TimeoutAt.IsZero())
-	assert.Equal(t, int64(-1), data.Session.TimeoutInSeconds)
+	assert.Equal(t, "other=value", headers.Get("Cookie"))
 }
 
-func TestHandler_SessionRefresh(t *testing.T) {
+func TestHandler_UpstreamToken(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(r.Header)
+	}))
+	defer up.Close()
+
+	upstream := hostOf(t, up.URL)
+	keep, enabled := true, true
+	routes := []config.UpstreamRoute{
+		{PathPrefix: "/id", Upstream: upstream, Token: &config.UpstreamToken{Type: config.TokenTypeIDToken, Header: "X-Id-Token"}},
+		{PathPrefix: "/exchanged", Upstream: upstream, Token: &config.UpstreamToken{Type: config.TokenTypeExchanged, Audience: "downstream-api"}},
+		{PathPrefix: "/basic", Upstream: upstream, Token: &config.UpstreamToken{KeepAuthorization: &keep}},
+		{PathPrefix: "/none", Upstream: upstream, Token: &config.UpstreamToken{Type: config.TokenTypeNone}},
+		{PathPrefix: "/session", Upstream: upstream, Token: &config.UpstreamToken{SessionHeaders: &enabled}},
+	}
+	rawRoutes, err := json.Marshal(routes)
+	assert.NoError(t, err)
+
 	cfg := mock.Config()
-	cfg.Session.Refresh = true
+	cfg.UpstreamHost = upstream
+	cfg.UpstreamRoutes = string(rawRoutes)
 
 	idp := mock.NewIdentityProvider(cfg)
-	idp.ProviderHandler.TokenDuration = 5 * time.Second
 	defer idp.Close()
 
 	rpClient := idp.RelyingPartyClient()
 	login(t, rpClient, idp)
 
-	// get initial session info
-	resp := sessionInfo(t, idp, rpClient)
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	headersFor := func(t *testing.T, path string, modify func(r *http.Request)) http.Header {
+		req, err := http.NewRequest(http.MethodGet, idp.RelyingPartyServer.URL+path, nil)
+		assert.NoError(t, err)
+		if modify != nil {
+			modify(req)
+		}
 
-	var data session.MetadataVerboseWithRefresh
-	err := json.Unmarshal([]byte(resp.Body), &data)
-	assert.NoError(t, err)
+		resp, err := rpClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-	// wait until refresh cooldown has reached zero before refresh
-	waitForRefreshCooldownTimer(t, idp, rpClient)
+		var headers http.Header
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&headers))
+		return headers
+	}
 
-	resp = sessionRefresh(t, idp, rpClient)
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	claims := func(t *testing.T, raw string) jwtlib.Token {
+		token, err := jwtlib.ParseInsecure([]byte(raw))
+		assert.NoError(t, err)
+		return token
+	}
 
-	var refreshedData session.MetadataVerboseWithRefresh
-	err = json.Unmarshal([]byte(resp.Body), &refreshedData)
-	assert.NoError(t, err)
+	t.Run("access token by default", func(t *testing.T) {
+		headers := headersFor(t, "/", func(r *http.Request) {
+			r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+		})
 
-	// session create and end times should be unchanged
-	assert.WithinDuration(t, data.Session.CreatedAt, refreshedData.Session.CreatedAt, 0)
-	assert.WithinDuration(t, data.Session.EndsAt, refreshedData.Session.EndsAt, 0)
+		token := claims(t, strings.TrimPrefix(headers.Get("Authorization"), "Bearer "))
+		assert.NotContains(t, token.Audience(), "downstream-api")
+		assert.Empty(t, headers.Get(reverseproxy.HeaderSessionID))
+	})
 
-	// token expiration and refresh times should be later than before
-	assert.True(t, refreshedData.Tokens.ExpireAt.After(data.Tokens.ExpireAt))
-	assert.True(t, refreshedData.Tokens.RefreshedAt.After(data.Tokens.RefreshedAt))
+	t.Run("id_token in custom header", func(t *testing.T) {
+		headers := headersFor(t, "/id", func(r *http.Request) {
+			r.Header.Set("X-Id-Token", "spoofed")
+		})
 
-	allowedSkew := 5 * time.Second
-	assert.WithinDuration(t, time.Now().Add(idp.ProviderHandler.TokenDuration), refreshedData.Tokens.ExpireAt, allowedSkew)
-	assert.WithinDuration(t, time.Now(), refreshedData.Tokens.RefreshedAt, allowedSkew)
+		token := claims(t, headers.Get("X-Id-Token"))
+		assert.Contains(t, token.Audience(), idp.OpenIDConfig.Client().ClientID())
+		assert.Empty(t, headers.Get("Authorization"))
+	})
 
-	sessionEndDuration := time.Duration(refreshedData.Session.EndsInSeconds) * time.Second
-	// 1 second < time until session ends <= configured max session lifetime
-	assert.LessOrEqual(t, sessionEndDuration, cfg.Session.MaxLifetime)
-	assert.Greater(t, sessionEndDuration, time.Second)
+	t.Run("exchanged token is cached", func(t *testing.T) {
+		headers := headersFor(t, "/exchanged", nil)
 
-	tokenExpiryDuration := time.Duration(refreshedData.Tokens.ExpireInSeconds) * time.Second
-	// 1 second < time until token expires <= max duration for tokens from IDP
-	assert.LessOrEqual(t, tokenExpiryDuration, idp.ProviderHandler.TokenDuration)
-	assert.Greater(t, tokenExpiryDuration, time.Second)
+		raw := strings.TrimPrefix(headers.Get("Authorization"), "Bearer ")
+		assert.Equal(t, []string{"downstream-api"}, claims(t, raw).Audience())
 
-	// 1 second < next token refresh <= seconds until token expires
-	assert.LessOrEqual(t, refreshedData.Tokens.NextAutoRefreshInSeconds, refreshedData.Tokens.ExpireInSeconds)
-	assert.Greater(t, refreshedData.Tokens.NextAutoRefreshInSeconds, int64(1))
+		headers = headersFor(t, "/exchanged", nil)
+		assert.Equal(t, "Bearer "+raw, headers.Get("Authorization"))
+		assert.Equal(t, 1, idp.ProviderHandler.TokenExchanges)
+	})
 
-	assert.True(t, refreshedData.Tokens.RefreshCooldown)
-	// 1 second < refresh cooldown <= minimum refresh interval
-	assert.LessOrEqual(t, refreshedData.Tokens.RefreshCooldownSeconds, session.RefreshMinInterval)
-	assert.Greater(t, refreshedData.Tokens.RefreshCooldownSeconds, int64(1))
+	t.Run("existing authorization header is kept", func(t *testing.T) {
+		headers := headersFor(t, "/basic", func(r *http.Request) {
+			r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+		})
+		assert.Equal(t, "Basic dXNlcjpwYXNz", headers.Get("Authorization"))
 
-	assert.True(t, data.Session.Active)
-	assert.True(t, refreshedData.Session.Active)
+		headers = headersFor(t, "/basic", nil)
+		assert.True(t, strings.HasPrefix(headers.Get("Authorization"), "Bearer "))
+	})
 
-	assert.True(t, data.Session.TimeoutAt.IsZero())
-	assert.True(t, refreshedData.Session.TimeoutAt.IsZero())
+	t.Run("no token", func(t *testing.T) {
+		headers := headersFor(t, "/none", nil)
+		assert.Empty(t, headers.Get("Authorization"))
+	})
 
-	assert.Equal(t, int64(-1), data.Session.TimeoutInSeconds)
-	assert.Equal(t, int64(-1), refreshedData.Session.TimeoutInSeconds)
+	t.Run("session headers", func(t *testing.T) {
+		headers := headersFor(t, "/session", nil)
+		assert.NotEmpty(t, headers.Get(reverseproxy.HeaderSessionID))
+		assert.NotEmpty(t, headers.Get(reverseproxy.HeaderTokenExpiresAt))
+
+		endsAt, err := time.Parse(time.RFC3339, headers.Get(reverseproxy.HeaderSessionEndsAt))
+		assert.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(cfg.Session.MaxLifetime), endsAt, time.Minute)
+	})
 }
 
-func TestHandler_SessionRefresh_Disabled(t *testing.T) {
+func TestHandler_CORS(t *testing.T) {
+	up := newNamedUpstream(t, "upstream")
+	defer up.Close()
+
 	cfg := mock.Config()
-	cfg.Session.Refresh = false
+	cfg.UpstreamHost = hostOf(t, up.URL)
+	cfg.CORS = config.CORS{
+		AllowCredentials: true,
+		AllowedOrigins:   []string{"https://app.example", "https://*.example.com"},
+		Enabled:          true,
+		MaxAge:           5 * time.Minute,
+	}
 
 	idp := mock.NewIdentityProvider(cfg)
-	idp.ProviderHandler.TokenDuration = 5 * time.Second
 	defer idp.Close()
 
 	rpClient := idp.RelyingPartyClient()
 	login(t, rpClient, idp)
 
-	resp := sessionRefresh(t, idp, rpClient)
-	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	request := func(method, path, origin string) *http.Response {
+		req, err := http.NewRequest(method, idp.RelyingPartyServer.URL+path, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "content-type")
+		}
+
+		resp, err := rpClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("preflight from allowed origin", func(t *testing.T) {
+		resp := request(http.MethodOptions, "/oauth2/session/refresh", "https://app.example")
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, "https://app.example", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "GET, POST", resp.Header.Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "content-type", resp.Header.Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "300", resp.Header.Get("Access-Control-Max-Age"))
+	})
+
+	t.Run("preflight from disallowed origin", func(t *testing.T) {
+		resp := request(http.MethodOptions, "/oauth2/session", "https://evil.example")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+	})
+
+	for _, test := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{origin: "https://app.example", allowed: true},
+		{origin: "https://sub.example.com", allowed: true},
+		{origin: idp.RelyingPartyServer.URL, allowed: true},
+		{origin: "https://example.com", allowed: false},
+		{origin: "http://sub.example.com", allowed: false},
+		{origin: "https://app.example.evil", allowed: false},
+	} {
+		t.Run("session from "+test.origin, func(t *testing.T) {
+			resp := request(http.MethodGet, "/oauth2/session", test.origin)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			if test.allowed {
+				assert.Equal(t, test.origin, resp.Header.Get("Access-Control-Allow-Origin"))
+			} else {
+				assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+			}
+		})
+	}
+
+	t.Run("proxied routes are not covered by default", func(t *testing.T) {
+		resp := request(http.MethodGet, "/", "https://app.example")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+	})
 }
 
-func TestHandler_SessionRefresh_WithInactivity(t *testing.T) {
+func TestHandler_NonNavigationalRequests(t *testing.T) {
+	up := newNamedUpstream(t, "upstream")
+	defer up.Close()
+
 	cfg := mock.Config()
-	cfg.Session.Refresh = true
-	cfg.Session.Inactivity = true
-	cfg.Session.InactivityTimeout = 10 * time.Minute
+	cfg.UpstreamHost = hostOf(t, up.URL)
+	cfg.AutoLogin = true
 
 	idp := mock.NewIdentityProvider(cfg)
-	idp.ProviderHandler.TokenDuration = 5 * time.Second
 	defer idp.Close()
 
 	rpClient := idp.RelyingPartyClient()
-	login(t, rpClient, idp)
-
-	// get initial session info
-	resp := sessionInfo(t, idp, rpClient)
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
-
-	var data session.MetadataVerboseWithRefresh
-	err := json.Unmarshal([]byte(resp.Body), &data)
-	assert.NoError(t, err)
 
-	// wait until refresh cooldown has reached zero before refresh
-	waitForRefreshCooldownTimer(t, idp, rpClient)
+	request := func(headers map[string]string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, idp.RelyingPartyServer.URL+"/api/me", nil)
+		assert.NoError(t, err)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
 
-	resp = sessionRefresh(t, idp, rpClient)
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp, err := rpClient.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
 
-	var refreshedData session.MetadataVerboseWithRefresh
-	err = json.Unmarshal([]byte(resp.Body), &refreshedData)
-	assert.NoError(t, err)
+	t.Run("navigation is redirected to login", func(t *testing.T) {
+		resp := request(map[string]string{"Sec-Fetch-Mode": "navigate"})
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	})
 
-	maxDelta := 5 * time.Second
+	for _, headers := range []map[string]string{
+		{"Sec-Fetch-Mode": "cors"},
+		{"X-Requested-With": "XMLHttpRequest"},
+		{"Accept": "application/json"},
+	} {
+		t.Run(fmt.Sprintf("%v is denied", headers), func(t *testing.T) {
+			resp := request(headers)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+			var body struct {
+				Error    string `json:"error"`
+				LoginURL string `json:"login_url"`
+			}
+			err := json.NewDecoder(resp.Body).Decode(&body)
+			assert.NoError(t, err)
+			assert.Equal(t, "unauthenticated", body.Error)
+			assert.Equal(t, urlpkg.LoginURL("", "/api/me"), body.LoginURL)
+		})
+	}
+}
 
-	assert.True(t, data.Session.Active)
-	assert.True(t, refreshedData.Session.Active)
+func TestHandler_PopupLogin(t *testing.T) {
+	cfg := mock.Config()
+	cfg.RedirectAllowedOrigins = []string{"https://portal.example"}
+	idp := mock.NewIdentityProvider(cfg)
+	defer idp.Close()
 
-	assert.False(t, data.Session.TimeoutAt.IsZero())
-	assert.False(t, refreshedData.Session.TimeoutAt.IsZero())
+	for _, test := range []struct {
+		name           string
+		redirect       string
+		expectedOrigin string
+	}{
+		{
+			name:           "relative redirect targets the ingress origin",
+			redirect:       "/api/me",
+			expectedOrigin: idp.RelyingPartyServer.URL,
+		},
+		{
+			name:           "absolute redirect to allowed origin targets that origin",
+			redirect:       "https://portal.example/app",
+			expectedOrigin: "https://portal.example",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rpClient := idp.RelyingPartyClient()
+
+			resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/login?popup=true&redirect="+url.QueryEscape(test.redirect))
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+			resp = get(t, rpClient, resp.Location.String())
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+			// the callback notifies the opener window instead of redirecting
+			callbackURL := resp.Location
+			resp = get(t, rpClient, callbackURL.String())
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Nil(t, resp.Location)
+			assert.Contains(t, resp.Body, "wonderwall:login")
+			assert.Contains(t, resp.Body, fmt.Sprintf("const origin = %q;", test.expectedOrigin))
+			assert.Contains(t, resp.Body, fmt.Sprintf("const redirect = %q;", test.redirect))
+
+			sessionCookie := getCookieFromJar(cookie.Session, rpClient.Jar.Cookies(callbackURL))
+			assert.NotNil(t, sessionCookie)
+		})
+	}
 
-	expectedTimeoutAt := time.Now().Add(cfg.Session.InactivityTimeout)
-	assert.WithinDuration(t, expectedTimeoutAt, data.Session.TimeoutAt, maxDelta)
-	assert.WithinDuration(t, expectedTimeoutAt, refreshedData.Session.TimeoutAt, maxDelta)
+	t.Run("failed login notifies the opener", func(t *testing.T) {
+		rpClient := idp.RelyingPartyClient()
 
-	assert.True(t, refreshedData.Session.TimeoutAt.After(data.Session.TimeoutAt))
+		resp := get(t, rpClient, idp.RelyingPartyServer.URL+"/oauth2/login?popup=true&redirect=/api/me")
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
 
-	previousTimeoutDuration := time.Duration(data.Session.TimeoutInSeconds) * time.Second
-	assert.WithinDuration(t, expectedTimeoutAt, time.Now().Add(previousTimeoutDuration), maxDelta)
+		resp = get(t, rpClient, resp.Location.String())
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
 
-	refreshedTimeoutDuration := time.Duration(refreshedData.Session.TimeoutInSeconds) * time.Second
-	assert.WithinDuration(t, expectedTimeoutAt, time.Now().Add(refreshedTimeoutDuration), maxDelta)
+		callbackURL := resp.Location
+		query := callbackURL.Query()
+		query.Del("code")
+		query.Set("error", "access_denied")
+		callbackURL.RawQuery = query.Encode()
+
+		resp = get(t, rpClient, callbackURL.String())
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Nil(t, resp.Location)
+		assert.Contains(t, resp.Body, "wonderwall:login")
+		assert.Contains(t, resp.Body, fmt.Sprintf("const origin = %q;", idp.RelyingPartyServer.URL))
+		assert.Contains(t, resp.Body, `const error = "internal_error";`)
+
+		sessionCookie := getCookieFromJar(cookie.Session, rpClient.Jar.Cookies(callbackURL))
+		assert.Nil(t, sessionCookie)
+	})
 }
 
 func TestHandler_Default(t *testing.T) {
@@ -701,10 +2354,11 @@ func localLogin(t *testing.T, rpClient *http.Client, idp *mock.IdentityProvider)
 	resp := get(t, rpClient, loginURL.String())
 	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
 
+	state := resp.Location.Query().Get("state")
 	cookies := rpClient.Jar.Cookies(loginURL)
 	sessionCookie := getCookieFromJar(cookie.Session, cookies)
-	loginCookie := getCookieFromJar(cookie.Login, cookies)
-	loginLegacyCookie := getCookieFromJar(cookie.LoginLegacy, cookies)
+	loginCookie := getCookieFromJar(cookie.LoginName(state), cookies)
+	loginLegacyCookie := getCookieFromJar(cookie.LoginLegacyName(state), cookies)
 
 	assert.Nil(t, sessionCookie)
 	assert.NotNil(t, loginCookie)
@@ -733,12 +2387,9 @@ func callback(t *testing.T, rpClient *http.Client, authorizeResponse response) *
 
 	cookies := rpClient.Jar.Cookies(callbackURL)
 	sessionCookie := getCookieFromJar(cookie.Session, cookies)
-	loginCookie := getCookieFromJar(cookie.Login, cookies)
-	loginLegacyCookie := getCookieFromJar(cookie.LoginLegacy, cookies)
 
 	assert.NotNil(t, sessionCookie)
-	assert.Nil(t, loginCookie)
-	assert.Nil(t, loginLegacyCookie)
+	assert.Empty(t, getLoginCookiesFromJar(cookies))
 
 	return sessionCookie
 }
@@ -895,6 +2546,47 @@ func post(t *testing.T, client *http.Client, url string) response {
 	}
 }
 
+func postForm(t *testing.T, client *http.Client, url string, params url.Values) response {
+	resp, err := client.PostForm(url, params)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	location, err := resp.Location()
+	if !errors.Is(http.ErrNoLocation, err) {
+		assert.NoError(t, err)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	return response{
+		Body:       string(body),
+		Location:   location,
+		StatusCode: resp.StatusCode,
+	}
+}
+
+var (
+	formActionRegex = regexp.MustCompile(`action="([^"]+)"`)
+	formInputRegex  = regexp.MustCompile(`name="([^"]+)" value="([^"]*)"`)
+)
+
+// parseFormPost returns the action and hidden input values from a form_post response.
+func parseFormPost(t *testing.T, body string) (*url.URL, url.Values) {
+	action := formActionRegex.FindStringSubmatch(body)
+	assert.Len(t, action, 2)
+
+	actionURL, err := url.Parse(html.UnescapeString(action[1]))
+	assert.NoError(t, err)
+
+	params := url.Values{}
+	for _, input := range formInputRegex.FindAllStringSubmatch(body, -1) {
+		params.Add(html.UnescapeString(input[1]), html.UnescapeString(input[2]))
+	}
+
+	return actionURL, params
+}
+
 type upstream struct {
 	Server          *httptest.Server
 	URL             *url.URL
@@ -939,6 +2631,53 @@ func newUpstream(t *testing.T) *upstream {
 	return u
 }
 
+// newNamedUpstream returns an upstream that responds with its name and whether the request contained a token.
+func newNamedUpstream(t *testing.T, name string) *httptest.Server {
+	return httptest.NewServer(namedUpstreamHandler(name))
+}
+
+func namedUpstreamHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if len(token) > 0 {
+			_, _ = w.Write([]byte(name + ": token"))
+		} else {
+			_, _ = w.Write([]byte(name + ": no token"))
+		}
+	})
+}
+
+func writeClientCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "wonderwall"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+
+	return certFile, keyFile
+}
+
+func hostOf(t *testing.T, raw string) string {
+	u, err := url.Parse(raw)
+	assert.NoError(t, err)
+	return u.Host
+}
+
 func getCookieFromJar(name string, cookies []*http.Cookie) *http.Cookie {
 	for _, c := range cookies {
 		if c.Name == name {
@@ -948,3 +2687,14 @@ func getCookieFromJar(name string, cookies []*http.Cookie) *http.Cookie {
 
 	return nil
 }
+
+func getLoginCookiesFromJar(cookies []*http.Cookie) []*http.Cookie {
+	result := make([]*http.Cookie, 0)
+	for _, c := range cookies {
+		if cookie.IsLogin(c.Name) {
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
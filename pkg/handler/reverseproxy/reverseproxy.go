@@ -2,44 +2,121 @@ package reverseproxy
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/handler/authrules"
+	"github.com/nais/wonderwall/pkg/handler/authz"
 	"github.com/nais/wonderwall/pkg/handler/autologin"
+	errorhandler "github.com/nais/wonderwall/pkg/handler/error"
 	"github.com/nais/wonderwall/pkg/handler/url"
 	"github.com/nais/wonderwall/pkg/loginstatus"
 	mw "github.com/nais/wonderwall/pkg/middleware"
+	openidclient "github.com/nais/wonderwall/pkg/openid/client"
 	"github.com/nais/wonderwall/pkg/session"
 )
 
 type Source interface {
+	GetAuthRules() *authrules.Rules
+	GetAuthorizationCallout() *authz.Callout
+	GetAuthorizationPolicies() *authz.Policies
 	GetAutoLogin() *autologin.AutoLogin
+	GetClient() *openidclient.Client
+	GetErrorHandler() errorhandler.Handler
 	GetLoginstatus() *loginstatus.Loginstatus
 	GetPath(r *http.Request) string
 	GetSessions() *session.Handler
 }
 
 type ReverseProxy struct {
-	*httputil.ReverseProxy
+	bearerToken     config.BearerToken
+	defaultRoute    *Route
+	exchangedTokens *exchangedTokens
+	sanitizer       *sanitizer
+	// routes are sorted by specificity, most specific first
+	routes []*Route
 }
 
-func New(upstreamHost string) *ReverseProxy {
-	rp := &httputil.ReverseProxy{
+// New creates a reverse proxy that forwards requests to the upstream of the first matching route in the configured
+// upstream routes. Requests that do not match any route are forwarded to the default upstream host.
+func New(cfg *config.Config) (*ReverseProxy, error) {
+	routeConfigs, err := config.ParseUpstreamRoutes(cfg.UpstreamRoutes)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make([]*Route, 0, len(routeConfigs))
+	for i, routeConfig := range routeConfigs {
+		route, err := newRoute(routeConfig, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: route %d: %w", config.UpstreamRoutes, i, err)
+		}
+
+		routes = append(routes, route)
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].moreSpecificThan(routes[j])
+	})
+
+	defaultRoute, err := newRoute(config.UpstreamRoute{Upstream: cfg.UpstreamHost}, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", config.UpstreamHost, err)
+	}
+
+	return &ReverseProxy{
+		bearerToken:     cfg.BearerToken,
+		defaultRoute:    defaultRoute,
+		exchangedTokens: newExchangedTokens(),
+		sanitizer:       newSanitizer(cfg.UpstreamRequest),
+		routes:          routes,
+	}, nil
+}
+
+// Match returns the route for the given request.
+func (rp *ReverseProxy) Match(r *http.Request) *Route {
+	for _, route := range rp.routes {
+		if route.Matches(r) {
+			return route
+		}
+	}
+
+	return rp.defaultRoute
+}
+
+func newProxy(route config.UpstreamRoute, globalTLS config.UpstreamTLS) (*httputil.ReverseProxy, error) {
+	upstream, err := config.ParseUpstream(route.Upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := newTransport(upstream, mergeTLS(globalTLS, route.TLS), time.Duration(route.ResponseHeaderTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	scheme, host := upstream.Scheme, upstream.Host
+	if scheme == config.UpstreamSchemeUnix {
+		scheme, host = config.UpstreamSchemeHTTP, unixSocketHost
+	}
+
+	return &httputil.ReverseProxy{
 		Director: func(r *http.Request) {
 			// Instruct http.ReverseProxy to not modify X-Forwarded-For header
 			r.Header["X-Forwarded-For"] = nil
 			// Request should go to correct host
-			r.URL.Host = upstreamHost
-			r.URL.Scheme = "http"
-
-			accessToken, ok := mw.AccessTokenFrom(r.Context())
-			if ok {
-				r.Header.Set("authorization", "Bearer "+accessToken)
-			}
+			r.URL.Host = host
+			r.URL.Scheme = scheme
 		},
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
 			logger := mw.LogEntryFrom(r)
@@ -51,16 +128,55 @@ func New(upstreamHost string) *ReverseProxy {
 				w.WriteHeader(http.StatusBadGateway)
 			}
 		},
-		ErrorLog: log.New(logrusErrorWriter{}, "reverseproxy: ", 0),
-	}
-	return &ReverseProxy{rp}
+		ErrorLog:  log.New(logrusErrorWriter{}, "reverseproxy: ", 0),
+		Transport: transport,
+	}, nil
 }
 
 func (rp *ReverseProxy) Handler(src Source, w http.ResponseWriter, r *http.Request) {
 	logger := mw.LogEntryFrom(r)
 	isAuthenticated := false
+	route := rp.Match(r)
+	rp.sanitizer.stripReservedHeaders(r)
+	route.stripTokenHeader(r)
+
+	if bearerToken, ok := bearerTokenFrom(r); ok && rp.bearerToken.Enabled {
+		token, err := src.GetClient().ValidateBearerToken(r.Context(), bearerToken, rp.bearerToken)
+		if err != nil {
+			logger.Infof("default: bearer token: %+v", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if needsClaims(src, r) {
+			claims, err := token.AsMap(r.Context())
+			if err != nil {
+				logger.Warnf("default: bearer token: getting claims: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			err = authorize(src, r, claims)
+			if errors.Is(err, authz.ErrForbidden) {
+				logger.Infof("default: bearer token: %+v", err)
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				logger.Errorf("default: bearer token: %+v", err)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
 
-	accessToken, err := src.GetSessions().GetAccessToken(r)
+		// forward the bearer token unchanged, without looking up any session
+		rp.serve(route, w, r)
+		return
+	}
+
+	sessionData, err := src.GetSessions().GetAuthenticated(r)
 	switch {
 	case err == nil:
 		// add authentication if session cookie and token checks out
@@ -83,28 +199,165 @@ func (rp *ReverseProxy) Handler(src Source, w http.ResponseWriter, r *http.Reque
 		logger.Infof("default: unauthenticated: %+v", err)
 	}
 
-	if src.GetAutoLogin().NeedsLogin(r, isAuthenticated) {
+	if !isAuthenticated {
 		redirectTarget := r.URL.String()
-		path := src.GetPath(r)
-
-		loginUrl := url.LoginURL(path, redirectTarget)
+		loginUrl := url.LoginURL(src.GetPath(r), redirectTarget)
 		fields := logrus.Fields{
 			"redirect_after_login": redirectTarget,
 			"redirect_to":          loginUrl,
 		}
 
-		logger.WithFields(fields).Info("default: unauthenticated: request matches auto-login; redirecting to login...")
-		http.Redirect(w, r, loginUrl, http.StatusTemporaryRedirect)
-		return
+		switch authMode(src, route, r) {
+		case config.AuthModeRedirect:
+			logger.WithFields(fields).Info("default: unauthenticated: request requires login; redirecting to login...")
+			http.Redirect(w, r, loginUrl, http.StatusTemporaryRedirect)
+			return
+		case config.AuthModeDeny:
+			logger.WithFields(fields).Info("default: unauthenticated: request requires authentication; denying...")
+			deny(w, r, loginUrl)
+			return
+		}
 	}
 
 	ctx := r.Context()
 
+	if needsClaims(src, r) {
+		var claims map[string]any
+		if isAuthenticated {
+			claims, err = sessionData.Claims(ctx)
+			if err != nil {
+				src.GetErrorHandler().InternalError(w, r, fmt.Errorf("default: getting session claims: %w", err))
+				return
+			}
+		}
+
+		err = authorize(src, r, claims)
+		if errors.Is(err, authz.ErrForbidden) {
+			src.GetErrorHandler().Forbidden(w, r, fmt.Errorf("default: %w", err))
+			return
+		}
+		if err != nil {
+			// fail closed if the authorization service is unavailable
+			src.GetErrorHandler().ServiceUnavailable(w, r, fmt.Errorf("default: %w", err))
+			return
+		}
+	}
+
 	if isAuthenticated {
-		ctx = mw.WithAccessToken(ctx, accessToken)
+		if err := rp.forwardToken(src, route, r, sessionData); err != nil {
+			src.GetErrorHandler().InternalError(w, r, fmt.Errorf("default: %w", err))
+			return
+		}
+
+		w = trackUpgrades(src, w, r, sessionData)
+	}
+
+	rp.serve(route, w, r)
+}
+
+func (rp *ReverseProxy) serve(route *Route, w http.ResponseWriter, r *http.Request) {
+	rp.sanitizer.stripCookies(r)
+	route.proxy.ServeHTTP(w, r)
+}
+
+// needsClaims returns true if the given request must be authorized, either by authorization policies or the external
+// authorization service.
+func needsClaims(src Source, r *http.Request) bool {
+	return src.GetAuthorizationPolicies().Applies(r) || src.GetAuthorizationCallout().Enabled()
+}
+
+// authorize evaluates the authorization policies and then the external authorization service, if enabled, for the
+// given request and claims. Claims are nil for unauthenticated requests. Headers from allowing decisions are added to
+// the request. An error wrapping authz.ErrForbidden is returned if the request is denied.
+func authorize(src Source, r *http.Request, claims map[string]any) error {
+	if src.GetAuthorizationPolicies().Applies(r) {
+		if err := src.GetAuthorizationPolicies().Authorize(r, claims); err != nil {
+			return err
+		}
+	}
+
+	callout := src.GetAuthorizationCallout()
+	if !callout.Enabled() {
+		return nil
+	}
+
+	decision, err := callout.Decide(r.Context(), r, claims)
+	if err != nil {
+		return fmt.Errorf("authorization callout: %w", err)
+	}
+
+	if !decision.Allow {
+		return fmt.Errorf("%w: denied by authorization callout", authz.ErrForbidden)
+	}
+
+	for key, value := range decision.Headers {
+		r.Header.Set(key, value)
+	}
+
+	return nil
+}
+
+// authMode returns the handling of the given unauthenticated request. The first matching auth rule applies, falling
+// back to auto-login. Requests matching authorization policies are never passed through. Requests matching 'redirect'
+// with methods other than GET are denied, as they cannot be replayed after login. Non-navigational requests, e.g. from
+// fetch(), are also denied, as they cannot follow the redirect to the identity provider.
+func authMode(src Source, route *Route, r *http.Request) config.AuthMode {
+	mode, ok := src.GetAuthRules().Match(r)
+	if !ok {
+		autoLogin := src.GetAutoLogin()
+		if route.AutoLogin != nil {
+			autoLogin = autoLogin.WithEnabled(*route.AutoLogin)
+		}
+
+		if autoLogin.NeedsLogin(r, false) {
+			mode = config.AuthModeRedirect
+		} else {
+			mode = config.AuthModePassthrough
+		}
+	}
+
+	// requests matching authorization policies require claims, and thus a session
+	if mode == config.AuthModePassthrough && src.GetAuthorizationPolicies().Applies(r) {
+		mode = config.AuthModeRedirect
+	}
+
+	if mode == config.AuthModeRedirect && (r.Method != http.MethodGet || !url.IsNavigation(r)) {
+		return config.AuthModeDeny
+	}
+
+	return mode
+}
+
+type unauthenticatedResponse struct {
+	Error    string `json:"error"`
+	LoginURL string `json:"login_url"`
+}
+
+// deny responds with 401 Unauthorized and the login URL, both as a JSON body and a Location header, so that clients
+// may redirect the user to login themselves.
+func deny(w http.ResponseWriter, r *http.Request, loginUrl string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", loginUrl)
+	w.WriteHeader(http.StatusUnauthorized)
+
+	err := json.NewEncoder(w).Encode(unauthenticatedResponse{
+		Error:    "unauthenticated",
+		LoginURL: loginUrl,
+	})
+	if err != nil {
+		mw.LogEntryFrom(r).Warnf("default: marshalling response: %+v", err)
+	}
+}
+
+// bearerTokenFrom returns the token from the request's 'Authorization: Bearer' header, if any.
+func bearerTokenFrom(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
 	}
 
-	rp.ServeHTTP(w, r.WithContext(ctx))
+	token = strings.TrimSpace(token)
+	return token, len(token) > 0
 }
 
 type logrusErrorWriter struct{}
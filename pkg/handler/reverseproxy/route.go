@@ -0,0 +1,70 @@
+package reverseproxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/handler/url"
+)
+
+type Route struct {
+	config.UpstreamRoute
+	proxy         *httputil.ReverseProxy
+	upstreamToken config.UpstreamToken
+}
+
+func newRoute(route config.UpstreamRoute, cfg *config.Config) (*Route, error) {
+	if route.PathPrefix != "/" {
+		route.PathPrefix = strings.TrimSuffix(route.PathPrefix, "/")
+	}
+
+	proxy, err := newProxy(route, cfg.UpstreamTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Route{
+		UpstreamRoute: route,
+		proxy:         proxy,
+		upstreamToken: mergeToken(cfg.UpstreamToken, route),
+	}, nil
+}
+
+// Matches returns true if the given request matches the route's host and path prefix. The path is canonicalized before
+// matching, so that e.g. '/public/../api' does not match the '/public' prefix.
+func (in *Route) Matches(r *http.Request) bool {
+	if len(in.Host) > 0 && !strings.EqualFold(in.Host, hostWithoutPort(r.Host)) {
+		return false
+	}
+
+	prefix := in.PathPrefix
+	if len(prefix) == 0 || prefix == "/" {
+		return true
+	}
+
+	path := url.CanonicalPath(r)
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// moreSpecificThan returns true if the route should be matched before the other route. Routes with a host are more
+// specific than routes without, and longer path prefixes are more specific than shorter ones.
+func (in *Route) moreSpecificThan(other *Route) bool {
+	hasHost, otherHasHost := len(in.Host) > 0, len(other.Host) > 0
+	if hasHost != otherHasHost {
+		return hasHost
+	}
+
+	return len(in.PathPrefix) > len(other.PathPrefix)
+}
+
+func hostWithoutPort(host string) string {
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+
+	return h
+}
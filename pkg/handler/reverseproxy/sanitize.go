@@ -0,0 +1,89 @@
+package reverseproxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/cookie"
+)
+
+type sanitizer struct {
+	allowedCookies         map[string]bool
+	allowedHeaders         map[string]bool
+	reservedHeaderPrefixes []string
+}
+
+func newSanitizer(cfg config.UpstreamRequest) *sanitizer {
+	s := &sanitizer{
+		allowedCookies: make(map[string]bool),
+		allowedHeaders: make(map[string]bool),
+	}
+
+	for _, name := range cfg.AllowedCookies {
+		s.allowedCookies[name] = true
+	}
+
+	for _, name := range cfg.AllowedHeaders {
+		s.allowedHeaders[http.CanonicalHeaderKey(name)] = true
+	}
+
+	for _, prefix := range cfg.ReservedHeaderPrefixes {
+		if len(prefix) > 0 {
+			s.reservedHeaderPrefixes = append(s.reservedHeaderPrefixes, strings.ToLower(prefix))
+		}
+	}
+
+	return s
+}
+
+// stripReservedHeaders removes client-supplied headers matching any of the reserved prefixes, so that clients cannot
+// spoof headers that the upstream expects to be set by Wonderwall. This must happen before any headers are injected.
+func (s *sanitizer) stripReservedHeaders(r *http.Request) {
+	for name := range r.Header {
+		if s.allowedHeaders[name] {
+			continue
+		}
+
+		lower := strings.ToLower(name)
+		for _, prefix := range s.reservedHeaderPrefixes {
+			if strings.HasPrefix(lower, prefix) {
+				r.Header.Del(name)
+				break
+			}
+		}
+	}
+}
+
+// stripCookies removes Wonderwall's own cookies from the request, leaving all other cookies as-is. This must happen
+// after the session has been looked up.
+func (s *sanitizer) stripCookies(r *http.Request) {
+	values := r.Header.Values("Cookie")
+	if len(values) == 0 {
+		return
+	}
+
+	kept := make([]string, 0)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ";") {
+			part = strings.TrimSpace(part)
+			if len(part) == 0 {
+				continue
+			}
+
+			name, _, _ := strings.Cut(part, "=")
+			if strings.HasPrefix(name, cookie.Prefix) && !s.allowedCookies[name] {
+				continue
+			}
+
+			kept = append(kept, part)
+		}
+	}
+
+	if len(kept) == 0 {
+		r.Header.Del("Cookie")
+		return
+	}
+
+	r.Header.Set("Cookie", strings.Join(kept, "; "))
+}
@@ -0,0 +1,182 @@
+package reverseproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nais/wonderwall/pkg/config"
+	openidclient "github.com/nais/wonderwall/pkg/openid/client"
+	"github.com/nais/wonderwall/pkg/session"
+)
+
+const (
+	HeaderSessionID        = "X-Wonderwall-Session-Id"
+	HeaderSessionEndsAt    = "X-Wonderwall-Session-Ends-At"
+	HeaderSessionTimeoutAt = "X-Wonderwall-Session-Timeout-At"
+	HeaderTokenExpiresAt   = "X-Wonderwall-Token-Expires-At"
+)
+
+const (
+	authorizationHeader = "Authorization"
+	// exchangedTokenLeeway is subtracted from the expiry of exchanged tokens to avoid forwarding tokens that are about
+	// to expire.
+	exchangedTokenLeeway = 30 * time.Second
+	// maxExchangedTokens limits the number of cached exchanged tokens. Tokens are not cached when the limit is reached.
+	maxExchangedTokens = 10_000
+)
+
+// mergeToken returns the global token settings, overridden by any settings for the given route.
+func mergeToken(global config.UpstreamToken, route config.UpstreamRoute) config.UpstreamToken {
+	merged := global
+
+	if route.Token != nil {
+		if len(route.Token.Type) > 0 {
+			merged.Type = route.Token.Type
+		}
+		if len(route.Token.Header) > 0 {
+			merged.Header = route.Token.Header
+		}
+		if len(route.Token.Audience) > 0 {
+			merged.Audience = route.Token.Audience
+		}
+		if route.Token.KeepAuthorization != nil {
+			merged.KeepAuthorization = route.Token.KeepAuthorization
+		}
+		if route.Token.SessionHeaders != nil {
+			merged.SessionHeaders = route.Token.SessionHeaders
+		}
+	}
+
+	if len(merged.Type) == 0 {
+		merged.Type = config.TokenTypeAccessToken
+	}
+
+	if len(merged.Header) == 0 {
+		merged.Header = authorizationHeader
+	}
+
+	return merged
+}
+
+// forwardToken adds the token and session headers for the given session to the request, according to the route's
+// token settings.
+func (rp *ReverseProxy) forwardToken(src Source, route *Route, r *http.Request, data *session.Data) error {
+	cfg := route.upstreamToken
+
+	var token string
+	switch cfg.Type {
+	case config.TokenTypeAccessToken:
+		token = data.AccessToken
+	case config.TokenTypeIDToken:
+		token = data.IDToken
+	case config.TokenTypeExchanged:
+		exchanged, err := rp.exchangedTokens.get(r.Context(), src.GetClient(), data.AccessToken, cfg.Audience)
+		if err != nil {
+			return fmt.Errorf("exchanging token for audience %q: %w", cfg.Audience, err)
+		}
+		token = exchanged
+	}
+
+	if len(token) > 0 {
+		if !strings.EqualFold(cfg.Header, authorizationHeader) {
+			r.Header.Set(cfg.Header, token)
+		} else if !isTrue(cfg.KeepAuthorization) || len(r.Header.Get(authorizationHeader)) == 0 {
+			r.Header.Set(authorizationHeader, "Bearer "+token)
+		}
+	}
+
+	if isTrue(cfg.SessionHeaders) {
+		metadata := data.Metadata
+		r.Header.Set(HeaderSessionID, data.ExternalSessionID)
+		r.Header.Set(HeaderSessionEndsAt, metadata.Session.EndsAt.UTC().Format(time.RFC3339))
+		r.Header.Set(HeaderTokenExpiresAt, metadata.Tokens.ExpireAt.UTC().Format(time.RFC3339))
+		if !metadata.Session.TimeoutAt.IsZero() {
+			r.Header.Set(HeaderSessionTimeoutAt, metadata.Session.TimeoutAt.UTC().Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
+
+// stripTokenHeader removes any client-supplied value for the route's token header, unless it is the 'Authorization'
+// header, so that clients cannot spoof the forwarded token.
+func (in *Route) stripTokenHeader(r *http.Request) {
+	if !strings.EqualFold(in.upstreamToken.Header, authorizationHeader) {
+		r.Header.Del(in.upstreamToken.Header)
+	}
+}
+
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
+
+type exchangedToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// exchangedTokens caches tokens exchanged with the identity provider for each access token and audience, until they
+// are about to expire.
+type exchangedTokens struct {
+	lock   sync.Mutex
+	tokens map[string]exchangedToken
+}
+
+func newExchangedTokens() *exchangedTokens {
+	return &exchangedTokens{
+		tokens: make(map[string]exchangedToken),
+	}
+}
+
+func (e *exchangedTokens) get(ctx context.Context, client *openidclient.Client, accessToken, audience string) (string, error) {
+	sum := sha256.Sum256([]byte(accessToken))
+	key := audience + ":" + hex.EncodeToString(sum[:])
+
+	e.lock.Lock()
+	cached, ok := e.tokens[key]
+	e.lock.Unlock()
+
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.token, nil
+	}
+
+	resp, err := client.TokenExchangeGrant(ctx, accessToken, audience)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - exchangedTokenLeeway)
+	e.set(key, exchangedToken{token: resp.AccessToken, expiresAt: expiresAt})
+
+	return resp.AccessToken, nil
+}
+
+func (e *exchangedTokens) set(key string, token exchangedToken) {
+	now := time.Now()
+	if !now.Before(token.expiresAt) {
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if len(e.tokens) >= maxExchangedTokens {
+		for k, t := range e.tokens {
+			if now.After(t.expiresAt) {
+				delete(e.tokens, k)
+			}
+		}
+	}
+
+	if len(e.tokens) >= maxExchangedTokens {
+		return
+	}
+
+	e.tokens[key] = token
+}
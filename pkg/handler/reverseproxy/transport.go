@@ -0,0 +1,101 @@
+package reverseproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/nais/wonderwall/pkg/config"
+)
+
+// unixSocketHost is the placeholder host used in request URLs for Unix socket upstreams.
+const unixSocketHost = "localhost"
+
+// newTransport creates a transport for the given upstream. Unix socket upstreams are dialed directly regardless of the
+// request URL's host, while 'https://' upstreams are verified using the given TLS settings.
+func newTransport(upstream *url.URL, tlsConfig config.UpstreamTLS, responseHeaderTimeout time.Duration) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+
+	switch upstream.Scheme {
+	case config.UpstreamSchemeUnix:
+		socketPath := upstream.Path
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+	case config.UpstreamSchemeHTTPS:
+		cfg, err := newTLSConfig(tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.TLSClientConfig = cfg
+	}
+
+	return transport, nil
+}
+
+func newTLSConfig(cfg config.UpstreamTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+
+	if len(cfg.CAFile) > 0 {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading upstream CA bundle: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("upstream CA bundle %q contains no valid certificates", cfg.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(cfg.CertFile) > 0 {
+		certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading upstream client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// mergeTLS returns the route-specific TLS settings, falling back to the global settings for fields that are not set.
+func mergeTLS(global config.UpstreamTLS, route *config.UpstreamTLS) config.UpstreamTLS {
+	if route == nil {
+		return global
+	}
+
+	merged := *route
+	if len(merged.CAFile) == 0 {
+		merged.CAFile = global.CAFile
+	}
+	if len(merged.CertFile) == 0 {
+		merged.CertFile = global.CertFile
+		merged.KeyFile = global.KeyFile
+	}
+	if len(merged.ServerName) == 0 {
+		merged.ServerName = global.ServerName
+	}
+
+	return merged
+}
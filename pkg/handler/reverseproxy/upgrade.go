@@ -0,0 +1,72 @@
+package reverseproxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	mw "github.com/nais/wonderwall/pkg/middleware"
+	"github.com/nais/wonderwall/pkg/session"
+)
+
+// upgradeResponseWriter tracks connections that are hijacked by the reverse proxy after a protocol upgrade, e.g. for
+// WebSockets, so that they are closed when the session ends.
+type upgradeResponseWriter struct {
+	http.ResponseWriter
+	track func(conn net.Conn) net.Conn
+}
+
+func trackUpgrades(src Source, w http.ResponseWriter, r *http.Request, data *session.Data) http.ResponseWriter {
+	// the session cookie is removed before the request is proxied, so we must look up the key beforehand
+	key, err := src.GetSessions().GetKey(r)
+	if err != nil {
+		mw.LogEntryFrom(r).Warnf("default: tracking upgraded connections: %+v", err)
+		return w
+	}
+
+	return &upgradeResponseWriter{
+		ResponseWriter: w,
+		track: func(conn net.Conn) net.Conn {
+			tracked := &trackedConn{Conn: conn}
+			tracked.release = src.GetSessions().TrackConnection(r, key, data, tracked)
+			return tracked
+		},
+	}
+}
+
+func (w *upgradeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", w.ResponseWriter)
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return w.track(conn), rw, nil
+}
+
+func (w *upgradeResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *upgradeResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
@@ -0,0 +1,103 @@
+package templates
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	errorTemplatePrefix = "error"
+	errorTemplateSuffix = ".gohtml"
+)
+
+// ErrorTemplates contains the templates for error pages, with optional variants for specific locales.
+type ErrorTemplates struct {
+	fallback *template.Template
+	locales  map[string]*template.Template
+}
+
+// DefaultErrorTemplates returns the built-in error template, without any locale variants.
+func DefaultErrorTemplates() *ErrorTemplates {
+	return &ErrorTemplates{
+		fallback: ErrorTemplate,
+		locales:  make(map[string]*template.Template),
+	}
+}
+
+// LoadErrorTemplates loads the error templates in the given directory. 'error.gohtml' is the default template, while
+// 'error.<locale>.gohtml' are variants for specific locales, e.g. 'error.en.gohtml' or 'error.nb-NO.gohtml'. The
+// built-in template is the default if the directory has no default template. An empty directory means the built-in
+// template only.
+func LoadErrorTemplates(dir string) (*ErrorTemplates, error) {
+	templates := DefaultErrorTemplates()
+	if len(dir) == 0 {
+		return templates, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading error template directory: %w", err)
+	}
+
+	found := false
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, errorTemplatePrefix) || !strings.HasSuffix(name, errorTemplateSuffix) {
+			continue
+		}
+
+		locale := strings.TrimSuffix(strings.TrimPrefix(name, errorTemplatePrefix), errorTemplateSuffix)
+		if len(locale) > 0 && !strings.HasPrefix(locale, ".") {
+			continue
+		}
+
+		tpl, err := template.ParseFiles(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("parsing error template %q: %w", name, err)
+		}
+
+		found = true
+		if len(locale) == 0 {
+			templates.fallback = tpl
+		} else {
+			templates.locales[normalizeLocale(locale[1:])] = tpl
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no error templates named '%s[.<locale>]%s' found in %q", errorTemplatePrefix, errorTemplateSuffix, dir)
+	}
+
+	return templates, nil
+}
+
+// Lookup returns the template for the first of the given locales, in order of preference, that has a variant. A locale
+// with a region, e.g. 'nb-NO', also matches a variant for the language only, e.g. 'nb'. The locale of the variant is
+// returned along with the template, or an empty string for the default template if no variant matches.
+func (e *ErrorTemplates) Lookup(locales ...string) (*template.Template, string) {
+	for _, locale := range locales {
+		locale = normalizeLocale(locale)
+
+		if tpl, ok := e.locales[locale]; ok {
+			return tpl, locale
+		}
+
+		language, _, found := strings.Cut(locale, "-")
+		if !found {
+			continue
+		}
+
+		if tpl, ok := e.locales[language]; ok {
+			return tpl, language
+		}
+	}
+
+	return e.fallback, ""
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
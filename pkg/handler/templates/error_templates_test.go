@@ -0,0 +1,94 @@
+package templates_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nais/wonderwall/pkg/handler/templates"
+)
+
+func TestLoadErrorTemplates(t *testing.T) {
+	t.Run("empty directory means built-in template", func(t *testing.T) {
+		errorTemplates, err := templates.LoadErrorTemplates("")
+		assert.NoError(t, err)
+
+		tpl, locale := errorTemplates.Lookup("en")
+		assert.Equal(t, templates.ErrorTemplate, tpl)
+		assert.Empty(t, locale)
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		_, err := templates.LoadErrorTemplates(filepath.Join(t.TempDir(), "missing"))
+		assert.Error(t, err)
+	})
+
+	t.Run("no templates in directory", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTemplate(t, dir, "other.gohtml", "other")
+
+		_, err := templates.LoadErrorTemplates(dir)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTemplate(t, dir, "error.gohtml", "{{.StatusCode")
+
+		_, err := templates.LoadErrorTemplates(dir)
+		assert.Error(t, err)
+	})
+
+	t.Run("locale variants", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTemplate(t, dir, "error.gohtml", "default")
+		writeTemplate(t, dir, "error.en.gohtml", "en")
+		writeTemplate(t, dir, "error.nb-NO.gohtml", "nb-NO")
+		writeTemplate(t, dir, "errors.gohtml", "ignored")
+
+		errorTemplates, err := templates.LoadErrorTemplates(dir)
+		assert.NoError(t, err)
+
+		for _, test := range []struct {
+			locales    []string
+			wantLocale string
+			wantBody   string
+		}{
+			{locales: nil, wantLocale: "", wantBody: "default"},
+			{locales: []string{"de"}, wantLocale: "", wantBody: "default"},
+			{locales: []string{"en"}, wantLocale: "en", wantBody: "en"},
+			{locales: []string{"en-GB"}, wantLocale: "en", wantBody: "en"},
+			{locales: []string{"nb_no"}, wantLocale: "nb-no", wantBody: "nb-NO"},
+			{locales: []string{"nb"}, wantLocale: "", wantBody: "default"},
+			{locales: []string{"de", "nb-NO", "en"}, wantLocale: "nb-no", wantBody: "nb-NO"},
+		} {
+			t.Run(strings.Join(test.locales, ","), func(t *testing.T) {
+				tpl, locale := errorTemplates.Lookup(test.locales...)
+				assert.Equal(t, test.wantLocale, locale)
+
+				var body strings.Builder
+				assert.NoError(t, tpl.Execute(&body, nil))
+				assert.Equal(t, test.wantBody, body.String())
+			})
+		}
+	})
+
+	t.Run("built-in template is default without default template", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTemplate(t, dir, "error.en.gohtml", "en")
+
+		errorTemplates, err := templates.LoadErrorTemplates(dir)
+		assert.NoError(t, err)
+		tpl, locale := errorTemplates.Lookup("de")
+		assert.Equal(t, templates.ErrorTemplate, tpl)
+		assert.Empty(t, locale)
+	})
+}
+
+func writeTemplate(t *testing.T, dir, name, content string) {
+	err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600)
+	assert.NoError(t, err)
+}
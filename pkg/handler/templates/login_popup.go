@@ -0,0 +1,22 @@
+package templates
+
+import (
+	_ "embed"
+	"html/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//go:embed login_popup.gohtml
+var loginPopupGoHtml string
+var LoginPopupTemplate *template.Template
+
+func init() {
+	var err error
+
+	LoginPopupTemplate = template.New("login_popup")
+	LoginPopupTemplate, err = LoginPopupTemplate.Parse(loginPopupGoHtml)
+	if err != nil {
+		log.Fatalf("parsing login popup template: %+v", err)
+	}
+}
@@ -0,0 +1,22 @@
+package templates
+
+import (
+	_ "embed"
+	"html/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//go:embed session_check.gohtml
+var sessionCheckGoHtml string
+var SessionCheckTemplate *template.Template
+
+func init() {
+	var err error
+
+	SessionCheckTemplate = template.New("session_check")
+	SessionCheckTemplate, err = SessionCheckTemplate.Parse(sessionCheckGoHtml)
+	if err != nil {
+		log.Fatalf("parsing session check template: %+v", err)
+	}
+}
@@ -6,7 +6,11 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
 
+	"github.com/bmatcuk/doublestar/v4"
+
+	"github.com/nais/wonderwall/pkg/ingress"
 	mw "github.com/nais/wonderwall/pkg/middleware"
 	"github.com/nais/wonderwall/pkg/router/paths"
 )
@@ -16,7 +20,9 @@ const (
 	RedirectURLEncodedParameter = "redirect-encoded"
 )
 
-// CanonicalRedirect constructs a redirect URL that points back to the application.
+// CanonicalRedirect constructs a redirect URL that points back to the application. Absolute URLs are only kept if
+// they point to another ingress or an allowed redirect origin, and are otherwise reduced to their path if they point
+// to the requested host. Other URLs fall back to the ingress path.
 func CanonicalRedirect(r *http.Request) string {
 	ingressPath, ok := mw.PathFrom(r.Context())
 	if len(ingressPath) == 0 || !ok {
@@ -50,6 +56,16 @@ func CanonicalRedirect(r *http.Request) string {
 		return ingressPath
 	}
 
+	if len(parsed.Host) > 0 || len(parsed.Scheme) > 0 {
+		if !isRequestHost(r, parsed.Host) {
+			if ingresses, ok := mw.IngressesFrom(r.Context()); ok && ingresses.AllowsRedirect(parsed) {
+				return parsed.String()
+			}
+
+			return ingressPath
+		}
+	}
+
 	// Strip scheme and host to avoid cross-domain redirects
 	parsed.Scheme = ""
 	parsed.Host = ""
@@ -69,6 +85,34 @@ func CanonicalRedirect(r *http.Request) string {
 	return redirect
 }
 
+// HasRedirect returns true if the given request has a redirect parameter.
+func HasRedirect(r *http.Request) bool {
+	return len(r.URL.Query().Get(RedirectURLParameter)) > 0 || len(RedirectDecoded(r)) > 0
+}
+
+// isRequestHost returns true if the given host is the host of the request. An empty host never matches, e.g. for
+// requests without a 'Host' or 'X-Forwarded-Host' header.
+func isRequestHost(r *http.Request, host string) bool {
+	if len(host) == 0 {
+		return false
+	}
+
+	return strings.EqualFold(host, r.Host) || strings.EqualFold(host, r.Header.Get(ingress.XForwardedHost))
+}
+
+// MatchPath returns true if the canonical path of the given request matches the given glob-style pattern.
+func MatchPath(pattern string, r *http.Request) bool {
+	match, _ := doublestar.Match(pattern, CanonicalPath(r))
+	return match
+}
+
+// CanonicalPath returns the path of the given request with a leading slash, without any trailing slash, and with dot
+// segments and repeated slashes resolved, e.g. '/public/../api//secret/' becomes '/api/secret'. Paths should be
+// canonicalized before matching them against patterns, as upstreams may normalize paths themselves.
+func CanonicalPath(r *http.Request) string {
+	return path.Clean("/" + r.URL.Path)
+}
+
 func LoginURL(prefix, redirectTarget string) string {
 	u := new(url.URL)
 	u.Path = path.Join(prefix, paths.OAuth2, paths.Login)
@@ -98,6 +142,37 @@ func RedirectDecoded(r *http.Request) string {
 	return ""
 }
 
+// IsNavigation returns true if the given request is likely a top-level navigation by the user agent. Other requests,
+// e.g. made by scripts through fetch() or XMLHttpRequest, cannot follow redirects to the identity provider, and should
+// instead receive a response that they can act on.
+func IsNavigation(r *http.Request) bool {
+	// Sec-Fetch-Mode is set by all modern browsers, and is the most reliable indicator
+	if mode := r.Header.Get("Sec-Fetch-Mode"); len(mode) > 0 {
+		return mode == "navigate"
+	}
+
+	if strings.EqualFold(r.Header.Get("X-Requested-With"), "XMLHttpRequest") {
+		return false
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html") {
+		return false
+	}
+
+	return true
+}
+
+// RedirectStatus returns the status code to use when redirecting the given request. POST requests, e.g. form_post
+// authorization responses, are redirected with 303 See Other so that the user agent follows up with a GET request.
+func RedirectStatus(r *http.Request) int {
+	if r.Method == http.MethodPost {
+		return http.StatusSeeOther
+	}
+
+	return http.StatusTemporaryRedirect
+}
+
 func LoginCallbackURL(r *http.Request) (string, error) {
 	return makeCallbackURL(r, paths.LoginCallback)
 }
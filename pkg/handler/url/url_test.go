@@ -140,6 +140,87 @@ func TestCanonicalRedirect(t *testing.T) {
 	})
 }
 
+func TestCanonicalRedirect_AbsoluteURLs(t *testing.T) {
+	cfg := mock.Config()
+	cfg.Ingresses = []string{"https://a.example.com", "https://b.example.com/app"}
+	cfg.RedirectAllowedOrigins = []string{"https://portal.example", "https://*.trusted.example"}
+	ingresses := mock.Ingresses(cfg)
+
+	for _, test := range []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{
+			name:     "requested host is reduced to path",
+			value:    "https://a.example.com/path?a=b",
+			expected: "/path?a=b",
+		},
+		{
+			name:     "other ingress",
+			value:    "https://b.example.com/app/path?a=b",
+			expected: "https://b.example.com/app/path?a=b",
+		},
+		{
+			name:     "other ingress with other scheme",
+			value:    "http://b.example.com/app/path",
+			expected: "/",
+		},
+		{
+			name:     "allowed origin",
+			value:    "https://portal.example/home",
+			expected: "https://portal.example/home",
+		},
+		{
+			name:     "allowed wildcard origin",
+			value:    "https://www.trusted.example/home",
+			expected: "https://www.trusted.example/home",
+		},
+		{
+			name:     "wildcard does not match parent domain",
+			value:    "https://trusted.example/home",
+			expected: "/",
+		},
+		{
+			name:     "other origin",
+			value:    "https://evil.example/home",
+			expected: "/",
+		},
+		{
+			name:     "protocol-relative url",
+			value:    "//evil.example/home",
+			expected: "/",
+		},
+		{
+			name:     "user info",
+			value:    "https://user@b.example.com/app",
+			expected: "/",
+		},
+		{
+			name:     "other scheme",
+			value:    "javascript:alert(1)",
+			expected: "/",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			v := url.Values{}
+			v.Set("redirect", test.value)
+
+			r := mock.NewGetRequest("https://a.example.com/oauth2/login?"+v.Encode(), ingresses)
+			assert.Equal(t, test.expected, urlpkg.CanonicalRedirect(r))
+		})
+	}
+
+	t.Run("empty host does not match request without host", func(t *testing.T) {
+		v := url.Values{}
+		v.Set("redirect", "https:///path")
+
+		r := mock.NewGetRequest("https://a.example.com/oauth2/login?"+v.Encode(), ingresses)
+		r.Host = ""
+		assert.Equal(t, "/", urlpkg.CanonicalRedirect(r))
+	})
+}
+
 func TestLoginURL(t *testing.T) {
 	for _, test := range []struct {
 		name           string
@@ -179,6 +260,95 @@ func TestLoginURL(t *testing.T) {
 	}
 }
 
+func TestCanonicalPath(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		want string
+	}{
+		{path: "", want: "/"},
+		{path: "/", want: "/"},
+		{path: "/api/", want: "/api"},
+		{path: "api", want: "/api"},
+		{path: "//api//secret", want: "/api/secret"},
+		{path: "/public/../api/secret", want: "/api/secret"},
+		{path: "/public/./../../api/secret", want: "/api/secret"},
+		{path: "/../api/secret", want: "/api/secret"},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://wonderwall/", nil)
+			r.URL.Path = test.path
+
+			assert.Equal(t, test.want, urlpkg.CanonicalPath(r))
+		})
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://wonderwall/", nil)
+	r.URL.Path = "/public/../api/secret/"
+
+	assert.True(t, urlpkg.MatchPath("/api/**", r))
+	assert.True(t, urlpkg.MatchPath("/api/secret", r))
+	assert.False(t, urlpkg.MatchPath("/public/**", r))
+}
+
+func TestIsNavigation(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{
+			name: "no headers",
+			want: true,
+		},
+		{
+			name:    "navigation",
+			headers: map[string]string{"Sec-Fetch-Mode": "navigate", "Accept": "text/html,application/xhtml+xml"},
+			want:    true,
+		},
+		{
+			name:    "fetch",
+			headers: map[string]string{"Sec-Fetch-Mode": "cors"},
+			want:    false,
+		},
+		{
+			name:    "nested-navigate is not a standard mode",
+			headers: map[string]string{"Sec-Fetch-Mode": "nested-navigate"},
+			want:    false,
+		},
+		{
+			name:    "fetch mode takes precedence over accept",
+			headers: map[string]string{"Sec-Fetch-Mode": "same-origin", "Accept": "text/html"},
+			want:    false,
+		},
+		{
+			name:    "xhr",
+			headers: map[string]string{"X-Requested-With": "XMLHttpRequest"},
+			want:    false,
+		},
+		{
+			name:    "accepts json",
+			headers: map[string]string{"Accept": "application/json"},
+			want:    false,
+		},
+		{
+			name:    "accepts json and html",
+			headers: map[string]string{"Accept": "text/html, application/json"},
+			want:    true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range test.headers {
+				r.Header.Set(key, value)
+			}
+
+			assert.Equal(t, test.want, urlpkg.IsNavigation(r))
+		})
+	}
+}
+
 func TestLoginCallbackURL(t *testing.T) {
 	cfg := mock.Config()
 	cfg.Ingresses = []string{
@@ -18,6 +18,8 @@ type Ingresses struct {
 	hosts      []string
 	paths      []string
 	urls       []string
+	// redirectOrigins are origins other than the ingresses that absolute redirect URLs may point to
+	redirectOrigins []string
 }
 
 func ParseIngresses(cfg *config.Config) (*Ingresses, error) {
@@ -44,6 +46,8 @@ func ParseIngresses(cfg *config.Config) (*Ingresses, error) {
 		hosts:      mapIngresses(seen, Ingress.Host),
 		paths:      mapIngresses(seen, Ingress.Path),
 		urls:       mapIngresses(seen, Ingress.String),
+
+		redirectOrigins: cfg.RedirectAllowedOrigins,
 	}, nil
 }
 
@@ -59,6 +63,30 @@ func (i *Ingresses) Strings() []string {
 	return i.urls
 }
 
+// AllowsRedirect returns true if the given absolute URL has the same origin as any of the ingresses, or matches any of
+// the allowed redirect origins.
+func (i *Ingresses) AllowsRedirect(u *url.URL) bool {
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 || u.User != nil {
+		return false
+	}
+
+	origin := u.Scheme + "://" + u.Host
+
+	for _, ingress := range i.ingressMap {
+		if strings.EqualFold(ingress.Scheme+"://"+ingress.Host(), origin) {
+			return true
+		}
+	}
+
+	for _, pattern := range i.redirectOrigins {
+		if config.MatchOrigin(pattern, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (i *Ingresses) MatchingIngress(r *http.Request) (Ingress, bool) {
 	var match Ingress
 	found := false
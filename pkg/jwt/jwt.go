@@ -1,9 +1,14 @@
 package jwt
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
@@ -12,16 +17,36 @@ import (
 const (
 	AcceptableClockSkew = 5 * time.Second
 
-	JtiClaim = "jti"
-	SidClaim = "sid"
-	UtiClaim = "uti"
+	AtHashClaim   = "at_hash"
+	AuthTimeClaim = "auth_time"
+	AzpClaim      = "azp"
+	JtiClaim      = "jti"
+	NonceClaim    = "nonce"
+	SidClaim      = "sid"
+	UtiClaim      = "uti"
 )
 
+// KeyEncryptionAlgorithms contains the accepted key management algorithms for encrypted tokens.
+// RSA1_5 is deliberately excluded due to its known weaknesses.
+var KeyEncryptionAlgorithms = []jwa.KeyEncryptionAlgorithm{
+	jwa.RSA_OAEP,
+	jwa.RSA_OAEP_256,
+	jwa.ECDH_ES,
+	jwa.ECDH_ES_A128KW,
+	jwa.ECDH_ES_A192KW,
+	jwa.ECDH_ES_A256KW,
+}
+
 type Token struct {
 	serialized string
 	token      jwt.Token
 }
 
+// GetAlgorithm returns the signing algorithm found in the token's protected header.
+func (in *Token) GetAlgorithm() (jwa.SignatureAlgorithm, error) {
+	return Algorithm(in.serialized)
+}
+
 func (in *Token) GetExpiration() time.Time {
 	return in.token.Expiration()
 }
@@ -70,6 +95,14 @@ func (in *Token) GetStringClaimOrEmpty(claim string) string {
 	return str
 }
 
+func (in *Token) GetTimeClaim(claim string) (time.Time, error) {
+	if in.token == nil {
+		return time.Time{}, fmt.Errorf("token is nil")
+	}
+
+	return TimeClaim(in.token, claim)
+}
+
 func (in *Token) GetToken() jwt.Token {
 	return in.token
 }
@@ -81,12 +114,53 @@ func NewToken(raw string, jwtToken jwt.Token) Token {
 	}
 }
 
-func Parse(raw string, jwks jwk.Set) (jwt.Token, error) {
+// Algorithm returns the signing algorithm found in the protected header of the given serialized JWS.
+func Algorithm(raw string) (jwa.SignatureAlgorithm, error) {
+	msg, err := jws.Parse([]byte(raw))
+	if err != nil {
+		return "", fmt.Errorf("parsing jws: %w", err)
+	}
+
+	signatures := msg.Signatures()
+	if len(signatures) != 1 {
+		return "", fmt.Errorf("expected exactly 1 signature, got %d", len(signatures))
+	}
+
+	return signatures[0].ProtectedHeaders().Algorithm(), nil
+}
+
+// TimeClaim returns the value of the given claim as a time.Time, assuming the value is a NumericDate as defined in RFC 7519.
+func TimeClaim(token jwt.Token, claim string) (time.Time, error) {
+	gotClaim, ok := token.Get(claim)
+	if !ok {
+		return time.Time{}, fmt.Errorf("missing required '%s' claim in token", claim)
+	}
+
+	switch v := gotClaim.(type) {
+	case time.Time:
+		return v, nil
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("'%s' claim is not a valid number: %w", claim, err)
+		}
+		return time.Unix(i, 0), nil
+	default:
+		return time.Time{}, fmt.Errorf("'%s' claim is not a number", claim)
+	}
+}
+
+// Parse parses the given JWT and verifies its signature with the given key set, using only the given algorithm.
+// The algorithm must match the token's protected header, and should be checked against an allow-list by the caller
+// beforehand, see Algorithm. The claims are not validated; this is left to the caller.
+func Parse(raw string, jwks jwk.Set, alg jwa.SignatureAlgorithm) (jwt.Token, error) {
 	parseOpts := []jwt.ParseOption{
-		jwt.WithKeySet(jwks,
-			jws.WithInferAlgorithmFromKey(true),
-		),
-		jwt.WithAcceptableSkew(AcceptableClockSkew),
+		jwt.WithKeyProvider(keyProvider(jwks, alg)),
+		jwt.WithValidate(false),
 	}
 	token, err := jwt.ParseString(raw, parseOpts...)
 	if err != nil {
@@ -95,3 +169,100 @@ func Parse(raw string, jwks jwk.Set) (jwt.Token, error) {
 
 	return token, nil
 }
+
+// keyProvider returns the keys in the given set that match the key ID in the token's protected header, to be used
+// with the given algorithm only. Keys that declare another algorithm, or are not meant for signatures, are skipped.
+func keyProvider(jwks jwk.Set, alg jwa.SignatureAlgorithm) jws.KeyProvider {
+	return jws.KeyProviderFunc(func(_ context.Context, sink jws.KeySink, sig *jws.Signature, _ *jws.Message) error {
+		headers := sig.ProtectedHeaders()
+		if headers.Algorithm() != alg {
+			return fmt.Errorf("expected algorithm %q, got %q", alg, headers.Algorithm())
+		}
+
+		kid := headers.KeyID()
+		if len(kid) == 0 {
+			return fmt.Errorf("missing key ID ('kid') in token")
+		}
+
+		for i := 0; i < jwks.Len(); i++ {
+			key, ok := jwks.Key(i)
+			if !ok || key.KeyID() != kid {
+				continue
+			}
+
+			if usage := key.KeyUsage(); len(usage) > 0 && usage != jwk.ForSignature.String() {
+				continue
+			}
+
+			if keyAlg := key.Algorithm().String(); len(keyAlg) > 0 && keyAlg != alg.String() {
+				continue
+			}
+
+			sink.Key(alg, key)
+		}
+
+		return nil
+	})
+}
+
+// ParseUnverified parses the given JWT without verifying its signature or validating its claims.
+func ParseUnverified(raw string) (jwt.Token, error) {
+	token, err := jwt.ParseString(raw, jwt.WithVerify(false), jwt.WithValidate(false))
+	if err != nil {
+		return nil, fmt.Errorf("parsing jwt: %w", err)
+	}
+
+	return token, nil
+}
+
+// IsEncrypted returns true if the given token is a JWE in compact serialization, i.e. consists of five parts.
+func IsEncrypted(raw string) bool {
+	return strings.Count(raw, ".") == 4
+}
+
+// Decrypt decrypts the given JWE with the matching key from the given set of private keys and returns the payload.
+// The key management algorithm is taken from the JWE header, and must be one of KeyEncryptionAlgorithms.
+func Decrypt(raw string, keys jwk.Set) (string, error) {
+	if keys == nil || keys.Len() == 0 {
+		return "", fmt.Errorf("no decryption keys configured")
+	}
+
+	provider := jwe.KeyProviderFunc(func(_ context.Context, sink jwe.KeySink, r jwe.Recipient, _ *jwe.Message) error {
+		alg := r.Headers().Algorithm()
+		if !acceptedKeyEncryptionAlgorithm(alg) {
+			return fmt.Errorf("unsupported key encryption algorithm %q", alg)
+		}
+
+		kid := r.Headers().KeyID()
+		for i := 0; i < keys.Len(); i++ {
+			key, ok := keys.Key(i)
+			if !ok {
+				continue
+			}
+
+			if len(kid) > 0 && len(key.KeyID()) > 0 && key.KeyID() != kid {
+				continue
+			}
+
+			sink.Key(alg, key)
+		}
+		return nil
+	})
+
+	payload, err := jwe.Decrypt([]byte(raw), jwe.WithKeyProvider(provider))
+	if err != nil {
+		return "", fmt.Errorf("decrypting jwe: %w", err)
+	}
+
+	return string(payload), nil
+}
+
+func acceptedKeyEncryptionAlgorithm(alg jwa.KeyEncryptionAlgorithm) bool {
+	for _, accepted := range KeyEncryptionAlgorithms {
+		if alg == accepted {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	jwtlib "github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/assert"
+
+	cryptopkg "github.com/nais/wonderwall/pkg/crypto"
+	"github.com/nais/wonderwall/pkg/jwt"
+)
+
+func TestParse(t *testing.T) {
+	privateKey, err := cryptopkg.NewJwk()
+	assert.NoError(t, err)
+
+	var rawKey rsa.PrivateKey
+	assert.NoError(t, privateKey.Raw(&rawKey))
+
+	publicKey := func(t *testing.T, alg jwa.SignatureAlgorithm) jwk.Set {
+		key, err := privateKey.PublicKey()
+		assert.NoError(t, err)
+		if len(alg) > 0 {
+			assert.NoError(t, key.Set(jwk.AlgorithmKey, alg))
+		}
+
+		set := jwk.NewSet()
+		assert.NoError(t, set.AddKey(key))
+		return set
+	}
+
+	token := jwtlib.New()
+	assert.NoError(t, token.Set("sub", "some-subject"))
+
+	t.Run("valid signature", func(t *testing.T) {
+		signed, err := jwtlib.Sign(token, jwtlib.WithKey(jwa.RS256, privateKey))
+		assert.NoError(t, err)
+
+		parsed, err := jwt.Parse(string(signed), publicKey(t, ""), jwa.RS256)
+		assert.NoError(t, err)
+		assert.Equal(t, "some-subject", parsed.Subject())
+	})
+
+	t.Run("header algorithm does not match expected algorithm", func(t *testing.T) {
+		signed, err := jwtlib.Sign(token, jwtlib.WithKey(jwa.RS384, privateKey))
+		assert.NoError(t, err)
+
+		_, err = jwt.Parse(string(signed), publicKey(t, ""), jwa.RS256)
+		assert.Error(t, err)
+	})
+
+	t.Run("key declares another algorithm", func(t *testing.T) {
+		signed, err := jwtlib.Sign(token, jwtlib.WithKey(jwa.RS256, privateKey))
+		assert.NoError(t, err)
+
+		_, err = jwt.Parse(string(signed), publicKey(t, jwa.PS256), jwa.RS256)
+		assert.Error(t, err)
+	})
+
+	t.Run("signed with another algorithm than in header", func(t *testing.T) {
+		headers := jws.NewHeaders()
+		assert.NoError(t, headers.Set(jws.AlgorithmKey, jwa.RS256))
+		assert.NoError(t, headers.Set(jws.KeyIDKey, privateKey.KeyID()))
+		encodedHeaders, err := headers.MarshalJSON()
+		assert.NoError(t, err)
+
+		payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"some-subject"}`))
+		signingInput := base64.RawURLEncoding.EncodeToString(encodedHeaders) + "." + payload
+
+		digest := sha256.Sum256([]byte(signingInput))
+		signature, err := rsa.SignPSS(rand.Reader, &rawKey, crypto.SHA256, digest[:], nil)
+		assert.NoError(t, err)
+		raw := signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+		for _, alg := range []jwa.SignatureAlgorithm{"", jwa.PS256} {
+			_, err = jwt.Parse(raw, publicKey(t, alg), jwa.RS256)
+			assert.Error(t, err)
+		}
+
+		_, err = jwt.Parse(raw, publicKey(t, jwa.PS256), jwa.PS256)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing key ID", func(t *testing.T) {
+		key, err := jwk.FromRaw(&rawKey)
+		assert.NoError(t, err)
+
+		signed, err := jwtlib.Sign(token, jwtlib.WithKey(jwa.RS256, key))
+		assert.NoError(t, err)
+
+		_, err = jwt.Parse(string(signed), publicKey(t, ""), jwa.RS256)
+		assert.Error(t, err)
+	})
+}
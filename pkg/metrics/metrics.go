@@ -16,6 +16,7 @@ const (
 	LabelHpa       = "hpa"
 	LabelOperation = "operation"
 	LabelProvider  = "provider"
+	LabelReason    = "reason"
 )
 
 type Hpa = string
@@ -41,10 +42,28 @@ const (
 	RedisOperationDelete = "Delete"
 )
 
+type IDTokenValidationReason = string
+
+const (
+	IDTokenValidationReasonAlgorithm = "alg"
+	IDTokenValidationReasonAtHash    = "at_hash"
+	IDTokenValidationReasonAudience  = "aud"
+	IDTokenValidationReasonAuthTime  = "auth_time"
+	IDTokenValidationReasonAzp       = "azp"
+	IDTokenValidationReasonClaims    = "claims"
+	IDTokenValidationReasonDecrypt   = "decrypt"
+	IDTokenValidationReasonIat       = "iat"
+	IDTokenValidationReasonIssuer    = "iss"
+	IDTokenValidationReasonNonce     = "nonce"
+	IDTokenValidationReasonSignature = "signature"
+	IDTokenValidationReasonSubject   = "sub"
+)
+
 var (
-	RedisLatency = redisLatency()
-	Logins       = logins()
-	Logouts      = logouts()
+	RedisLatency              = redisLatency()
+	Logins                    = logins()
+	Logouts                   = logouts()
+	IDTokenValidationFailures = idTokenValidationFailures()
 )
 
 func redisLatency(constLabels ...prometheus.Labels) *prometheus.HistogramVec {
@@ -96,6 +115,18 @@ func logouts(constLabels ...prometheus.Labels) *prometheus.CounterVec {
 	return prometheus.NewCounterVec(opts, []string{LabelOperation})
 }
 
+func idTokenValidationFailures(constLabels ...prometheus.Labels) *prometheus.CounterVec {
+	opts := prometheus.CounterOpts{
+		Name:      "id_token_validation_failures",
+		Namespace: Namespace,
+		Help:      "cumulative number of id_token validation failures",
+	}
+	if len(constLabels) > 0 {
+		opts.ConstLabels = constLabels[0]
+	}
+	return prometheus.NewCounterVec(opts, []string{LabelReason})
+}
+
 func WithProvider(provider string) {
 	RedisLatency = redisLatency(prometheus.Labels{
 		LabelProvider: provider,
@@ -110,6 +141,9 @@ func WithProvider(provider string) {
 		LabelHpa:      HpaRate,
 		LabelProvider: provider,
 	})
+	IDTokenValidationFailures = idTokenValidationFailures(prometheus.Labels{
+		LabelProvider: provider,
+	})
 }
 
 // InitLabels zeroes out all possible label combinations
@@ -119,6 +153,24 @@ func InitLabels() {
 	for _, operation := range logoutOperations {
 		Logouts.With(prometheus.Labels{LabelOperation: operation})
 	}
+
+	idTokenValidationReasons := []IDTokenValidationReason{
+		IDTokenValidationReasonAlgorithm,
+		IDTokenValidationReasonAtHash,
+		IDTokenValidationReasonAudience,
+		IDTokenValidationReasonAuthTime,
+		IDTokenValidationReasonAzp,
+		IDTokenValidationReasonClaims,
+		IDTokenValidationReasonDecrypt,
+		IDTokenValidationReasonIat,
+		IDTokenValidationReasonIssuer,
+		IDTokenValidationReasonNonce,
+		IDTokenValidationReasonSignature,
+		IDTokenValidationReasonSubject,
+	}
+	for _, reason := range idTokenValidationReasons {
+		IDTokenValidationFailures.With(prometheus.Labels{LabelReason: reason})
+	}
 }
 
 func Handle(address string, openidConfig openidconfig.Config) error {
@@ -135,6 +187,7 @@ func Register(registry prometheus.Registerer) {
 		RedisLatency,
 		Logins,
 		Logouts,
+		IDTokenValidationFailures,
 	)
 }
 
@@ -157,3 +210,9 @@ func ObserveLogout(operation LogoutOperation) {
 		LabelOperation: operation,
 	}).Inc()
 }
+
+func ObserveIDTokenValidationFailure(reason IDTokenValidationReason) {
+	IDTokenValidationFailures.With(prometheus.Labels{
+		LabelReason: reason,
+	}).Inc()
+}
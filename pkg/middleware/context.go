@@ -10,20 +10,11 @@ import (
 type contextKey string
 
 const (
-	ctxAccessToken = contextKey("AccessToken")
-	ctxIngress     = contextKey("Ingress")
-	ctxPath        = contextKey("Path")
+	ctxIngress   = contextKey("Ingress")
+	ctxIngresses = contextKey("Ingresses")
+	ctxPath      = contextKey("Path")
 )
 
-func AccessTokenFrom(ctx context.Context) (string, bool) {
-	accessToken, ok := ctx.Value(ctxAccessToken).(string)
-	return accessToken, ok
-}
-
-func WithAccessToken(ctx context.Context, accessToken string) context.Context {
-	return context.WithValue(ctx, ctxAccessToken, accessToken)
-}
-
 func IngressFrom(ctx context.Context) (ingress.Ingress, bool) {
 	i, ok := ctx.Value(ctxIngress).(ingress.Ingress)
 	return i, ok
@@ -39,6 +30,21 @@ func RequestWithIngress(r *http.Request, ing ingress.Ingress) *http.Request {
 	return r.WithContext(ctx)
 }
 
+func IngressesFrom(ctx context.Context) (*ingress.Ingresses, bool) {
+	i, ok := ctx.Value(ctxIngresses).(*ingress.Ingresses)
+	return i, ok && i != nil
+}
+
+func WithIngresses(ctx context.Context, ingresses *ingress.Ingresses) context.Context {
+	return context.WithValue(ctx, ctxIngresses, ingresses)
+}
+
+func RequestWithIngresses(r *http.Request, ingresses *ingress.Ingresses) *http.Request {
+	ctx := r.Context()
+	ctx = WithIngresses(ctx, ingresses)
+	return r.WithContext(ctx)
+}
+
 func PathFrom(ctx context.Context) (string, bool) {
 	path, ok := ctx.Value(ctxPath).(string)
 	return path, ok
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nais/wonderwall/pkg/config"
+)
+
+const (
+	corsAllowedMethods = "GET, POST"
+)
+
+type CORSMiddleware struct {
+	IngressSource
+	config   config.CORS
+	any      bool
+	upstream bool
+}
+
+func CORS(cfg config.CORS, source IngressSource) CORSMiddleware {
+	m := CORSMiddleware{
+		IngressSource: source,
+		config:        cfg,
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			m.any = true
+		}
+	}
+
+	return m
+}
+
+// ForUpstream returns a copy of the middleware for requests proxied to the upstream.
+func (c CORSMiddleware) ForUpstream() CORSMiddleware {
+	c.upstream = true
+	return c
+}
+
+// Handler adds CORS headers to responses for requests from allowed origins, and answers preflight requests. Requests
+// from other origins are passed through without CORS headers, so that browsers block the responses.
+func (c *CORSMiddleware) Handler(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !c.config.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+		isPreflight := r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0
+
+		w.Header().Add("Vary", "Origin")
+		if len(origin) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !c.allowed(origin) {
+			if isPreflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if c.any && !c.config.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if c.config.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !isPreflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", c.allowedMethods(r))
+		if headers := r.Header.Get("Access-Control-Request-Headers"); len(headers) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		if c.config.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.config.MaxAge.Seconds())))
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (c *CORSMiddleware) allowed(origin string) bool {
+	if c.any {
+		return true
+	}
+
+	for _, pattern := range c.config.AllowedOrigins {
+		if config.MatchOrigin(pattern, origin) {
+			return true
+		}
+	}
+
+	for _, raw := range c.GetIngresses().Strings() {
+		u, err := url.Parse(raw)
+		if err == nil && config.MatchOrigin(u.Scheme+"://"+u.Host, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowedMethods returns the methods allowed for the preflight request. Proxied routes allow the requested method, as
+// the upstream decides which methods it supports.
+func (c *CORSMiddleware) allowedMethods(r *http.Request) string {
+	requested := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if c.upstream && len(requested) > 0 {
+		return requested
+	}
+
+	return corsAllowedMethods
+}
@@ -0,0 +1,176 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/ingress"
+	"github.com/nais/wonderwall/pkg/middleware"
+)
+
+type ingressSource struct {
+	ingresses *ingress.Ingresses
+}
+
+func (in ingressSource) GetIngresses() *ingress.Ingresses {
+	return in.ingresses
+}
+
+func newCORSHandler(t *testing.T, cfg config.CORS) http.Handler {
+	ingresses, err := ingress.ParseIngresses(&config.Config{
+		Ingresses: []string{"https://wonderwall.example/path"},
+	})
+	assert.NoError(t, err)
+
+	cfg.Enabled = true
+	cors := middleware.CORS(cfg, ingressSource{ingresses: ingresses})
+	return cors.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func corsRequest(handler http.Handler, method, origin string, preflight bool) *http.Response {
+	r := httptest.NewRequest(method, "/oauth2/session", nil)
+	if len(origin) > 0 {
+		r.Header.Set("Origin", origin)
+	}
+	if preflight {
+		r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		r.Header.Set("Access-Control-Request-Headers", "X-Requested-With")
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func TestCORS_Origins(t *testing.T) {
+	handler := newCORSHandler(t, config.CORS{
+		AllowCredentials: true,
+		AllowedOrigins:   []string{"https://app.example", "https://*.example.com"},
+	})
+
+	for _, test := range []struct {
+		name    string
+		origin  string
+		allowed bool
+	}{
+		{name: "exact origin", origin: "https://app.example", allowed: true},
+		{name: "exact origin is case-insensitive", origin: "https://APP.example", allowed: true},
+		{name: "ingress origin", origin: "https://wonderwall.example", allowed: true},
+		{name: "wildcard subdomain", origin: "https://app.example.com", allowed: true},
+		{name: "nested wildcard subdomain", origin: "https://a.b.example.com", allowed: true},
+		{name: "wildcard does not match apex", origin: "https://example.com"},
+		{name: "wildcard does not match other domain with same suffix", origin: "https://evilexample.com"},
+		{name: "wildcard does not match other scheme", origin: "http://app.example.com"},
+		{name: "other scheme", origin: "http://app.example"},
+		{name: "other port", origin: "https://app.example:8443"},
+		{name: "other origin", origin: "https://evil.example"},
+		{name: "null origin", origin: "null"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resp := corsRequest(handler, http.MethodGet, test.origin, false)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			if test.allowed {
+				assert.Equal(t, test.origin, resp.Header.Get("Access-Control-Allow-Origin"))
+				assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+			} else {
+				assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+				assert.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"))
+			}
+			assert.Contains(t, resp.Header.Values("Vary"), "Origin")
+		})
+	}
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	handler := newCORSHandler(t, config.CORS{
+		AllowCredentials: true,
+		AllowedOrigins:   []string{"https://app.example"},
+		MaxAge:           10 * time.Minute,
+	})
+
+	t.Run("allowed origin", func(t *testing.T) {
+		resp := corsRequest(handler, http.MethodOptions, "https://app.example", true)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, "https://app.example", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "GET, POST", resp.Header.Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "X-Requested-With", resp.Header.Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "600", resp.Header.Get("Access-Control-Max-Age"))
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		resp := corsRequest(handler, http.MethodOptions, "https://evil.example", true)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Methods"))
+	})
+
+	t.Run("options request without preflight headers is passed through", func(t *testing.T) {
+		resp := corsRequest(handler, http.MethodOptions, "https://evil.example", false)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("request without origin is passed through", func(t *testing.T) {
+		resp := corsRequest(handler, http.MethodGet, "", false)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+	})
+}
+
+func TestCORS_ForUpstream(t *testing.T) {
+	ingresses, err := ingress.ParseIngresses(&config.Config{
+		Ingresses: []string{"https://wonderwall.example"},
+	})
+	assert.NoError(t, err)
+
+	cors := middleware.CORS(config.CORS{
+		Enabled:        true,
+		AllowedOrigins: []string{"https://app.example"},
+	}, ingressSource{ingresses: ingresses}).ForUpstream()
+	handler := cors.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	r := httptest.NewRequest(http.MethodOptions, "/api/items", nil)
+	r.Header.Set("Origin", "https://app.example")
+	r.Header.Set("Access-Control-Request-Method", "delete")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.MethodDelete, w.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestCORS_AnyOrigin(t *testing.T) {
+	handler := newCORSHandler(t, config.CORS{
+		AllowedOrigins: []string{"*"},
+	})
+
+	resp := corsRequest(handler, http.MethodGet, "https://anything.example", false)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"))
+
+	resp = corsRequest(handler, http.MethodOptions, "https://anything.example", true)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORS_Disabled(t *testing.T) {
+	cors := middleware.CORS(config.CORS{AllowedOrigins: []string{"https://app.example"}}, ingressSource{})
+	handler := cors.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	resp := corsRequest(handler, http.MethodOptions, "https://app.example", true)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+}
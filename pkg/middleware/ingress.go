@@ -21,7 +21,7 @@ func Ingress(source IngressSource) IngressMiddleware {
 func (i *IngressMiddleware) Handler(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		ingresses := i.GetIngresses()
-		ctx := r.Context()
+		ctx := WithIngresses(r.Context(), ingresses)
 
 		path := ingresses.MatchingPath(r)
 		ctx = WithPath(ctx, path)
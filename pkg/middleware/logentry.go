@@ -158,12 +158,5 @@ func nonEmptyRequestCookies(r *http.Request) string {
 }
 
 func isRelevantCookie(name string) bool {
-	switch name {
-	case cookie.Session,
-		cookie.Login,
-		cookie.LoginLegacy:
-		return true
-	}
-
-	return false
+	return name == cookie.Session || cookie.IsLogin(name)
 }
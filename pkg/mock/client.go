@@ -1,6 +1,15 @@
 package mock
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+
 	"github.com/lestrrat-go/jwx/v2/jwk"
 
 	"github.com/nais/wonderwall/pkg/config"
@@ -10,13 +19,31 @@ import (
 
 type TestClientConfiguration struct {
 	*config.Config
-	clientJwk jwk.Key
+	clientCertificate     *tls.Certificate
+	clientJwk             jwk.Key
+	idTokenDecryptionKeys jwk.Set
 }
 
 func (c *TestClientConfiguration) ACRValues() string {
 	return c.Config.OpenID.ACRValues
 }
 
+func (c *TestClientConfiguration) ClientAuthMethod() config.ClientAuthMethod {
+	if len(c.Config.OpenID.ClientAuthMethod) == 0 {
+		return config.ClientAuthMethodPrivateKeyJWT
+	}
+
+	return c.Config.OpenID.ClientAuthMethod
+}
+
+func (c *TestClientConfiguration) ClientCertificate() *tls.Certificate {
+	if !c.ClientAuthMethod().IsMutualTLS() {
+		return nil
+	}
+
+	return c.clientCertificate
+}
+
 func (c *TestClientConfiguration) ClientID() string {
 	return c.Config.OpenID.ClientID
 }
@@ -25,6 +52,40 @@ func (c *TestClientConfiguration) ClientJWK() jwk.Key {
 	return c.clientJwk
 }
 
+func (c *TestClientConfiguration) DomainHint() string {
+	return c.Config.OpenID.DomainHint
+}
+
+func (c *TestClientConfiguration) IDTokenDecryptionKeys() jwk.Set {
+	if c.idTokenDecryptionKeys != nil {
+		return c.idTokenDecryptionKeys
+	}
+
+	// fall back to the client JWK, unless it is explicitly a signing key
+	keys := jwk.NewSet()
+	if key := c.ClientJWK(); key != nil && !c.ClientAuthMethod().IsMutualTLS() && key.KeyUsage() != string(jwk.ForSignature) {
+		_ = keys.AddKey(key)
+	}
+
+	return keys
+}
+
+func (c *TestClientConfiguration) SetIDTokenDecryptionKeys(keys jwk.Set) {
+	c.idTokenDecryptionKeys = keys
+}
+
+func (c *TestClientConfiguration) IDTokenIatMaxAge() time.Duration {
+	return c.Config.OpenID.IDTokenIatMaxAge
+}
+
+func (c *TestClientConfiguration) LoginHint() string {
+	return c.Config.OpenID.LoginHint
+}
+
+func (c *TestClientConfiguration) MaxAge() string {
+	return c.Config.OpenID.MaxAge
+}
+
 func (c *TestClientConfiguration) SetPostLogoutRedirectURI(uri string) {
 	c.Config.OpenID.PostLogoutRedirectURI = uri
 }
@@ -33,6 +94,18 @@ func (c *TestClientConfiguration) PostLogoutRedirectURI() string {
 	return c.Config.OpenID.PostLogoutRedirectURI
 }
 
+func (c *TestClientConfiguration) Prompt() string {
+	return c.Config.OpenID.Prompt
+}
+
+func (c *TestClientConfiguration) ResponseMode() config.ResponseMode {
+	if len(c.Config.OpenID.ResponseMode) == 0 {
+		return config.ResponseModeQuery
+	}
+
+	return c.Config.OpenID.ResponseMode
+}
+
 func (c *TestClientConfiguration) Scopes() scopes.Scopes {
 	return scopes.DefaultScopes().WithAdditional(c.Config.OpenID.Scopes...)
 }
@@ -53,8 +126,55 @@ func clientConfiguration(cfg *config.Config) *TestClientConfiguration {
 		panic(err)
 	}
 
+	certificate, err := newSelfSignedCertificate(cfg.OpenID.ClientID)
+	if err != nil {
+		panic(err)
+	}
+
+	var decryptionKeys jwk.Set
+	if len(cfg.OpenID.IDTokenDecryptionJWK) > 0 {
+		decryptionKeys, err = jwk.ParseString(cfg.OpenID.IDTokenDecryptionJWK)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	return &TestClientConfiguration{
-		Config:    cfg,
-		clientJwk: key,
+		Config:                cfg,
+		clientCertificate:     certificate,
+		clientJwk:             key,
+		idTokenDecryptionKeys: decryptionKeys,
 	}
 }
+
+func newSelfSignedCertificate(commonName string) (*tls.Certificate, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  privateKey,
+		Leaf:        leaf,
+	}, nil
+}
@@ -18,15 +18,20 @@ func Config() *config.Config {
 		Ingresses:     []string{Ingress},
 		OpenID: config.OpenID{
 			ACRValues:             "Level4",
+			ClientAuthMethod:      config.ClientAuthMethodPrivateKeyJWT,
+			ResponseMode:          config.ResponseModeQuery,
 			ClientID:              "client-id",
+			IDTokenIatMaxAge:      5 * time.Minute,
 			PostLogoutRedirectURI: "https://google.com",
 			Provider:              "test",
 			Scopes:                []string{"some-scope"},
 			UILocales:             "nb",
 		},
 		Session: config.Session{
-			MaxLifetime: time.Hour,
+			MaxLifetime:            time.Hour,
+			WebSocketCheckInterval: time.Minute,
 		},
+		UpstreamHost: "127.0.0.1:8080",
 	}
 }
 
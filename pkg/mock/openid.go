@@ -1,8 +1,11 @@
 package mock
 
 import (
+	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
@@ -13,7 +16,9 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
 	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 
 	"github.com/nais/wonderwall/pkg/config"
@@ -44,12 +49,16 @@ type IdentityProvider struct {
 	OpenIDConfig        *TestConfiguration
 	ProviderHandler     *IdentityProviderHandler
 	ProviderServer      *httptest.Server
+	ProviderMTLSServer  *httptest.Server
 	RelyingPartyHandler RelyingPartyHandler
 	RelyingPartyServer  *httptest.Server
 }
 
 func (in *IdentityProvider) Close() {
 	in.ProviderServer.Close()
+	if in.ProviderMTLSServer != nil {
+		in.ProviderMTLSServer.Close()
+	}
 	in.RelyingPartyServer.Close()
 }
 
@@ -88,6 +97,7 @@ func NewIdentityProvider(cfg *config.Config) *IdentityProvider {
 	jwksProvider := NewTestJwksProvider()
 	handler := newIdentityProviderHandler(jwksProvider, openidConfig)
 	idpRouter := identityProviderRouter(handler)
+
 	server := httptest.NewServer(idpRouter)
 
 	openidConfig.TestProvider.SetAuthorizationEndpoint(server.URL + "/authorize")
@@ -95,6 +105,17 @@ func NewIdentityProvider(cfg *config.Config) *IdentityProvider {
 	openidConfig.TestProvider.SetIssuer(server.URL)
 	openidConfig.TestProvider.SetJwksURI(server.URL + "/jwks")
 	openidConfig.TestProvider.SetTokenEndpoint(server.URL + "/token")
+	openidConfig.TestProvider.SetUserInfoEndpoint(server.URL + "/userinfo")
+
+	// mutual TLS endpoint aliases are served by a separate TLS server that requests client certificates
+	var mtlsServer *httptest.Server
+	mutualTLS := openidConfig.Client().ClientAuthMethod().IsMutualTLS()
+	if mutualTLS {
+		mtlsServer = httptest.NewUnstartedServer(identityProviderMTLSRouter(handler))
+		mtlsServer.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+		mtlsServer.StartTLS()
+		openidConfig.TestProvider.SetMTLSTokenEndpoint(mtlsServer.URL + "/token")
+	}
 
 	crypter := crypto.NewCrypter([]byte(cfg.EncryptionKey))
 
@@ -104,6 +125,15 @@ func NewIdentityProvider(cfg *config.Config) *IdentityProvider {
 		panic(err)
 	}
 
+	if mutualTLS {
+		// trust the identity provider's self-signed server certificate while presenting the client certificate
+		idpClient := mtlsServer.Client()
+		transport := idpClient.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*openidConfig.Client().ClientCertificate()}
+		idpClient.Transport = transport
+		rpHandler.GetClient().SetHttpClient(idpClient)
+	}
+
 	rpRouter := router.New(rpHandler)
 	rpServer := httptest.NewServer(rpRouter)
 
@@ -114,6 +144,7 @@ func NewIdentityProvider(cfg *config.Config) *IdentityProvider {
 		OpenIDConfig:        openidConfig,
 		ProviderHandler:     handler,
 		ProviderServer:      server,
+		ProviderMTLSServer:  mtlsServer,
 	}
 
 	// reconfigure ingresses after Relying Party server is started
@@ -127,31 +158,45 @@ func identityProviderRouter(ip *IdentityProviderHandler) chi.Router {
 	r.Post("/token", ip.Token)
 	r.Get("/jwks", ip.Jwks)
 	r.Get("/endsession", ip.EndSession)
+	r.Get("/userinfo", ip.UserInfo)
+	return r
+}
+
+func identityProviderMTLSRouter(ip *IdentityProviderHandler) chi.Router {
+	r := chi.NewRouter()
+	r.Post("/token", ip.Token)
 	return r
 }
 
 type IdentityProviderHandler struct {
-	Codes         map[string]*AuthorizeRequest
-	Config        openidconfig.Config
-	Provider      *TestProvider
-	Sessions      map[string]string
-	RefreshTokens map[string]*RefreshTokenData
-	TokenDuration time.Duration
+	Codes           map[string]*AuthorizeRequest
+	Config          openidconfig.Config
+	EncryptIDTokens bool                   // EncryptIDTokens encrypts all issued id_tokens to the client's decryption keys.
+	IDTokenClaims   map[string]interface{} // IDTokenClaims are set in all issued id_tokens, overriding default claims.
+	UserInfoClaims  map[string]interface{} // UserInfoClaims are returned from the userinfo endpoint in addition to 'sub'.
+	Provider        *TestProvider
+	Sessions        map[string]string
+	RefreshTokens   map[string]*RefreshTokenData
+	TokenDuration   time.Duration
+	TokenExchanges  int // TokenExchanges counts the number of successful token exchange grants.
 }
 
 func newIdentityProviderHandler(provider *TestProvider, cfg openidconfig.Config) *IdentityProviderHandler {
 	return &IdentityProviderHandler{
-		Codes:         make(map[string]*AuthorizeRequest),
-		Config:        cfg,
-		Provider:      provider,
-		Sessions:      make(map[string]string),
-		RefreshTokens: make(map[string]*RefreshTokenData),
-		TokenDuration: time.Minute,
+		Codes:          make(map[string]*AuthorizeRequest),
+		Config:         cfg,
+		IDTokenClaims:  make(map[string]interface{}),
+		UserInfoClaims: make(map[string]interface{}),
+		Provider:       provider,
+		Sessions:       make(map[string]string),
+		RefreshTokens:  make(map[string]*RefreshTokenData),
+		TokenDuration:  time.Minute,
 	}
 }
 
 type AuthorizeRequest struct {
 	AcrLevel      string
+	AuthTime      time.Time
 	ClientID      string
 	CodeChallenge string
 	Locale        string
@@ -190,6 +235,47 @@ func (ip *IdentityProviderHandler) signToken(token jwt.Token) (string, error) {
 	return string(signedToken), nil
 }
 
+// SignToken signs the given token with the identity provider's private key, e.g. to issue bearer tokens in tests.
+func (ip *IdentityProviderHandler) SignToken(token jwt.Token) (string, error) {
+	return ip.signToken(token)
+}
+
+// issueIDToken signs the given id_token, and encrypts it to the client if EncryptIDTokens is set.
+func (ip *IdentityProviderHandler) issueIDToken(token jwt.Token) (string, error) {
+	signedToken, err := ip.signToken(token)
+	if err != nil {
+		return "", err
+	}
+
+	if !ip.EncryptIDTokens {
+		return signedToken, nil
+	}
+
+	key, ok := ip.Config.Client().IDTokenDecryptionKeys().Key(0)
+	if !ok {
+		return "", fmt.Errorf("could not get client decryption key")
+	}
+
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		return "", err
+	}
+
+	headers := jwe.NewHeaders()
+	headers.Set(jwe.ContentTypeKey, "JWT")
+
+	encryptedToken, err := jwe.Encrypt([]byte(signedToken),
+		jwe.WithKey(jwa.RSA_OAEP_256, publicKey),
+		jwe.WithContentEncryption(jwa.A256GCM),
+		jwe.WithProtectedHeaders(headers),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return string(encryptedToken), nil
+}
+
 func (ip *IdentityProviderHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 
@@ -237,7 +323,7 @@ func (ip *IdentityProviderHandler) Authorize(w http.ResponseWriter, r *http.Requ
 	allowedParamValues := map[string][]string{
 		"code_challenge_method": {"S256"},
 		"response_type":         {"code"},
-		"response_mode":         {"query"},
+		"response_mode":         {"query", "form_post", "query.jwt", "form_post.jwt"},
 		"acr_values":            {"", "Level3", "Level4"},
 		"ui_locales":            {"", "nb", "nn", "en", "se"},
 	}
@@ -280,6 +366,7 @@ func (ip *IdentityProviderHandler) Authorize(w http.ResponseWriter, r *http.Requ
 	code := uuid.New().String()
 	ip.Codes[code] = &AuthorizeRequest{
 		AcrLevel:      acrLevel,
+		AuthTime:      time.Now().Truncate(time.Second),
 		ClientID:      clientId,
 		CodeChallenge: codeChallenge,
 		Locale:        locale,
@@ -301,11 +388,59 @@ func (ip *IdentityProviderHandler) Authorize(w http.ResponseWriter, r *http.Requ
 		v.Set("session_state", sessionID)
 	}
 
+	if strings.HasSuffix(responseMode, ".jwt") {
+		response, err := ip.signAuthorizationResponse(clientId, v)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("could not sign authorization response: " + err.Error()))
+			return
+		}
+
+		v = url.Values{}
+		v.Set("response", response)
+	}
+
+	if strings.HasPrefix(responseMode, "form_post") {
+		w.Header().Set("Content-Type", "text/html")
+		formPostTemplate.Execute(w, struct {
+			Action string
+			Params url.Values
+		}{
+			Action: u.String(),
+			Params: v,
+		})
+		return
+	}
+
 	u.RawQuery = v.Encode()
 
 	http.Redirect(w, r, u.String(), http.StatusTemporaryRedirect)
 }
 
+var formPostTemplate = template.Must(template.New("form_post").Parse(`<html>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{ .Action }}">
+{{- range $name, $values := .Params }}{{ range $values }}
+<input type="hidden" name="{{ $name }}" value="{{ . }}"/>
+{{- end }}{{ end }}
+</form>
+</body>
+</html>`))
+
+// signAuthorizationResponse returns the given authorization response parameters as a signed JWT, see JARM section 2.1.
+func (ip *IdentityProviderHandler) signAuthorizationResponse(clientID string, params url.Values) (string, error) {
+	token := jwt.New()
+	token.Set("iss", ip.Config.Provider().Issuer())
+	token.Set("aud", clientID)
+	token.Set("exp", time.Now().Add(time.Minute).Unix())
+
+	for param := range params {
+		token.Set(param, params.Get(param))
+	}
+
+	return ip.signToken(token)
+}
+
 func (ip *IdentityProviderHandler) Jwks(w http.ResponseWriter, r *http.Request) {
 	jwks, _ := ip.Provider.GetPublicJwkSet(r.Context())
 	json.NewEncoder(w).Encode(jwks)
@@ -327,6 +462,9 @@ func (ip *IdentityProviderHandler) Token(w http.ResponseWriter, r *http.Request)
 	case "refresh_token":
 		ip.RefreshTokenGrant(w, r)
 		return
+	case openid.TokenExchangeValue:
+		ip.TokenExchangeGrant(w, r)
+		return
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("unsupported grant_type: " + grantType))
@@ -393,6 +531,11 @@ func (ip *IdentityProviderHandler) TokenCodeGrant(w http.ResponseWriter, r *http
 	exp := iat.Add(ip.TokenDuration)
 	sub := uuid.New().String()
 
+	authTime := auth.AuthTime
+	if authTime.IsZero() {
+		authTime = iat
+	}
+
 	accessToken := jwt.New()
 	accessToken.Set("sub", sub)
 	accessToken.Set("iss", ip.Config.Provider().Issuer())
@@ -414,6 +557,7 @@ func (ip *IdentityProviderHandler) TokenCodeGrant(w http.ResponseWriter, r *http
 	idToken.Set("locale", auth.Locale)
 	idToken.Set("nonce", auth.Nonce)
 	idToken.Set("acr", auth.AcrLevel)
+	idToken.Set("auth_time", authTime.Unix())
 	idToken.Set("iat", iat.Unix())
 	idToken.Set("exp", exp.Unix())
 	idToken.Set("jti", uuid.NewString())
@@ -423,7 +567,19 @@ func (ip *IdentityProviderHandler) TokenCodeGrant(w http.ResponseWriter, r *http
 		idToken.Set("sid", auth.SessionID)
 	}
 
-	signedIdToken, err := ip.signToken(idToken)
+	atHash, err := openid.TokenHash(jwa.RS256, signedAccessToken)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not create at_hash: " + err.Error()))
+		return
+	}
+	idToken.Set("at_hash", atHash)
+
+	for claim, value := range ip.IDTokenClaims {
+		idToken.Set(claim, value)
+	}
+
+	signedIdToken, err := ip.issueIDToken(idToken)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("could not sign access token: " + err.Error()))
@@ -490,6 +646,36 @@ func (ip *IdentityProviderHandler) RefreshTokenGrant(w http.ResponseWriter, r *h
 		return
 	}
 
+	idToken, err := data.OriginalIDToken.Clone()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not clone id token: " + err.Error()))
+		return
+	}
+	idToken.Set("iat", iat.Unix())
+	idToken.Set("exp", exp.Unix())
+	idToken.Set("jti", uuid.NewString())
+	idToken.Remove("nonce")
+
+	atHash, err := openid.TokenHash(jwa.RS256, signedAccessToken)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not create at_hash: " + err.Error()))
+		return
+	}
+	idToken.Set("at_hash", atHash)
+
+	for claim, value := range ip.IDTokenClaims {
+		idToken.Set(claim, value)
+	}
+
+	signedIdToken, err := ip.issueIDToken(idToken)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not sign id token: " + err.Error()))
+		return
+	}
+
 	// remove provided refresh_token as it is now used
 	delete(ip.RefreshTokens, refreshToken)
 
@@ -499,6 +685,7 @@ func (ip *IdentityProviderHandler) RefreshTokenGrant(w http.ResponseWriter, r *h
 	token := &tokenResponse{
 		AccessToken:  signedAccessToken,
 		TokenType:    "Bearer",
+		IDToken:      signedIdToken,
 		RefreshToken: refreshToken,
 		ExpiresIn:    int64(ip.TokenDuration.Seconds()),
 	}
@@ -515,6 +702,65 @@ func (ip *IdentityProviderHandler) RefreshTokenGrant(w http.ResponseWriter, r *h
 	json.NewEncoder(w).Encode(token)
 }
 
+func (ip *IdentityProviderHandler) TokenExchangeGrant(w http.ResponseWriter, r *http.Request) {
+	err := ip.validateClientAuthentication(w, r, ip.Config.Client().ClientID())
+	if err != nil {
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if r.PostForm.Get(openid.SubjectTokenType) != openid.TokenTypeAccessTokenValue {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unsupported subject_token_type"))
+		return
+	}
+
+	audience := r.PostForm.Get(openid.Audience)
+	if len(audience) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("missing audience"))
+		return
+	}
+
+	subjectToken, err := jwt.ParseString(r.PostForm.Get(openid.SubjectToken),
+		jwt.WithKeySet(ip.Provider.JwksPair.Public, jws.WithInferAlgorithmFromKey(true)),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(ip.Config.Provider().Issuer()),
+	)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid subject_token: " + err.Error()))
+		return
+	}
+
+	iat := time.Now().Truncate(time.Second)
+	exp := iat.Add(ip.TokenDuration)
+
+	accessToken := jwt.New()
+	accessToken.Set("sub", subjectToken.Subject())
+	accessToken.Set("aud", audience)
+	accessToken.Set("iss", ip.Config.Provider().Issuer())
+	accessToken.Set("iat", iat.Unix())
+	accessToken.Set("exp", exp.Unix())
+	accessToken.Set("jti", uuid.NewString())
+	signedAccessToken, err := ip.signToken(accessToken)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not sign access token: " + err.Error()))
+		return
+	}
+
+	ip.TokenExchanges++
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&tokenResponse{
+		AccessToken: signedAccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(ip.TokenDuration.Seconds()),
+	})
+}
+
 func (ip *IdentityProviderHandler) validateClientAuthentication(w http.ResponseWriter, r *http.Request, expectedClientID string) error {
 	clientID := r.PostForm.Get("client_id")
 	if len(clientID) == 0 {
@@ -527,6 +773,10 @@ func (ip *IdentityProviderHandler) validateClientAuthentication(w http.ResponseW
 		return fmt.Errorf("client_id does not match client_id for original authorization")
 	}
 
+	if ip.Config.Client().ClientAuthMethod().IsMutualTLS() {
+		return ip.validateMutualTLSClientAuthentication(w, r)
+	}
+
 	clientAssertion := r.PostForm.Get("client_assertion")
 	if len(clientAssertion) == 0 {
 		w.WriteHeader(http.StatusBadRequest)
@@ -562,6 +812,56 @@ func (ip *IdentityProviderHandler) validateClientAuthentication(w http.ResponseW
 	return nil
 }
 
+func (ip *IdentityProviderHandler) validateMutualTLSClientAuthentication(w http.ResponseWriter, r *http.Request) error {
+	if r.TLS == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("mutual TLS client authentication must use the mtls_endpoint_aliases token endpoint")
+	}
+
+	if len(r.PostForm.Get("client_assertion")) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("unexpected client_assertion with mutual TLS client authentication")
+	}
+
+	if len(r.TLS.PeerCertificates) == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return fmt.Errorf("missing client certificate")
+	}
+
+	expected := ip.Config.Client().ClientCertificate().Leaf
+	if !bytes.Equal(r.TLS.PeerCertificates[0].Raw, expected.Raw) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return fmt.Errorf("client certificate does not match registered certificate")
+	}
+
+	return nil
+}
+
+func (ip *IdentityProviderHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	token, err := jwt.ParseString(accessToken,
+		jwt.WithKeySet(ip.Provider.JwksPair.Public, jws.WithInferAlgorithmFromKey(true)),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(ip.Config.Provider().Issuer()),
+	)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid access token: " + err.Error()))
+		return
+	}
+
+	claims := map[string]interface{}{
+		"sub": token.Subject(),
+	}
+	for claim, value := range ip.UserInfoClaims {
+		claims[claim] = value
+	}
+
+	w.Header().Set("content-type", "application/json")
+	json.NewEncoder(w).Encode(claims)
+}
+
 func (ip *IdentityProviderHandler) EndSession(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	postLogoutRedirectURI := query.Get("post_logout_redirect_uri")
@@ -3,6 +3,7 @@ package mock
 import (
 	"context"
 	"net/url"
+	"sync"
 
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	log "github.com/sirupsen/logrus"
@@ -14,20 +15,68 @@ import (
 
 type TestProvider struct {
 	JwksPair *crypto.JwkSet
+
+	lock  sync.Mutex
+	err   error
+	stale *jwk.Set
 }
 
 func (p *TestProvider) GetPublicJwkSet(_ context.Context) (*jwk.Set, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	if p.stale != nil {
+		return p.stale, nil
+	}
+
 	return &p.JwksPair.Public, nil
 }
 
 func (p *TestProvider) RefreshPublicJwkSet(_ context.Context) (*jwk.Set, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	p.stale = nil
 	return &p.JwksPair.Public, nil
 }
 
 func (p *TestProvider) PrivateJwkSet() *jwk.Set {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
 	return &p.JwksPair.Private
 }
 
+// RotateKeys replaces the signing keys. GetPublicJwkSet returns the previous public keys until the key set is refreshed.
+func (p *TestProvider) RotateKeys() {
+	jwksPair, err := crypto.NewJwkSet()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.stale = &p.JwksPair.Public
+	p.JwksPair = jwksPair
+}
+
+// SetError makes fetching the public keys fail with the given error. A nil error restores the default behaviour.
+func (p *TestProvider) SetError(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.err = err
+}
+
 func NewTestJwksProvider() *TestProvider {
 	jwksPair, err := crypto.NewJwkSet()
 	if err != nil {
@@ -48,6 +97,10 @@ func (t *TestProviderConfiguration) AuthorizationEndpoint() string {
 	return t.metadata.AuthorizationEndpoint
 }
 
+func (t *TestProviderConfiguration) CheckSessionIframe() string {
+	return t.metadata.CheckSessionIframe
+}
+
 func (t *TestProviderConfiguration) EndSessionEndpointURL() url.URL {
 	u, _ := url.Parse(t.metadata.EndSessionEndpoint)
 	return *u
@@ -62,13 +115,38 @@ func (t *TestProviderConfiguration) JwksURI() string {
 }
 
 func (t *TestProviderConfiguration) TokenEndpoint() string {
-	return t.metadata.TokenEndpoint
+	return t.metadata.TokenEndpointFor(t.cfg.OpenID.ClientAuthMethod)
+}
+
+func (t *TestProviderConfiguration) UserInfoEndpoint() string {
+	return t.metadata.UserInfoEndpoint
 }
 
 func (t *TestProviderConfiguration) ACRValuesSupported() openidconfig.Supported {
 	return t.metadata.ACRValuesSupported
 }
 
+func (t *TestProviderConfiguration) AuthorizationSigningAlgs() openidconfig.Supported {
+	return t.metadata.AuthorizationSigningAlgs()
+}
+
+func (t *TestProviderConfiguration) IDTokenSigningAlgs() openidconfig.Supported {
+	algs, err := openidconfig.ResolveIDTokenSigningAlgs(t.cfg.OpenID.IDTokenSigningAlgs, t.metadata.IDTokenSigningAlgValuesSupported)
+	if err != nil {
+		return openidconfig.Supported{}
+	}
+
+	return algs
+}
+
+func (t *TestProviderConfiguration) PromptValuesSupported() openidconfig.Supported {
+	if len(t.metadata.PromptValuesSupported) == 0 {
+		return openidconfig.DefaultPromptValuesSupported
+	}
+
+	return t.metadata.PromptValuesSupported
+}
+
 func (t *TestProviderConfiguration) UILocalesSupported() openidconfig.Supported {
 	return t.metadata.UILocalesSupported
 }
@@ -105,6 +183,10 @@ func (t *TestProviderConfiguration) SetFrontchannelLogoutSessionSupported(val bo
 	t.metadata.FrontchannelLogoutSessionSupported = val
 }
 
+func (t *TestProviderConfiguration) SetIDTokenSigningAlgValuesSupported(values ...string) {
+	t.metadata.IDTokenSigningAlgValuesSupported = values
+}
+
 func (t *TestProviderConfiguration) SetIssuer(url string) {
 	t.metadata.Issuer = url
 }
@@ -113,10 +195,26 @@ func (t *TestProviderConfiguration) SetJwksURI(url string) {
 	t.metadata.JwksURI = url
 }
 
+func (t *TestProviderConfiguration) SetMTLSTokenEndpoint(url string) {
+	if t.metadata.MTLSEndpointAliases == nil {
+		t.metadata.MTLSEndpointAliases = &openidconfig.MTLSEndpointAliases{}
+	}
+
+	t.metadata.MTLSEndpointAliases.TokenEndpoint = url
+}
+
+func (t *TestProviderConfiguration) SetPromptValuesSupported(values ...string) {
+	t.metadata.PromptValuesSupported = values
+}
+
 func (t *TestProviderConfiguration) SetTokenEndpoint(url string) {
 	t.metadata.TokenEndpoint = url
 }
 
+func (t *TestProviderConfiguration) SetUserInfoEndpoint(url string) {
+	t.metadata.UserInfoEndpoint = url
+}
+
 func (t *TestProviderConfiguration) WithFrontChannelLogoutSupport() {
 	t.SetFrontchannelLogoutSupported(true)
 	t.SetFrontchannelLogoutSessionSupported(true)
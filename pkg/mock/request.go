@@ -10,6 +10,7 @@ import (
 
 func NewGetRequest(target string, ingresses *ingress.Ingresses) *http.Request {
 	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req = mw.RequestWithIngresses(req, ingresses)
 	match, ok := ingresses.MatchingIngress(req)
 	if ok {
 		req = mw.RequestWithIngress(req, match)
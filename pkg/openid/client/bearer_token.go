@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	jwtlib "github.com/lestrrat-go/jwx/v2/jwt"
+
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/jwt"
+	"github.com/nais/wonderwall/pkg/openid"
+)
+
+var ErrInvalidBearerToken = errors.New("invalid bearer token")
+
+// bearerTokenForbiddenClaims contains claims that are only found in id_tokens and JWT secured authorization responses.
+// Such tokens are issued by the identity provider with the same keys as access tokens, but must not be accepted as such.
+var bearerTokenForbiddenClaims = []string{
+	jwt.NonceClaim,
+	jwt.AtHashClaim,
+	openid.Code,
+	openid.State,
+}
+
+// ValidateBearerToken verifies the signature of the given bearer token with the identity provider's public keys and
+// validates its signing algorithm, issuer, expiry and audience. The token must contain at least one of the configured
+// audiences. Tokens with claims that are specific to id_tokens or authorization responses are rejected. The validated
+// token is returned.
+func (c *Client) ValidateBearerToken(ctx context.Context, raw string, cfg config.BearerToken) (jwtlib.Token, error) {
+	if jwt.IsEncrypted(raw) {
+		return nil, fmt.Errorf("%w: encrypted tokens are not supported", ErrInvalidBearerToken)
+	}
+
+	alg, err := jwt.Algorithm(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %+v", ErrInvalidBearerToken, err)
+	}
+
+	if !contains(cfg.SigningAlgs, alg.String()) {
+		return nil, fmt.Errorf("%w: signing algorithm %q is not one of %q", ErrInvalidBearerToken, alg, cfg.SigningAlgs)
+	}
+
+	token, err := c.parseJwt(ctx, raw, alg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %+v", ErrInvalidBearerToken, err)
+	}
+
+	err = jwtlib.Validate(token,
+		jwtlib.WithAcceptableSkew(jwt.AcceptableClockSkew),
+		jwtlib.WithIssuer(c.cfg.Provider().Issuer()),
+		jwtlib.WithRequiredClaim(jwtlib.ExpirationKey),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %+v", ErrInvalidBearerToken, err)
+	}
+
+	for _, claim := range bearerTokenForbiddenClaims {
+		if _, ok := token.Get(claim); ok {
+			return nil, fmt.Errorf("%w: unexpected '%s' claim, not an access token", ErrInvalidBearerToken, claim)
+		}
+	}
+
+	for _, aud := range token.Audience() {
+		if contains(cfg.Audiences, aud) {
+			return token, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: 'aud' claim %q does not contain any of %q", ErrInvalidBearerToken, token.Audience(), cfg.Audiences)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -12,10 +12,12 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"golang.org/x/oauth2"
 
+	jwtpkg "github.com/nais/wonderwall/pkg/jwt"
 	"github.com/nais/wonderwall/pkg/loginstatus"
 	"github.com/nais/wonderwall/pkg/openid"
 	openidconfig "github.com/nais/wonderwall/pkg/openid/config"
@@ -63,6 +65,31 @@ func NewClient(cfg openidconfig.Config, loginstatus *loginstatus.Loginstatus, jw
 	}
 }
 
+// parseJwt parses the given JWT and verifies its signature with the identity provider's public keys, using only the
+// given algorithm. The caller must check the algorithm against the accepted algorithms beforehand.
+func (c *Client) parseJwt(ctx context.Context, raw string, alg jwa.SignatureAlgorithm) (jwt.Token, error) {
+	jwkSet, err := c.jwksProvider.GetPublicJwkSet(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting jwks: %w", err)
+	}
+
+	token, err := jwtpkg.Parse(raw, *jwkSet, alg)
+	if err != nil {
+		// JWKS might not be up-to-date, so we'll force a refresh and retry once
+		jwkSet, refreshErr := c.jwksProvider.RefreshPublicJwkSet(ctx)
+		if refreshErr != nil {
+			return nil, fmt.Errorf("refreshing jwks: %w", refreshErr)
+		}
+
+		token, err = jwtpkg.Parse(raw, *jwkSet, alg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return token, nil
+}
+
 func (c *Client) SetHttpClient(httpClient *http.Client) {
 	c.httpClient = httpClient
 }
@@ -103,9 +130,40 @@ func (c *Client) LogoutFrontchannel(r *http.Request) *LogoutFrontchannel {
 }
 
 func (c *Client) AuthCodeGrant(ctx context.Context, code string, opts []oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	params, err := c.ClientAuthParams()
+	if err != nil {
+		return nil, fmt.Errorf("creating client authentication: %w", err)
+	}
+
+	for key := range params {
+		opts = append(opts, oauth2.SetAuthURLParam(key, params.Get(key)))
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c.httpClient)
 	return c.oauth2Config.Exchange(ctx, code, opts...)
 }
 
+// ClientAuthParams returns the parameters used to authenticate the client in requests to the token endpoint.
+// With mutual TLS client authentication, the client is authenticated by its certificate and only the client ID is
+// included, see RFC 8705, section 2.
+func (c *Client) ClientAuthParams() (url.Values, error) {
+	v := url.Values{}
+	v.Set(openid.ClientID, c.cfg.Client().ClientID())
+
+	if c.cfg.Client().ClientAuthMethod().IsMutualTLS() {
+		return v, nil
+	}
+
+	assertion, err := c.MakeAssertion(DefaultClientAssertionLifetime)
+	if err != nil {
+		return nil, fmt.Errorf("creating client assertion: %w", err)
+	}
+
+	v.Set(openid.ClientAssertion, assertion)
+	v.Set(openid.ClientAssertionType, openid.ClientAssertionTypeJwtBearer)
+	return v, nil
+}
+
 func (c *Client) MakeAssertion(expiration time.Duration) (string, error) {
 	clientCfg := c.cfg.Client()
 	providerCfg := c.cfg.Provider()
@@ -139,18 +197,34 @@ func (c *Client) MakeAssertion(expiration time.Duration) (string, error) {
 }
 
 func (c *Client) RefreshGrant(ctx context.Context, refreshToken string) (*openid.TokenResponse, error) {
-	assertion, err := c.MakeAssertion(DefaultClientAssertionLifetime)
+	v, err := c.ClientAuthParams()
 	if err != nil {
-		return nil, fmt.Errorf("creating client assertion: %w", err)
+		return nil, fmt.Errorf("creating client authentication: %w", err)
 	}
 
-	v := url.Values{}
 	v.Set(openid.GrantType, openid.RefreshTokenValue)
 	v.Set(openid.RefreshToken, refreshToken)
-	v.Set(openid.ClientID, c.cfg.Client().ClientID())
-	v.Set(openid.ClientAssertion, assertion)
-	v.Set(openid.ClientAssertionType, openid.ClientAssertionTypeJwtBearer)
 
+	return c.tokenRequest(ctx, v)
+}
+
+// TokenExchangeGrant exchanges the given access token for a token with the given target audience, as defined in
+// RFC 8693.
+func (c *Client) TokenExchangeGrant(ctx context.Context, accessToken, audience string) (*openid.TokenResponse, error) {
+	v, err := c.ClientAuthParams()
+	if err != nil {
+		return nil, fmt.Errorf("creating client authentication: %w", err)
+	}
+
+	v.Set(openid.GrantType, openid.TokenExchangeValue)
+	v.Set(openid.SubjectToken, accessToken)
+	v.Set(openid.SubjectTokenType, openid.TokenTypeAccessTokenValue)
+	v.Set(openid.Audience, audience)
+
+	return c.tokenRequest(ctx, v)
+}
+
+func (c *Client) tokenRequest(ctx context.Context, v url.Values) (*openid.TokenResponse, error) {
 	r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.Provider().TokenEndpoint(), strings.NewReader(v.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
@@ -185,3 +259,38 @@ func (c *Client) RefreshGrant(ctx context.Context, refreshToken string) (*openid
 
 	return &tokenResponse, nil
 }
+
+// RefreshedIDToken parses and validates the id_token returned in a refresh grant response against the id_token from
+// the original authentication.
+func (c *Client) RefreshedIDToken(ctx context.Context, resp *openid.TokenResponse, originalIDToken string) (*openid.IDToken, error) {
+	original, err := openid.ParseIDTokenUnverified(originalIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("parsing original id_token: %w", err)
+	}
+
+	jwkSet, err := c.jwksProvider.GetPublicJwkSet(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting jwks: %w", err)
+	}
+
+	idToken, err := openid.ParseIDToken(resp.IDToken, *jwkSet, c.cfg)
+	if errors.Is(err, openid.ErrIDTokenSignature) {
+		// JWKS might not be up-to-date, so we'll force a refresh and retry once
+		jwkSet, err = c.jwksProvider.RefreshPublicJwkSet(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("refreshing jwks: %w", err)
+		}
+
+		idToken, err = openid.ParseIDToken(resp.IDToken, *jwkSet, c.cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing id_token: %w", err)
+	}
+
+	err = idToken.ValidateRefreshed(c.cfg, original, resp.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("validating id_token: %w", err)
+	}
+
+	return idToken, nil
+}
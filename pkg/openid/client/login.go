@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	gostrings "strings"
 
 	"golang.org/x/oauth2"
 
@@ -17,24 +19,40 @@ import (
 )
 
 const (
+	DomainHintURLParameter    = "domain_hint"
 	LocaleURLParameter        = "locale"
+	LoginHintURLParameter     = "login_hint"
+	MaxAgeURLParameter        = "max_age"
+	PopupURLParameter         = "popup"
+	PromptURLParameter        = "prompt"
 	SecurityLevelURLParameter = "level"
 
-	ResponseModeQuery = "query"
-
 	CodeChallengeMethodS256 = "S256"
 )
 
 var (
 	ErrInvalidSecurityLevel  = errors.New("InvalidSecurityLevel")
 	ErrInvalidLocale         = errors.New("InvalidLocale")
+	ErrInvalidPrompt         = errors.New("InvalidPrompt")
+	ErrInvalidLoginHint      = errors.New("InvalidLoginHint")
+	ErrInvalidDomainHint     = errors.New("InvalidDomainHint")
+	ErrInvalidMaxAge         = errors.New("InvalidMaxAge")
+	ErrInvalidPopup          = errors.New("InvalidPopup")
 	ErrInvalidLoginParameter = errors.New("InvalidLoginParameter")
 
-	// LoginParameterMapping maps incoming login parameters to OpenID Connect parameters
+	// LoginParameterMapping maps incoming login parameters to OpenID Connect parameters.
+	// Only parameters present in this mapping are passed on to the authorization request.
 	LoginParameterMapping = map[string]string{
+		DomainHintURLParameter:    openid.DomainHint,
 		LocaleURLParameter:        openid.UILocales,
+		LoginHintURLParameter:     openid.LoginHint,
+		MaxAgeURLParameter:        openid.MaxAge,
+		PromptURLParameter:        openid.Prompt,
 		SecurityLevelURLParameter: openid.ACRValues,
 	}
+
+	// maxHintLength is the maximum allowed length for the login_hint and domain_hint parameters.
+	maxHintLength = 256
 )
 
 func NewLogin(c *Client, r *http.Request) (*Login, error) {
@@ -53,8 +71,15 @@ func NewLogin(c *Client, r *http.Request) (*Login, error) {
 		return nil, fmt.Errorf("generating auth code url: %w", err)
 	}
 
+	popup, err := popupRequested(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %+v", ErrInvalidPopup, err)
+	}
+
 	referer := urlpkg.CanonicalRedirect(r)
 	cookie := params.cookie(referer, callbackURL)
+	cookie.Popup = popup
+	cookie.Locale = r.URL.Query().Get(LocaleURLParameter)
 
 	return &Login{
 		authCodeURL:       url,
@@ -91,6 +116,10 @@ func (l *Login) Cookie() *openid.LoginCookie {
 	return l.cookie
 }
 
+func (l *Login) MaxAge() string {
+	return l.params.MaxAge
+}
+
 func (l *Login) Nonce() string {
 	return l.params.Nonce
 }
@@ -103,6 +132,7 @@ type loginParameters struct {
 	*Client
 	CodeVerifier  string
 	CodeChallenge string
+	MaxAge        string
 	Nonce         string
 	State         string
 }
@@ -135,7 +165,7 @@ func newLoginParameters(c *Client) (*loginParameters, error) {
 func (in *loginParameters) authCodeURL(r *http.Request, callbackURL string, loginstatus *loginstatus.Loginstatus) (string, error) {
 	opts := []oauth2.AuthCodeOption{
 		oauth2.SetAuthURLParam(openid.Nonce, in.Nonce),
-		oauth2.SetAuthURLParam(openid.ResponseMode, ResponseModeQuery),
+		oauth2.SetAuthURLParam(openid.ResponseMode, string(in.cfg.Client().ResponseMode())),
 		oauth2.SetAuthURLParam(openid.CodeChallenge, in.CodeChallenge),
 		oauth2.SetAuthURLParam(openid.CodeChallengeMethod, CodeChallengeMethodS256),
 		oauth2.SetAuthURLParam(openid.RedirectURI, callbackURL),
@@ -155,6 +185,26 @@ func (in *loginParameters) authCodeURL(r *http.Request, callbackURL string, logi
 		return "", fmt.Errorf("%w: %+v", ErrInvalidLocale, err)
 	}
 
+	opts, err = in.withPrompt(r, opts)
+	if err != nil {
+		return "", fmt.Errorf("%w: %+v", ErrInvalidPrompt, err)
+	}
+
+	opts, err = in.withLoginHint(r, opts)
+	if err != nil {
+		return "", fmt.Errorf("%w: %+v", ErrInvalidLoginHint, err)
+	}
+
+	opts, err = in.withDomainHint(r, opts)
+	if err != nil {
+		return "", fmt.Errorf("%w: %+v", ErrInvalidDomainHint, err)
+	}
+
+	opts, err = in.withMaxAge(r, opts)
+	if err != nil {
+		return "", fmt.Errorf("%w: %+v", ErrInvalidMaxAge, err)
+	}
+
 	authCodeUrl := in.oauth2Config.AuthCodeURL(in.State, opts...)
 	return authCodeUrl, nil
 }
@@ -164,8 +214,10 @@ func (in *loginParameters) cookie(referer, redirectURI string) *openid.LoginCook
 		State:        in.State,
 		Nonce:        in.Nonce,
 		CodeVerifier: in.CodeVerifier,
+		MaxAge:       in.MaxAge,
 		Referer:      referer,
 		RedirectURI:  redirectURI,
+		ResponseMode: string(in.cfg.Client().ResponseMode()),
 	}
 }
 
@@ -187,6 +239,86 @@ func (in *loginParameters) withSecurityLevel(r *http.Request, opts []oauth2.Auth
 	)
 }
 
+func (in *loginParameters) withPrompt(r *http.Request, opts []oauth2.AuthCodeOption) ([]oauth2.AuthCodeOption, error) {
+	supported := in.cfg.Provider().PromptValuesSupported()
+
+	return withOptionalParamMapping(r,
+		opts,
+		PromptURLParameter,
+		in.cfg.Client().Prompt(),
+		func(value string) error {
+			values := gostrings.Fields(value)
+			if len(values) > 1 && config.Supported(values).Contains(openid.PromptNone) {
+				return fmt.Errorf("'%s' cannot be combined with other values", openid.PromptNone)
+			}
+
+			return supported.ContainsAll(value)
+		},
+	)
+}
+
+func (in *loginParameters) withLoginHint(r *http.Request, opts []oauth2.AuthCodeOption) ([]oauth2.AuthCodeOption, error) {
+	return withOptionalParamMapping(r,
+		opts,
+		LoginHintURLParameter,
+		in.cfg.Client().LoginHint(),
+		validateHint,
+	)
+}
+
+func (in *loginParameters) withDomainHint(r *http.Request, opts []oauth2.AuthCodeOption) ([]oauth2.AuthCodeOption, error) {
+	return withOptionalParamMapping(r,
+		opts,
+		DomainHintURLParameter,
+		in.cfg.Client().DomainHint(),
+		validateHint,
+	)
+}
+
+func (in *loginParameters) withMaxAge(r *http.Request, opts []oauth2.AuthCodeOption) ([]oauth2.AuthCodeOption, error) {
+	opts, value, err := optionalParamMapping(r,
+		opts,
+		MaxAgeURLParameter,
+		in.cfg.Client().MaxAge(),
+		func(value string) error {
+			maxAge, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || maxAge < 0 {
+				return fmt.Errorf("must be a non-negative integer")
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	in.MaxAge = value
+	return opts, nil
+}
+
+// popupRequested returns true if the login was started in a popup window, i.e. with the 'popup' parameter set to true.
+// The callback then notifies the opener window instead of redirecting.
+func popupRequested(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get(PopupURLParameter)
+	if len(value) == 0 {
+		return false, nil
+	}
+
+	popup, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for %s=%s", PopupURLParameter, value)
+	}
+
+	return popup, nil
+}
+
+func validateHint(value string) error {
+	if len(value) > maxHintLength {
+		return fmt.Errorf("exceeds maximum length of %d", maxHintLength)
+	}
+	return nil
+}
+
 func withParamMapping(r *http.Request, opts []oauth2.AuthCodeOption, param, fallback string, supported config.Supported) ([]oauth2.AuthCodeOption, error) {
 	if len(fallback) == 0 {
 		return opts, nil
@@ -201,6 +333,31 @@ func withParamMapping(r *http.Request, opts []oauth2.AuthCodeOption, param, fall
 	return opts, nil
 }
 
+// withOptionalParamMapping adds the given parameter to the authorization request if set in the request or
+// through the fallback value. The parameter is omitted if neither are set.
+func withOptionalParamMapping(r *http.Request, opts []oauth2.AuthCodeOption, param, fallback string, validate func(string) error) ([]oauth2.AuthCodeOption, error) {
+	opts, _, err := optionalParamMapping(r, opts, param, fallback, validate)
+	return opts, err
+}
+
+func optionalParamMapping(r *http.Request, opts []oauth2.AuthCodeOption, param, fallback string, validate func(string) error) ([]oauth2.AuthCodeOption, string, error) {
+	value := r.URL.Query().Get(param)
+	if len(value) == 0 {
+		value = fallback
+	}
+
+	if len(value) == 0 {
+		return opts, "", nil
+	}
+
+	if err := validate(value); err != nil {
+		return nil, "", fmt.Errorf("%w: invalid value for %s=%s: %+v", ErrInvalidLoginParameter, param, value, err)
+	}
+
+	opts = append(opts, oauth2.SetAuthURLParam(LoginParameterMapping[param], value))
+	return opts, value, nil
+}
+
 // LoginURLParameter attempts to get a given parameter from the given HTTP request, falling back if none found.
 // The value must exist in the supplied list of supported values.
 func LoginURLParameter(r *http.Request, parameter, fallback string, supported config.Supported) (string, error) {
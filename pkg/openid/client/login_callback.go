@@ -2,16 +2,22 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 
+	jwtlib "github.com/lestrrat-go/jwx/v2/jwt"
 	"golang.org/x/oauth2"
 
+	"github.com/nais/wonderwall/pkg/config"
 	urlpkg "github.com/nais/wonderwall/pkg/handler/url"
+	"github.com/nais/wonderwall/pkg/jwt"
 	"github.com/nais/wonderwall/pkg/openid"
 )
 
+var ErrInvalidAuthorizationResponse = errors.New("InvalidAuthorizationResponse")
+
 type LoginCallback struct {
 	*Client
 	cookie        *openid.LoginCookie
@@ -34,14 +40,24 @@ func NewLoginCallback(c *Client, r *http.Request, cookie *openid.LoginCookie) (*
 		cookie.RedirectURI = callbackURL
 	}
 
+	requestParams, err := authorizationResponseParams(c, r, cookie)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %+v", ErrInvalidAuthorizationResponse, err)
+	}
+
 	return &LoginCallback{
 		Client:        c,
 		cookie:        cookie,
 		request:       r,
-		requestParams: r.URL.Query(),
+		requestParams: requestParams,
 	}, nil
 }
 
+// ResponseParams returns the parameters from the authorization response.
+func (in *LoginCallback) ResponseParams() url.Values {
+	return in.requestParams
+}
+
 func (in *LoginCallback) IdentityProviderError() error {
 	if in.requestParams.Get(openid.Error) != "" {
 		oauthError := in.requestParams.Get(openid.Error)
@@ -68,15 +84,8 @@ func (in *LoginCallback) StateMismatchError() error {
 }
 
 func (in *LoginCallback) RedeemTokens(ctx context.Context) (*openid.Tokens, error) {
-	clientAssertion, err := in.MakeAssertion(DefaultClientAssertionLifetime)
-	if err != nil {
-		return nil, fmt.Errorf("creating client assertion: %w", err)
-	}
-
 	opts := []oauth2.AuthCodeOption{
 		oauth2.SetAuthURLParam(openid.CodeVerifier, in.cookie.CodeVerifier),
-		oauth2.SetAuthURLParam(openid.ClientAssertion, clientAssertion),
-		oauth2.SetAuthURLParam(openid.ClientAssertionType, openid.ClientAssertionTypeJwtBearer),
 		oauth2.SetAuthURLParam(openid.RedirectURI, in.cookie.RedirectURI),
 	}
 
@@ -91,17 +100,104 @@ func (in *LoginCallback) RedeemTokens(ctx context.Context) (*openid.Tokens, erro
 		return nil, fmt.Errorf("getting jwks: %w", err)
 	}
 
-	tokens, err := openid.NewTokens(rawTokens, *jwkSet)
+	tokens, err := openid.NewTokens(rawTokens, *jwkSet, in.cfg)
 	if err != nil {
 		// JWKS might not be up-to-date, so we'll want to force a refresh for the next attempt
 		_, _ = in.jwksProvider.RefreshPublicJwkSet(ctx)
 		return nil, fmt.Errorf("parsing tokens: %w", err)
 	}
 
-	err = tokens.IDToken.Validate(in.cfg, in.cookie.Nonce)
+	err = tokens.IDToken.Validate(in.cfg, in.cookie, tokens.AccessToken)
 	if err != nil {
 		return nil, fmt.Errorf("validating id_token: %w", err)
 	}
 
 	return tokens, nil
 }
+
+// authorizationResponseParams returns the parameters from the authorization response, according to the response mode
+// used in the corresponding authorization request.
+func authorizationResponseParams(c *Client, r *http.Request, cookie *openid.LoginCookie) (url.Values, error) {
+	responseMode := config.ResponseMode(cookie.ResponseMode)
+	// response_mode not set in cookie (e.g. login initiated at instance running older version, callback handled at newer version)
+	if len(responseMode) == 0 {
+		responseMode = config.ResponseModeQuery
+	}
+
+	var params url.Values
+	if responseMode.IsFormPost() {
+		if r.Method != http.MethodPost {
+			return nil, fmt.Errorf("expected %s request for response_mode '%s', got %s", http.MethodPost, responseMode, r.Method)
+		}
+
+		if err := r.ParseForm(); err != nil {
+			return nil, fmt.Errorf("parsing form: %w", err)
+		}
+
+		params = r.PostForm
+	} else {
+		if r.Method != http.MethodGet {
+			return nil, fmt.Errorf("expected %s request for response_mode '%s', got %s", http.MethodGet, responseMode, r.Method)
+		}
+
+		params = r.URL.Query()
+	}
+
+	if !responseMode.IsJWT() {
+		return params, nil
+	}
+
+	response := params.Get(openid.Response)
+	if len(response) == 0 {
+		return nil, fmt.Errorf("missing '%s' parameter for response_mode '%s'", openid.Response, responseMode)
+	}
+
+	return c.parseJwtResponse(r.Context(), response)
+}
+
+// parseJwtResponse verifies and validates a JWT secured authorization response, and returns the contained parameters.
+// See JWT Secured Authorization Response Mode for OAuth 2.0 (JARM), section 2.4.
+func (c *Client) parseJwtResponse(ctx context.Context, raw string) (url.Values, error) {
+	if jwt.IsEncrypted(raw) {
+		decrypted, err := jwt.Decrypt(raw, c.cfg.Client().IDTokenDecryptionKeys())
+		if err != nil {
+			return nil, err
+		}
+
+		raw = decrypted
+	}
+
+	alg, err := jwt.Algorithm(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := c.cfg.Provider().AuthorizationSigningAlgs()
+	if !allowed.Contains(alg.String()) {
+		return nil, fmt.Errorf("unexpected signing algorithm: expected one of %q, got %q", allowed, alg)
+	}
+
+	token, err := c.parseJwt(ctx, raw, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = jwtlib.Validate(token,
+		jwtlib.WithAcceptableSkew(jwt.AcceptableClockSkew),
+		jwtlib.WithIssuer(c.cfg.Provider().Issuer()),
+		jwtlib.WithAudience(c.cfg.Client().ClientID()),
+		jwtlib.WithRequiredClaim(jwtlib.ExpirationKey),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("validating response: %w", err)
+	}
+
+	params := url.Values{}
+	for claim, value := range token.PrivateClaims() {
+		if str, ok := value.(string); ok {
+			params.Set(claim, str)
+		}
+	}
+
+	return params, nil
+}
@@ -5,9 +5,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/nais/wonderwall/pkg/crypto"
 	urlpkg "github.com/nais/wonderwall/pkg/handler/url"
+	"github.com/nais/wonderwall/pkg/jwt"
 	"github.com/nais/wonderwall/pkg/mock"
 	"github.com/nais/wonderwall/pkg/openid"
 	"github.com/nais/wonderwall/pkg/openid/client"
@@ -99,18 +102,146 @@ func TestLoginCallback_RedeemTokens(t *testing.T) {
 		assert.Nil(t, tokens)
 	})
 
+	t.Run("max_age within auth_time", func(t *testing.T) {
+		idp, lc := newLoginCallbackWithMaxAge(t, url, "60")
+		defer idp.Close()
+		idp.ProviderHandler.Codes["some-code"].AuthTime = time.Now().Add(-30 * time.Second)
+
+		tokens, err := lc.RedeemTokens(context.Background())
+		assert.NoError(t, err)
+		assert.NotNil(t, tokens)
+	})
+
+	t.Run("max_age exceeded by auth_time", func(t *testing.T) {
+		idp, lc := newLoginCallbackWithMaxAge(t, url, "60")
+		defer idp.Close()
+		idp.ProviderHandler.Codes["some-code"].AuthTime = time.Now().Add(-5 * time.Minute)
+
+		tokens, err := lc.RedeemTokens(context.Background())
+		assert.Error(t, err)
+		assert.Nil(t, tokens)
+	})
+
+	for _, test := range []struct {
+		name   string
+		claims map[string]interface{}
+		err    error
+	}{
+		{
+			name:   "multiple audiences without azp",
+			claims: map[string]interface{}{"aud": []string{"client-id", "other-client"}},
+			err:    openid.ErrIDTokenAzp,
+		},
+		{
+			name:   "multiple audiences with azp",
+			claims: map[string]interface{}{"aud": []string{"client-id", "other-client"}, "azp": "client-id"},
+		},
+		{
+			name:   "unexpected azp",
+			claims: map[string]interface{}{"azp": "other-client"},
+			err:    openid.ErrIDTokenAzp,
+		},
+		{
+			name:   "invalid at_hash",
+			claims: map[string]interface{}{"at_hash": "invalid"},
+			err:    openid.ErrIDTokenAtHash,
+		},
+		{
+			name:   "iat too old",
+			claims: map[string]interface{}{"iat": time.Now().Add(-time.Hour).Unix()},
+			err:    openid.ErrIDTokenIat,
+		},
+		{
+			name:   "iat in the future",
+			claims: map[string]interface{}{"iat": time.Now().Add(time.Hour).Unix()},
+			err:    openid.ErrIDTokenIat,
+		},
+		{
+			name:   "auth_time in the future",
+			claims: map[string]interface{}{"auth_time": time.Now().Add(time.Hour).Unix()},
+			err:    openid.ErrIDTokenAuthTime,
+		},
+		{
+			name:   "unexpected issuer",
+			claims: map[string]interface{}{"iss": "https://not-the-issuer"},
+			err:    openid.ErrIDTokenIssuer,
+		},
+		{
+			name:   "expired",
+			claims: map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()},
+			err:    openid.ErrIDTokenClaims,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			idp, lc := newLoginCallback(t, url)
+			defer idp.Close()
+			idp.ProviderHandler.IDTokenClaims = test.claims
+
+			tokens, err := lc.RedeemTokens(context.Background())
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				assert.Nil(t, tokens)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, tokens)
+			}
+		})
+	}
+
+	t.Run("signing algorithm not allowed", func(t *testing.T) {
+		idp, lc := newLoginCallback(t, url)
+		defer idp.Close()
+		idp.OpenIDConfig.TestProvider.SetIDTokenSigningAlgValuesSupported("ES256")
+
+		tokens, err := lc.RedeemTokens(context.Background())
+		assert.ErrorIs(t, err, openid.ErrIDTokenAlgorithm)
+		assert.Nil(t, tokens)
+	})
+
+	t.Run("encrypted id_token", func(t *testing.T) {
+		idp, lc := newLoginCallback(t, url)
+		defer idp.Close()
+		idp.ProviderHandler.EncryptIDTokens = true
+
+		key, err := crypto.NewJwk()
+		assert.NoError(t, err)
+		keys := jwk.NewSet()
+		assert.NoError(t, keys.AddKey(key))
+		idp.OpenIDConfig.TestClient.SetIDTokenDecryptionKeys(keys)
+
+		tokens, err := lc.RedeemTokens(context.Background())
+		assert.NoError(t, err)
+		assert.NotNil(t, tokens)
+		assert.False(t, jwt.IsEncrypted(tokens.IDToken.GetSerialized()))
+	})
+
+	t.Run("encrypted id_token with client jwk", func(t *testing.T) {
+		idp, lc := newLoginCallback(t, url)
+		defer idp.Close()
+		idp.ProviderHandler.EncryptIDTokens = true
+
+		tokens, err := lc.RedeemTokens(context.Background())
+		assert.NoError(t, err)
+		assert.NotNil(t, tokens)
+		assert.False(t, jwt.IsEncrypted(tokens.IDToken.GetSerialized()))
+	})
+
 	t.Run("unexpected audience", func(t *testing.T) {
 		idp, lc := newLoginCallback(t, url)
 		defer idp.Close()
 		idp.Cfg.OpenID.ClientID = "new-client-id"
 
 		tokens, err := lc.RedeemTokens(context.Background())
-		assert.Error(t, err)
+		assert.ErrorIs(t, err, openid.ErrIDTokenAudience)
 		assert.Nil(t, tokens)
 	})
 }
 
 func newLoginCallback(t *testing.T, url string) (*mock.IdentityProvider, *client.LoginCallback) {
+	return newLoginCallbackWithMaxAge(t, url, "")
+}
+
+func newLoginCallbackWithMaxAge(t *testing.T, url, maxAge string) (*mock.IdentityProvider, *client.LoginCallback) {
 	idp := mock.NewIdentityProvider(mock.Config())
 	idp.SetIngresses(mock.Ingress)
 	req := idp.GetRequest(url)
@@ -131,6 +262,7 @@ func newLoginCallback(t *testing.T, url string) (*mock.IdentityProvider, *client
 		State:        "some-state",
 		Nonce:        "some-nonce",
 		CodeVerifier: "some-verifier",
+		MaxAge:       maxAge,
 		RedirectURI:  redirect,
 	}
 
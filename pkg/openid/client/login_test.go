@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -58,6 +59,59 @@ func TestLogin_URL(t *testing.T) {
 			url:   mock.Ingress + "/oauth2/login?locale=es",
 			error: client.ErrInvalidLocale,
 		},
+		{
+			url: mock.Ingress + "/oauth2/login?prompt=select_account",
+			extraParams: map[string]string{
+				"prompt": "select_account",
+			},
+			error: nil,
+		},
+		{
+			url: mock.Ingress + "/oauth2/login?prompt=login+consent",
+			extraParams: map[string]string{
+				"prompt": "login consent",
+			},
+			error: nil,
+		},
+		{
+			url:   mock.Ingress + "/oauth2/login?prompt=none+login",
+			error: client.ErrInvalidPrompt,
+		},
+		{
+			url:   mock.Ingress + "/oauth2/login?prompt=invalid",
+			error: client.ErrInvalidPrompt,
+		},
+		{
+			url: mock.Ingress + "/oauth2/login?login_hint=user@example.com&domain_hint=example.com",
+			extraParams: map[string]string{
+				"login_hint":  "user@example.com",
+				"domain_hint": "example.com",
+			},
+			error: nil,
+		},
+		{
+			url:   mock.Ingress + "/oauth2/login?login_hint=" + strings.Repeat("a", 257),
+			error: client.ErrInvalidLoginHint,
+		},
+		{
+			url: mock.Ingress + "/oauth2/login?max_age=0",
+			extraParams: map[string]string{
+				"max_age": "0",
+			},
+			error: nil,
+		},
+		{
+			url:   mock.Ingress + "/oauth2/login?max_age=-1",
+			error: client.ErrInvalidMaxAge,
+		},
+		{
+			url:   mock.Ingress + "/oauth2/login?max_age=soon",
+			error: client.ErrInvalidMaxAge,
+		},
+		{
+			url:   mock.Ingress + "/oauth2/login?unknown=value",
+			error: nil,
+		},
 	}
 
 	for _, test := range tests {
@@ -91,6 +145,7 @@ func TestLogin_URL(t *testing.T) {
 				assert.Contains(t, query, "code_challenge")
 				assert.Contains(t, query, "code_challenge_method")
 				assert.NotContains(t, query, "resource")
+				assert.NotContains(t, query, "unknown")
 
 				callbackURL, err := urlpkg.LoginCallbackURL(req)
 				assert.NoError(t, err)
@@ -105,6 +160,9 @@ func TestLogin_URL(t *testing.T) {
 				assert.ElementsMatch(t, query["code_challenge"], []string{result.CodeChallenge()})
 				assert.ElementsMatch(t, query["code_challenge_method"], []string{"S256"})
 
+				assert.Equal(t, query.Get("max_age"), result.MaxAge())
+				assert.Equal(t, query.Get("max_age"), result.Cookie().MaxAge)
+
 				if test.extraParams != nil {
 					for key, value := range test.extraParams {
 						assert.Contains(t, query, key)
@@ -142,6 +200,86 @@ func TestLoginURL_WithResourceIndicator(t *testing.T) {
 	assert.ElementsMatch(t, query["resource"], []string{"https://some-resource"})
 }
 
+func TestLoginURL_WithDefaults(t *testing.T) {
+	cfg := mock.Config()
+	cfg.OpenID.Prompt = "login"
+	cfg.OpenID.LoginHint = "user@example.com"
+	cfg.OpenID.DomainHint = "example.com"
+	cfg.OpenID.MaxAge = "300"
+
+	openidConfig := mock.NewTestConfiguration(cfg)
+	lsc := loginstatus.NewClient(cfg.Loginstatus, http.DefaultClient)
+	c := client.NewClient(openidConfig, lsc, nil)
+	ingresses := mock.Ingresses(cfg)
+
+	t.Run("defaults are used if not set in request", func(t *testing.T) {
+		req := mock.NewGetRequest(mock.Ingress+"/oauth2/login", ingresses)
+		result, err := c.Login(req)
+		assert.NoError(t, err)
+
+		parsed, err := url.Parse(result.AuthCodeURL())
+		assert.NoError(t, err)
+
+		query := parsed.Query()
+		assert.Equal(t, "login", query.Get("prompt"))
+		assert.Equal(t, "user@example.com", query.Get("login_hint"))
+		assert.Equal(t, "example.com", query.Get("domain_hint"))
+		assert.Equal(t, "300", query.Get("max_age"))
+		assert.Equal(t, "300", result.Cookie().MaxAge)
+	})
+
+	t.Run("request parameters take precedence", func(t *testing.T) {
+		req := mock.NewGetRequest(mock.Ingress+"/oauth2/login?prompt=select_account&max_age=0", ingresses)
+		result, err := c.Login(req)
+		assert.NoError(t, err)
+
+		parsed, err := url.Parse(result.AuthCodeURL())
+		assert.NoError(t, err)
+
+		query := parsed.Query()
+		assert.Equal(t, "select_account", query.Get("prompt"))
+		assert.Equal(t, "0", query.Get("max_age"))
+		assert.Equal(t, "0", result.Cookie().MaxAge)
+	})
+
+	t.Run("prompt values advertised by provider are enforced", func(t *testing.T) {
+		openidConfig.TestProvider.SetPromptValuesSupported("none", "login")
+		defer openidConfig.TestProvider.SetPromptValuesSupported()
+
+		req := mock.NewGetRequest(mock.Ingress+"/oauth2/login?prompt=select_account", ingresses)
+		_, err := c.Login(req)
+		assert.ErrorIs(t, err, client.ErrInvalidPrompt)
+	})
+
+	t.Run("popup", func(t *testing.T) {
+		req := mock.NewGetRequest(mock.Ingress+"/oauth2/login?popup=true", ingresses)
+		result, err := c.Login(req)
+		assert.NoError(t, err)
+		assert.True(t, result.Cookie().Popup)
+
+		req = mock.NewGetRequest(mock.Ingress+"/oauth2/login", ingresses)
+		result, err = c.Login(req)
+		assert.NoError(t, err)
+		assert.False(t, result.Cookie().Popup)
+
+		req = mock.NewGetRequest(mock.Ingress+"/oauth2/login?popup=maybe", ingresses)
+		_, err = c.Login(req)
+		assert.ErrorIs(t, err, client.ErrInvalidPopup)
+	})
+
+	t.Run("locale is stored in cookie for error pages", func(t *testing.T) {
+		req := mock.NewGetRequest(mock.Ingress+"/oauth2/login?locale=en", ingresses)
+		result, err := c.Login(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "en", result.Cookie().Locale)
+
+		req = mock.NewGetRequest(mock.Ingress+"/oauth2/login", ingresses)
+		result, err = c.Login(req)
+		assert.NoError(t, err)
+		assert.Empty(t, result.Cookie().Locale)
+	})
+}
+
 func TestLoginURLParameter(t *testing.T) {
 	for _, test := range []struct {
 		name      string
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// UserInfo fetches the claims about the end-user from the identity provider's userinfo endpoint. The 'sub' claim must
+// match the given subject, i.e. the 'sub' claim from the id_token.
+func (c *Client) UserInfo(ctx context.Context, accessToken, subject string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.Provider().UserInfoEndpoint(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("performing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%w: userinfo: status %d: %s", ErrOpenIDClient, resp.StatusCode, body)
+	} else if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("%w: userinfo: status %d", ErrOpenIDServer, resp.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return nil, fmt.Errorf("userinfo: unsupported content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	var claims map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("userinfo: decoding response: %w", err)
+	}
+
+	if sub, _ := claims["sub"].(string); sub != subject {
+		return nil, fmt.Errorf("userinfo: 'sub' claim %q does not match id_token 'sub' claim %q", sub, subject)
+	}
+
+	return claims, nil
+}
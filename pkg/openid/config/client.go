@@ -1,7 +1,9 @@
 package config
 
 import (
+	"crypto/tls"
 	"fmt"
+	"time"
 
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	log "github.com/sirupsen/logrus"
@@ -12,9 +14,18 @@ import (
 
 type Client interface {
 	ACRValues() string
+	ClientAuthMethod() wonderwallconfig.ClientAuthMethod
+	ClientCertificate() *tls.Certificate
 	ClientID() string
 	ClientJWK() jwk.Key
+	DomainHint() string
+	IDTokenDecryptionKeys() jwk.Set
+	IDTokenIatMaxAge() time.Duration
+	LoginHint() string
+	MaxAge() string
 	PostLogoutRedirectURI() string
+	Prompt() string
+	ResponseMode() wonderwallconfig.ResponseMode
 	Scopes() scopes.Scopes
 	UILocales() string
 	WellKnownURL() string
@@ -24,25 +35,89 @@ type Client interface {
 
 type client struct {
 	wonderwallconfig.OpenID
-	clientJwk jwk.Key
+	clientCertificate     *tls.Certificate
+	clientJwks            *clientJwks
+	idTokenDecryptionKeys jwk.Set
 }
 
 func (in *client) ACRValues() string {
 	return in.OpenID.ACRValues
 }
 
+func (in *client) ClientAuthMethod() wonderwallconfig.ClientAuthMethod {
+	if len(in.OpenID.ClientAuthMethod) == 0 {
+		return wonderwallconfig.ClientAuthMethodPrivateKeyJWT
+	}
+
+	return in.OpenID.ClientAuthMethod
+}
+
+// ClientCertificate returns the certificate used for mutual TLS client authentication, if any.
+func (in *client) ClientCertificate() *tls.Certificate {
+	return in.clientCertificate
+}
+
 func (in *client) ClientID() string {
 	return in.OpenID.ClientID
 }
 
+// ClientJWK returns the current private key used for signing, if any.
 func (in *client) ClientJWK() jwk.Key {
-	return in.clientJwk
+	if in.clientJwks == nil {
+		return nil
+	}
+
+	return in.clientJwks.Key()
+}
+
+func (in *client) DomainHint() string {
+	return in.OpenID.DomainHint
+}
+
+// IDTokenDecryptionKeys returns the private keys used for decrypting encrypted id_tokens. If no separate decryption keys
+// are configured, the client JWK is used if its intended use is encryption or unspecified. The set is otherwise empty,
+// in which case encrypted id_tokens are rejected.
+func (in *client) IDTokenDecryptionKeys() jwk.Set {
+	if in.idTokenDecryptionKeys != nil {
+		return in.idTokenDecryptionKeys
+	}
+
+	keys := jwk.NewSet()
+	if key := in.ClientJWK(); key != nil && isPrivateEncryptionKey(key) {
+		_ = keys.AddKey(key)
+	}
+
+	return keys
+}
+
+func (in *client) IDTokenIatMaxAge() time.Duration {
+	return in.OpenID.IDTokenIatMaxAge
+}
+
+func (in *client) LoginHint() string {
+	return in.OpenID.LoginHint
+}
+
+func (in *client) MaxAge() string {
+	return in.OpenID.MaxAge
 }
 
 func (in *client) PostLogoutRedirectURI() string {
 	return in.OpenID.PostLogoutRedirectURI
 }
 
+func (in *client) Prompt() string {
+	return in.OpenID.Prompt
+}
+
+func (in *client) ResponseMode() wonderwallconfig.ResponseMode {
+	if len(in.OpenID.ResponseMode) == 0 {
+		return wonderwallconfig.ResponseModeQuery
+	}
+
+	return in.OpenID.ResponseMode
+}
+
 func (in *client) Scopes() scopes.Scopes {
 	return scopes.DefaultScopes().WithAdditional(in.OpenID.Scopes...)
 }
@@ -60,26 +135,48 @@ func (in *client) Print() {
 
 	logger.Info("🤔 openid client configuration 🤔")
 	logger.Infof("acr values: '%s'", in.ACRValues())
+	logger.Infof("client auth method: '%s'", in.ClientAuthMethod())
 	logger.Infof("client id: '%s'", in.ClientID())
+	logger.Infof("domain hint: '%s'", in.DomainHint())
+	logger.Infof("id_token decryption keys: %d", in.IDTokenDecryptionKeys().Len())
+	logger.Infof("id_token iat max age: '%s'", in.IDTokenIatMaxAge())
+	logger.Infof("login hint: '%s'", in.LoginHint())
+	logger.Infof("max age: '%s'", in.MaxAge())
 	logger.Infof("post-logout redirect uri: '%s'", in.PostLogoutRedirectURI())
+	logger.Infof("prompt: '%s'", in.Prompt())
+	logger.Infof("response mode: '%s'", in.ResponseMode())
 	logger.Infof("scopes: '%s'", in.Scopes())
 	logger.Infof("ui locales: '%s'", in.UILocales())
 }
 
 func NewClientConfig(cfg *wonderwallconfig.Config) (Client, error) {
-	clientJwkString := cfg.OpenID.ClientJWK
-	if len(clientJwkString) == 0 {
-		return nil, fmt.Errorf("missing required config %s", wonderwallconfig.OpenIDClientJWK)
+	c := &client{
+		OpenID: cfg.OpenID,
 	}
 
-	clientJwk, err := jwk.ParseKey([]byte(clientJwkString))
-	if err != nil {
-		return nil, fmt.Errorf("parsing client JWK: %w", err)
+	if c.ClientAuthMethod().IsMutualTLS() {
+		certificate, err := tls.LoadX509KeyPair(cfg.OpenID.ClientTLSCertFile, cfg.OpenID.ClientTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+
+		c.clientCertificate = &certificate
+	} else {
+		clientJwks, err := newClientJwks(cfg.OpenID)
+		if err != nil {
+			return nil, err
+		}
+
+		c.clientJwks = clientJwks
 	}
 
-	c := &client{
-		OpenID:    cfg.OpenID,
-		clientJwk: clientJwk,
+	if len(cfg.OpenID.IDTokenDecryptionJWK) > 0 {
+		keys, err := parseDecryptionKeys([]byte(cfg.OpenID.IDTokenDecryptionJWK))
+		if err != nil {
+			return nil, err
+		}
+
+		c.idTokenDecryptionKeys = keys
 	}
 
 	var clientConfig Client
@@ -106,6 +203,23 @@ func NewClientConfig(cfg *wonderwallconfig.Config) (Client, error) {
 	return clientConfig, nil
 }
 
+func newClientJwks(cfg wonderwallconfig.OpenID) (*clientJwks, error) {
+	switch {
+	case len(cfg.ClientJWK) > 0 && len(cfg.ClientJWKFile) > 0:
+		return nil, fmt.Errorf("only one of %s or %s can be set", wonderwallconfig.OpenIDClientJWK, wonderwallconfig.OpenIDClientJWKFile)
+	case len(cfg.ClientJWKFile) > 0:
+		clientJwks, err := newClientJwksFromFile(cfg.ClientJWKFile, cfg.ClientJWKKeyID)
+		if err != nil {
+			return nil, fmt.Errorf("loading client JWK from file: %w", err)
+		}
+		return clientJwks, nil
+	case len(cfg.ClientJWK) > 0:
+		return newClientJwksFromString(cfg.ClientJWK, cfg.ClientJWKKeyID)
+	default:
+		return nil, fmt.Errorf("missing required config %s or %s", wonderwallconfig.OpenIDClientJWK, wonderwallconfig.OpenIDClientJWKFile)
+	}
+}
+
 type azure struct {
 	*client
 }
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	log "github.com/sirupsen/logrus"
+)
+
+// clientJwks holds the private key used by the client for signing, e.g. client assertions.
+// Keys loaded from a file are reloaded whenever the file is changed.
+type clientJwks struct {
+	keyID string
+	path  string
+
+	lock    sync.RWMutex
+	key     jwk.Key
+	modTime time.Time
+	size    int64
+}
+
+func newClientJwksFromString(raw, keyID string) (*clientJwks, error) {
+	key, err := parseSigningKey([]byte(raw), keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &clientJwks{
+		key:   key,
+		keyID: keyID,
+	}, nil
+}
+
+func newClientJwksFromFile(path, keyID string) (*clientJwks, error) {
+	c := &clientJwks{
+		keyID: keyID,
+		path:  path,
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading file info: %w", err)
+	}
+
+	if err := c.load(info); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Key returns the current signing key. If the keys are loaded from a file that has changed since the last load, the
+// file is reloaded first. The previous key is kept if the reload fails.
+func (c *clientJwks) Key() jwk.Key {
+	if len(c.path) > 0 {
+		c.reloadIfChanged()
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.key
+}
+
+func (c *clientJwks) reloadIfChanged() {
+	logger := log.WithField("logger", "openid.config.client")
+
+	info, err := os.Stat(c.path)
+	if err != nil {
+		logger.Warnf("client jwk: reading file info: %+v", err)
+		return
+	}
+
+	c.lock.RLock()
+	changed := c.changed(info)
+	c.lock.RUnlock()
+	if !changed {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// another caller may have reloaded the file while we were waiting for the lock
+	if !c.changed(info) {
+		return
+	}
+
+	previousKeyID := c.key.KeyID()
+	if err := c.loadLocked(info); err != nil {
+		logger.Warnf("client jwk: reloading from file; keeping previous key: %+v", err)
+		return
+	}
+
+	logger.Infof("client jwk: reloaded from file; using key with kid '%s' (previously '%s')", c.key.KeyID(), previousKeyID)
+}
+
+func (c *clientJwks) changed(info os.FileInfo) bool {
+	return !info.ModTime().Equal(c.modTime) || info.Size() != c.size
+}
+
+func (c *clientJwks) load(info os.FileInfo) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.loadLocked(info)
+}
+
+func (c *clientJwks) loadLocked(info os.FileInfo) error {
+	// mark the file as seen regardless of the outcome to avoid repeatedly attempting to parse an invalid file
+	c.modTime = info.ModTime()
+	c.size = info.Size()
+
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("reading file: %w", err)
+	}
+
+	key, err := parseSigningKey(data, c.keyID)
+	if err != nil {
+		return err
+	}
+
+	c.key = key
+	return nil
+}
+
+// parseSigningKey parses the given JWK or JWK set and returns the private signing key to use. If a key ID is given,
+// the matching key is returned. Otherwise, the last signing key in the set is returned; i.e. new keys should be
+// appended to the set.
+func parseSigningKey(data []byte, keyID string) (jwk.Key, error) {
+	set, err := jwk.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing client JWK: %w", err)
+	}
+
+	var key jwk.Key
+	for i := 0; i < set.Len(); i++ {
+		candidate, ok := set.Key(i)
+		if !ok || !isPrivateSigningKey(candidate) {
+			continue
+		}
+
+		if len(keyID) > 0 && candidate.KeyID() != keyID {
+			continue
+		}
+
+		key = candidate
+	}
+
+	if key == nil {
+		if len(keyID) > 0 {
+			return nil, fmt.Errorf("no private signing key with kid '%s' found in client JWK", keyID)
+		}
+		return nil, fmt.Errorf("no private signing key found in client JWK")
+	}
+
+	return key, nil
+}
+
+func isPrivateSigningKey(key jwk.Key) bool {
+	if usage := key.KeyUsage(); len(usage) > 0 && usage != string(jwk.ForSignature) {
+		return false
+	}
+
+	switch key.(type) {
+	case jwk.RSAPrivateKey, jwk.ECDSAPrivateKey, jwk.OKPPrivateKey:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseDecryptionKeys parses the given JWK or JWK set and returns the private keys that may be used for decryption.
+func parseDecryptionKeys(data []byte) (jwk.Set, error) {
+	set, err := jwk.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing decryption JWK: %w", err)
+	}
+
+	keys := jwk.NewSet()
+	for i := 0; i < set.Len(); i++ {
+		key, ok := set.Key(i)
+		if !ok || !isPrivateEncryptionKey(key) {
+			continue
+		}
+
+		if err := keys.AddKey(key); err != nil {
+			return nil, fmt.Errorf("adding decryption key: %w", err)
+		}
+	}
+
+	if keys.Len() == 0 {
+		return nil, fmt.Errorf("no private encryption keys found in decryption JWK")
+	}
+
+	return keys, nil
+}
+
+func isPrivateEncryptionKey(key jwk.Key) bool {
+	if usage := key.KeyUsage(); len(usage) > 0 && usage != string(jwk.ForEncryption) {
+		return false
+	}
+
+	switch key.(type) {
+	case jwk.RSAPrivateKey, jwk.ECDSAPrivateKey:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,221 @@
+package config_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nais/wonderwall/pkg/config"
+	"github.com/nais/wonderwall/pkg/crypto"
+	openidconfig "github.com/nais/wonderwall/pkg/openid/config"
+)
+
+func TestNewClientConfig_ClientJWK(t *testing.T) {
+	key := newJwk(t)
+
+	t.Run("inline jwk", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.OpenID.ClientJWK = string(marshal(t, key))
+
+		clientCfg, err := openidconfig.NewClientConfig(cfg)
+		assert.NoError(t, err)
+		assert.Equal(t, key.KeyID(), clientCfg.ClientJWK().KeyID())
+	})
+
+	t.Run("inline jwk set", func(t *testing.T) {
+		other := newJwk(t)
+
+		cfg := newConfig()
+		cfg.OpenID.ClientJWK = string(marshal(t, newJwkSet(t, key, other)))
+
+		clientCfg, err := openidconfig.NewClientConfig(cfg)
+		assert.NoError(t, err)
+		assert.Equal(t, other.KeyID(), clientCfg.ClientJWK().KeyID())
+	})
+
+	t.Run("inline jwk set with key id", func(t *testing.T) {
+		other := newJwk(t)
+
+		cfg := newConfig()
+		cfg.OpenID.ClientJWK = string(marshal(t, newJwkSet(t, key, other)))
+		cfg.OpenID.ClientJWKKeyID = key.KeyID()
+
+		clientCfg, err := openidconfig.NewClientConfig(cfg)
+		assert.NoError(t, err)
+		assert.Equal(t, key.KeyID(), clientCfg.ClientJWK().KeyID())
+	})
+
+	t.Run("unknown key id", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.OpenID.ClientJWK = string(marshal(t, key))
+		cfg.OpenID.ClientJWKKeyID = "unknown"
+
+		_, err := openidconfig.NewClientConfig(cfg)
+		assert.Error(t, err)
+	})
+
+	t.Run("public key only", func(t *testing.T) {
+		publicKey, err := key.PublicKey()
+		assert.NoError(t, err)
+
+		cfg := newConfig()
+		cfg.OpenID.ClientJWK = string(marshal(t, publicKey))
+
+		_, err = openidconfig.NewClientConfig(cfg)
+		assert.Error(t, err)
+	})
+
+	t.Run("both inline and file", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.OpenID.ClientJWK = string(marshal(t, key))
+		cfg.OpenID.ClientJWKFile = writeFile(t, filepath.Join(t.TempDir(), "jwk.json"), marshal(t, key))
+
+		_, err := openidconfig.NewClientConfig(cfg)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := openidconfig.NewClientConfig(newConfig())
+		assert.Error(t, err)
+	})
+}
+
+func TestNewClientConfig_ClientJWKFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwk.json")
+	first := newJwk(t)
+	writeFile(t, path, marshal(t, first))
+
+	cfg := newConfig()
+	cfg.OpenID.ClientJWKFile = path
+
+	clientCfg, err := openidconfig.NewClientConfig(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, first.KeyID(), clientCfg.ClientJWK().KeyID())
+
+	// rotate by appending a new key to the set
+	second := newJwk(t)
+	writeFile(t, path, marshal(t, newJwkSet(t, first, second)))
+	assert.Equal(t, second.KeyID(), clientCfg.ClientJWK().KeyID())
+
+	// invalid content should keep the previous key
+	writeFile(t, path, []byte("not a jwk"))
+	assert.Equal(t, second.KeyID(), clientCfg.ClientJWK().KeyID())
+
+	// remove the old key
+	writeFile(t, path, marshal(t, newJwkSet(t, second)))
+	assert.Equal(t, second.KeyID(), clientCfg.ClientJWK().KeyID())
+}
+
+func TestNewClientConfig_IDTokenDecryptionKeys(t *testing.T) {
+	clientKey := newJwk(t)
+
+	t.Run("falls back to client jwk without decryption jwk", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.OpenID.ClientJWK = string(marshal(t, clientKey))
+
+		clientCfg, err := openidconfig.NewClientConfig(cfg)
+		assert.NoError(t, err)
+
+		keys := clientCfg.IDTokenDecryptionKeys()
+		assert.Equal(t, 1, keys.Len())
+		_, ok := keys.LookupKeyID(clientKey.KeyID())
+		assert.True(t, ok)
+	})
+
+	t.Run("empty if client jwk is a signing key", func(t *testing.T) {
+		signingKey := newJwk(t)
+		assert.NoError(t, signingKey.Set(jwk.KeyUsageKey, jwk.ForSignature))
+
+		cfg := newConfig()
+		cfg.OpenID.ClientJWK = string(marshal(t, signingKey))
+
+		clientCfg, err := openidconfig.NewClientConfig(cfg)
+		assert.NoError(t, err)
+
+		keys := clientCfg.IDTokenDecryptionKeys()
+		assert.Equal(t, 0, keys.Len())
+	})
+
+	t.Run("separate key set", func(t *testing.T) {
+		encryptionKey := newJwk(t)
+		assert.NoError(t, encryptionKey.Set(jwk.KeyUsageKey, jwk.ForEncryption))
+		signingKey := newJwk(t)
+		assert.NoError(t, signingKey.Set(jwk.KeyUsageKey, jwk.ForSignature))
+
+		cfg := newConfig()
+		cfg.OpenID.ClientJWK = string(marshal(t, clientKey))
+		cfg.OpenID.IDTokenDecryptionJWK = string(marshal(t, newJwkSet(t, encryptionKey, signingKey)))
+
+		clientCfg, err := openidconfig.NewClientConfig(cfg)
+		assert.NoError(t, err)
+
+		keys := clientCfg.IDTokenDecryptionKeys()
+		assert.Equal(t, 1, keys.Len())
+		_, ok := keys.LookupKeyID(encryptionKey.KeyID())
+		assert.True(t, ok)
+
+		// the client jwk is not used when a separate set is configured
+		_, ok = keys.LookupKeyID(clientKey.KeyID())
+		assert.False(t, ok)
+	})
+
+	t.Run("public key only", func(t *testing.T) {
+		publicKey, err := newJwk(t).PublicKey()
+		assert.NoError(t, err)
+
+		cfg := newConfig()
+		cfg.OpenID.ClientJWK = string(marshal(t, clientKey))
+		cfg.OpenID.IDTokenDecryptionJWK = string(marshal(t, publicKey))
+
+		_, err = openidconfig.NewClientConfig(cfg)
+		assert.Error(t, err)
+	})
+}
+
+func newConfig() *config.Config {
+	return &config.Config{
+		OpenID: config.OpenID{
+			ClientID:     "client-id",
+			Provider:     config.ProviderOpenID,
+			WellKnownURL: "https://some-provider/.well-known/openid-configuration",
+		},
+	}
+}
+
+func newJwk(t *testing.T) jwk.Key {
+	key, err := crypto.NewJwk()
+	assert.NoError(t, err)
+	return key
+}
+
+func newJwkSet(t *testing.T, keys ...jwk.Key) jwk.Set {
+	set := jwk.NewSet()
+	for _, key := range keys {
+		assert.NoError(t, set.AddKey(key))
+	}
+	return set
+}
+
+func marshal(t *testing.T, v any) []byte {
+	data, err := json.Marshal(v)
+	assert.NoError(t, err)
+	return data
+}
+
+// writeFile writes the given data to the given path, and bumps the modification time to ensure that changes are
+// detected regardless of the file system's timestamp resolution.
+func writeFile(t *testing.T, path string, data []byte) string {
+	modTime := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	}
+
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	return path
+}
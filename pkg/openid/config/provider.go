@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
@@ -13,12 +14,17 @@ import (
 
 type Provider interface {
 	AuthorizationEndpoint() string
+	CheckSessionIframe() string
 	EndSessionEndpointURL() url.URL
 	Issuer() string
 	JwksURI() string
 	TokenEndpoint() string
+	UserInfoEndpoint() string
 
 	ACRValuesSupported() Supported
+	AuthorizationSigningAlgs() Supported
+	IDTokenSigningAlgs() Supported
+	PromptValuesSupported() Supported
 	UILocalesSupported() Supported
 
 	Name() string
@@ -28,6 +34,8 @@ type Provider interface {
 
 type provider struct {
 	endSessionEndpointURL *url.URL
+	clientAuthMethod      wonderwallconfig.ClientAuthMethod
+	idTokenSigningAlgs    Supported
 	metadata              *ProviderMetadata
 	name                  string
 }
@@ -36,12 +44,17 @@ func (p *provider) AuthorizationEndpoint() string {
 	return p.metadata.AuthorizationEndpoint
 }
 
+func (p *provider) CheckSessionIframe() string {
+	return p.metadata.CheckSessionIframe
+}
+
 func (p *provider) EndSessionEndpointURL() url.URL {
 	return *p.endSessionEndpointURL
 }
 
+// TokenEndpoint returns the token endpoint, or its mutual TLS alias if the client uses mutual TLS authentication.
 func (p *provider) TokenEndpoint() string {
-	return p.metadata.TokenEndpoint
+	return p.metadata.TokenEndpointFor(p.clientAuthMethod)
 }
 
 func (p *provider) Issuer() string {
@@ -52,10 +65,34 @@ func (p *provider) JwksURI() string {
 	return p.metadata.JwksURI
 }
 
+func (p *provider) UserInfoEndpoint() string {
+	return p.metadata.UserInfoEndpoint
+}
+
 func (p *provider) ACRValuesSupported() Supported {
 	return p.metadata.ACRValuesSupported
 }
 
+// AuthorizationSigningAlgs returns the signing algorithms that are accepted for JWT secured authorization responses.
+func (p *provider) AuthorizationSigningAlgs() Supported {
+	return p.metadata.AuthorizationSigningAlgs()
+}
+
+// IDTokenSigningAlgs returns the signing algorithms that are accepted for id_tokens.
+func (p *provider) IDTokenSigningAlgs() Supported {
+	return p.idTokenSigningAlgs
+}
+
+// PromptValuesSupported returns the prompt values supported by the provider. If the provider does not advertise any
+// values, the values defined in OpenID Connect Core 1.0 are assumed.
+func (p *provider) PromptValuesSupported() Supported {
+	if len(p.metadata.PromptValuesSupported) == 0 {
+		return DefaultPromptValuesSupported
+	}
+
+	return p.metadata.PromptValuesSupported
+}
+
 func (p *provider) UILocalesSupported() Supported {
 	return p.metadata.UILocalesSupported
 }
@@ -94,6 +131,57 @@ func NewProviderConfig(cfg *wonderwallconfig.Config) (Provider, error) {
 		return nil, fmt.Errorf("identity provider does not support '%s=%s'", wonderwallconfig.OpenIDUILocales, acrValues)
 	}
 
+	prompt := cfg.OpenID.Prompt
+	if len(prompt) > 0 {
+		supported := providerCfg.PromptValuesSupported
+		if len(supported) == 0 {
+			supported = DefaultPromptValuesSupported
+		}
+
+		if err := supported.ContainsAll(prompt); err != nil {
+			return nil, fmt.Errorf("identity provider does not support '%s=%s': %w", wonderwallconfig.OpenIDPrompt, prompt, err)
+		}
+	}
+
+	idTokenSigningAlgs, err := ResolveIDTokenSigningAlgs(cfg.OpenID.IDTokenSigningAlgs, providerCfg.IDTokenSigningAlgValuesSupported)
+	if err != nil {
+		return nil, fmt.Errorf("identity provider does not support '%s': %w", wonderwallconfig.OpenIDIDTokenSigningAlgs, err)
+	}
+
+	if cfg.Session.Check && len(providerCfg.CheckSessionIframe) == 0 {
+		return nil, fmt.Errorf("identity provider does not support '%s': missing 'check_session_iframe'", wonderwallconfig.SessionCheck)
+	}
+
+	if cfg.OpenID.UserInfo && len(providerCfg.UserInfoEndpoint) == 0 {
+		return nil, fmt.Errorf("identity provider does not support '%s': missing 'userinfo_endpoint'", wonderwallconfig.OpenIDUserInfo)
+	}
+
+	clientAuthMethod := cfg.OpenID.ClientAuthMethod
+	if len(clientAuthMethod) == 0 {
+		clientAuthMethod = wonderwallconfig.ClientAuthMethodPrivateKeyJWT
+	}
+
+	authMethods := providerCfg.TokenEndpointAuthMethodsSupported
+	if len(authMethods) > 0 && !authMethods.Contains(string(clientAuthMethod)) {
+		return nil, fmt.Errorf("identity provider does not support '%s=%s'", wonderwallconfig.OpenIDClientAuthMethod, clientAuthMethod)
+	}
+
+	// certificate-bound access tokens can only be used by the holder of the certificate, see RFC 8705, section 3
+	if clientAuthMethod.IsMutualTLS() && providerCfg.TLSClientCertificateBoundAccessTokens {
+		log.WithField("logger", "openid.config.provider").
+			Warnf("identity provider issues certificate-bound access tokens with '%s=%s'; upstreams that verify the 'cnf' claim will reject forwarded access tokens", wonderwallconfig.OpenIDClientAuthMethod, clientAuthMethod)
+	}
+
+	responseMode := cfg.OpenID.ResponseMode
+	if len(responseMode) == 0 {
+		responseMode = wonderwallconfig.ResponseModeQuery
+	}
+
+	responseModes := providerCfg.ResponseModesSupported
+	if len(responseModes) > 0 && !responseModes.Contains(string(responseMode)) {
+		return nil, fmt.Errorf("identity provider does not support '%s=%s'", wonderwallconfig.OpenIDResponseMode, responseMode)
+	}
+
 	endSessionEndpointURL, err := url.Parse(providerCfg.EndSessionEndpoint)
 	if err != nil {
 		return nil, fmt.Errorf("parsing end session endpoint URL: %w", err)
@@ -102,7 +190,9 @@ func NewProviderConfig(cfg *wonderwallconfig.Config) (Provider, error) {
 	providerCfg.Print()
 
 	return &provider{
+		clientAuthMethod:      clientAuthMethod,
 		endSessionEndpointURL: endSessionEndpointURL,
+		idTokenSigningAlgs:    idTokenSigningAlgs,
 		metadata:              providerCfg,
 		name:                  string(cfg.OpenID.Provider),
 	}, nil
@@ -117,22 +207,54 @@ type ProviderMetadata struct {
 	RevocationEndpoint                     string    `json:"revocation_endpoint"`
 	JwksURI                                string    `json:"jwks_uri"`
 	ResponseTypesSupported                 []string  `json:"response_types_supported"`
-	ResponseModesSupported                 []string  `json:"response_modes_supported"`
+	ResponseModesSupported                 Supported `json:"response_modes_supported"`
 	SubjectTypesSupported                  []string  `json:"subject_types_supported"`
-	IDTokenSigningAlgValuesSupported       []string  `json:"id_token_signing_alg_values_supported"`
+	IDTokenSigningAlgValuesSupported       Supported `json:"id_token_signing_alg_values_supported"`
 	CodeChallengeMethodsSupported          []string  `json:"code_challenge_methods_supported"`
 	UserInfoEndpoint                       string    `json:"userinfo_endpoint"`
 	ScopesSupported                        []string  `json:"scopes_supported"`
 	UILocalesSupported                     Supported `json:"ui_locales_supported"`
 	ACRValuesSupported                     Supported `json:"acr_values_supported"`
+	PromptValuesSupported                  Supported `json:"prompt_values_supported"`
 	FrontchannelLogoutSupported            bool      `json:"frontchannel_logout_supported"`
 	FrontchannelLogoutSessionSupported     bool      `json:"frontchannel_logout_session_supported"`
 	IntrospectionEndpoint                  string    `json:"introspection_endpoint"`
-	TokenEndpointAuthMethodsSupported      []string  `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthMethodsSupported      Supported `json:"token_endpoint_auth_methods_supported"`
 	RequestParameterSupported              bool      `json:"request_parameter_supported"`
 	RequestURIParameterSupported           bool      `json:"request_uri_parameter_supported"`
 	RequestObjectSigningAlgValuesSupported []string  `json:"request_object_signing_alg_values_supported"`
 	CheckSessionIframe                     string    `json:"check_session_iframe"`
+
+	AuthorizationSigningAlgValuesSupported Supported `json:"authorization_signing_alg_values_supported"`
+
+	MTLSEndpointAliases                   *MTLSEndpointAliases `json:"mtls_endpoint_aliases"`
+	TLSClientCertificateBoundAccessTokens bool                 `json:"tls_client_certificate_bound_access_tokens"`
+}
+
+// MTLSEndpointAliases contains alternative endpoints that should be used with mutual TLS, see RFC 8705, section 5.
+type MTLSEndpointAliases struct {
+	TokenEndpoint         string `json:"token_endpoint"`
+	RevocationEndpoint    string `json:"revocation_endpoint"`
+	IntrospectionEndpoint string `json:"introspection_endpoint"`
+}
+
+// TokenEndpointFor returns the token endpoint to use for the given client authentication method.
+func (c *ProviderMetadata) TokenEndpointFor(method wonderwallconfig.ClientAuthMethod) string {
+	if method.IsMutualTLS() && c.MTLSEndpointAliases != nil && len(c.MTLSEndpointAliases.TokenEndpoint) > 0 {
+		return c.MTLSEndpointAliases.TokenEndpoint
+	}
+
+	return c.TokenEndpoint
+}
+
+// AuthorizationSigningAlgs returns the signing algorithms supported for JWT secured authorization responses.
+// If the provider does not advertise any algorithms, the default from JARM is assumed.
+func (c *ProviderMetadata) AuthorizationSigningAlgs() Supported {
+	if len(c.AuthorizationSigningAlgValuesSupported) == 0 {
+		return Supported{DefaultAuthorizationSigningAlg}
+	}
+
+	return c.AuthorizationSigningAlgValuesSupported
 }
 
 func (c *ProviderMetadata) Print() {
@@ -142,6 +264,16 @@ func (c *ProviderMetadata) Print() {
 	logger.Infof("%#v", *c)
 }
 
+// DefaultIDTokenSigningAlg is the default signing algorithm for id_tokens, see OpenID Connect Discovery 1.0, section 3.
+const DefaultIDTokenSigningAlg = "RS256"
+
+// DefaultAuthorizationSigningAlg is the default signing algorithm for JWT secured authorization responses, see JARM
+// section 3.
+const DefaultAuthorizationSigningAlg = "RS256"
+
+// DefaultPromptValuesSupported contains the prompt values defined in OpenID Connect Core 1.0, section 3.1.2.1.
+var DefaultPromptValuesSupported = Supported{"none", "login", "consent", "select_account"}
+
 type Supported []string
 
 func (in Supported) Contains(value string) bool {
@@ -152,3 +284,45 @@ func (in Supported) Contains(value string) bool {
 	}
 	return false
 }
+
+// ContainsAll checks that all space-separated values in the given string are supported.
+func (in Supported) ContainsAll(values string) error {
+	for _, value := range strings.Fields(values) {
+		if !in.Contains(value) {
+			return fmt.Errorf("unsupported value '%s'", value)
+		}
+	}
+	return nil
+}
+
+// ResolveIDTokenSigningAlgs returns the accepted signing algorithms for id_tokens, given the configured algorithms and
+// the algorithms supported by the provider. The 'none' algorithm is never accepted.
+func ResolveIDTokenSigningAlgs(configured []string, supported Supported) (Supported, error) {
+	if len(supported) == 0 {
+		supported = Supported{DefaultIDTokenSigningAlg}
+	}
+
+	candidates := Supported(configured)
+	if len(candidates) == 0 {
+		candidates = supported
+	}
+
+	result := make(Supported, 0)
+	for _, alg := range candidates {
+		if alg == "none" {
+			continue
+		}
+
+		if !supported.Contains(alg) {
+			return nil, fmt.Errorf("unsupported value '%s'", alg)
+		}
+
+		result = append(result, alg)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no acceptable signing algorithms")
+	}
+
+	return result, nil
+}
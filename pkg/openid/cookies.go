@@ -14,19 +14,88 @@ type LoginCookie struct {
 	State        string `json:"state"`
 	Nonce        string `json:"nonce"`
 	CodeVerifier string `json:"code_verifier"`
+	MaxAge       string `json:"max_age,omitempty"`
 	Referer      string `json:"referer"`
 	RedirectURI  string `json:"redirect_uri"`
+	ResponseMode string `json:"response_mode,omitempty"`
+	Popup        bool   `json:"popup,omitempty"`
+	Locale       string `json:"locale,omitempty"`
 }
 
+// GetLoginCookie returns the login cookie for the login flow that the request belongs to, i.e. the cookie matching the
+// 'state' parameter in the request. If the request has no such parameter, e.g. for JWT secured authorization responses,
+// the login cookie is only returned if it is the only one.
 func GetLoginCookie(r *http.Request, crypter crypto.Crypter) (*LoginCookie, error) {
-	loginCookieJson, err := cookie.GetDecrypted(r, cookie.Login, crypter)
+	loginCookies, err := GetLoginCookies(r, crypter)
 	if err != nil {
-		middleware.LogEntryFrom(r).Debugf("failed to fetch login cookie: %+v; falling back to legacy cookie", err)
+		return nil, err
+	}
+
+	if loginCookies[0].State == requestState(r) || len(loginCookies) == 1 {
+		return loginCookies[0], nil
+	}
+
+	return nil, fmt.Errorf("none of %d login cookies match the request state: %w", len(loginCookies), http.ErrNoCookie)
+}
+
+// GetLoginCookies returns the login cookies for all login flows in progress, e.g. in multiple browser tabs. The cookie
+// matching the 'state' parameter in the request, if any, is returned first, followed by the most recently set cookies.
+func GetLoginCookies(r *http.Request, crypter crypto.Crypter) ([]*LoginCookie, error) {
+	state := requestState(r)
+	seen := make(map[string]bool)
+	loginCookies := make([]*LoginCookie, 0)
 
-		loginCookieJson, err = cookie.GetDecrypted(r, cookie.LoginLegacy, crypter)
+	for _, name := range loginCookieNames(r) {
+		loginCookie, err := getLoginCookie(r, name, crypter)
 		if err != nil {
-			return nil, err
+			middleware.LogEntryFrom(r).Debugf("failed to fetch login cookie %q: %+v", name, err)
+			continue
+		}
+
+		if seen[loginCookie.State] {
+			continue
 		}
+		seen[loginCookie.State] = true
+
+		if len(state) > 0 && loginCookie.State == state {
+			loginCookies = append([]*LoginCookie{loginCookie}, loginCookies...)
+		} else {
+			loginCookies = append(loginCookies, loginCookie)
+		}
+	}
+
+	if len(loginCookies) == 0 {
+		return nil, fmt.Errorf("no valid login cookies: %w", http.ErrNoCookie)
+	}
+
+	return loginCookies, nil
+}
+
+// loginCookieNames returns the names of the login cookies in the request. Cookies with the SameSite attribute are
+// returned before the legacy cookies without it, which are duplicates for user agents that do not handle SameSite.
+// User agents send older cookies first, so the order is reversed to return the most recently set cookies first.
+func loginCookieNames(r *http.Request) []string {
+	names := make([]string, 0)
+	legacyNames := make([]string, 0)
+
+	cookies := r.Cookies()
+	for i := len(cookies) - 1; i >= 0; i-- {
+		name := cookies[i].Name
+		switch {
+		case cookie.IsLoginLegacy(name):
+			legacyNames = append(legacyNames, name)
+		case cookie.IsLogin(name):
+			names = append(names, name)
+		}
+	}
+
+	return append(names, legacyNames...)
+}
+
+func getLoginCookie(r *http.Request, name string, crypter crypto.Crypter) (*LoginCookie, error) {
+	loginCookieJson, err := cookie.GetDecrypted(r, name, crypter)
+	if err != nil {
+		return nil, err
 	}
 
 	var loginCookie LoginCookie
@@ -37,3 +106,16 @@ func GetLoginCookie(r *http.Request, crypter crypto.Crypter) (*LoginCookie, erro
 
 	return &loginCookie, nil
 }
+
+// requestState returns the 'state' parameter from the query or form of the request, if any.
+func requestState(r *http.Request) string {
+	if state := r.URL.Query().Get(State); len(state) > 0 {
+		return state
+	}
+
+	if r.Method == http.MethodPost {
+		return r.PostFormValue(State)
+	}
+
+	return ""
+}
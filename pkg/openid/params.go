@@ -2,6 +2,7 @@ package openid
 
 const (
 	ACRValues             = "acr_values"
+	Audience              = "audience"
 	ClientAssertion       = "client_assertion"
 	ClientAssertionType   = "client_assertion_type"
 	ClientID              = "client_id"
@@ -9,18 +10,25 @@ const (
 	CodeChallengeMethod   = "code_challenge_method"
 	Code                  = "code"
 	CodeVerifier          = "code_verifier"
+	DomainHint            = "domain_hint"
 	Error                 = "error"
 	ErrorDescription      = "error_description"
 	GrantType             = "grant_type"
 	IDTokenHint           = "id_token_hint"
+	LoginHint             = "login_hint"
+	MaxAge                = "max_age"
 	Nonce                 = "nonce"
 	PostLogoutRedirectURI = "post_logout_redirect_uri"
+	Prompt                = "prompt"
 	SessionState          = "session_state"
 	Sid                   = "sid"
 	State                 = "state"
+	SubjectToken          = "subject_token"
+	SubjectTokenType      = "subject_token_type"
 	RedirectURI           = "redirect_uri"
 	RefreshToken          = "refresh_token"
 	Resource              = "resource"
+	Response              = "response"
 	ResponseMode          = "response_mode"
 	UILocales             = "ui_locales"
 )
@@ -3,4 +3,11 @@ package openid
 const (
 	ClientAssertionTypeJwtBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
 	RefreshTokenValue            = "refresh_token"
+	TokenExchangeValue           = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessTokenValue    = "urn:ietf:params:oauth:token-type:access_token"
+
+	PromptConsent       = "consent"
+	PromptLogin         = "login"
+	PromptNone          = "none"
+	PromptSelectAccount = "select_account"
 )
@@ -1,17 +1,56 @@
 package openid
 
 import (
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	jwtlib "github.com/lestrrat-go/jwx/v2/jwt"
 	"golang.org/x/oauth2"
 
 	"github.com/nais/wonderwall/pkg/jwt"
+	"github.com/nais/wonderwall/pkg/metrics"
 	openidconfig "github.com/nais/wonderwall/pkg/openid/config"
 )
 
+var (
+	ErrIDTokenAlgorithm = errors.New("unexpected signing algorithm")
+	ErrIDTokenAtHash    = errors.New("invalid at_hash")
+	ErrIDTokenAudience  = errors.New("invalid audience")
+	ErrIDTokenAuthTime  = errors.New("invalid auth_time")
+	ErrIDTokenAzp       = errors.New("invalid authorized party")
+	ErrIDTokenClaims    = errors.New("invalid claims")
+	ErrIDTokenDecrypt   = errors.New("decryption failed")
+	ErrIDTokenIat       = errors.New("invalid issued at")
+	ErrIDTokenIssuer    = errors.New("invalid issuer")
+	ErrIDTokenNonce     = errors.New("invalid nonce")
+	ErrIDTokenSignature = errors.New("invalid signature")
+	ErrIDTokenSubject   = errors.New("invalid subject")
+
+	idTokenValidationReasons = map[error]metrics.IDTokenValidationReason{
+		ErrIDTokenAlgorithm: metrics.IDTokenValidationReasonAlgorithm,
+		ErrIDTokenAtHash:    metrics.IDTokenValidationReasonAtHash,
+		ErrIDTokenAudience:  metrics.IDTokenValidationReasonAudience,
+		ErrIDTokenAuthTime:  metrics.IDTokenValidationReasonAuthTime,
+		ErrIDTokenAzp:       metrics.IDTokenValidationReasonAzp,
+		ErrIDTokenClaims:    metrics.IDTokenValidationReasonClaims,
+		ErrIDTokenDecrypt:   metrics.IDTokenValidationReasonDecrypt,
+		ErrIDTokenIat:       metrics.IDTokenValidationReasonIat,
+		ErrIDTokenIssuer:    metrics.IDTokenValidationReasonIssuer,
+		ErrIDTokenNonce:     metrics.IDTokenValidationReasonNonce,
+		ErrIDTokenSignature: metrics.IDTokenValidationReasonSignature,
+		ErrIDTokenSubject:   metrics.IDTokenValidationReasonSubject,
+	}
+)
+
 type Tokens struct {
 	AccessToken  string
 	Expiry       time.Time
@@ -20,8 +59,8 @@ type Tokens struct {
 	TokenType    string
 }
 
-func NewTokens(src *oauth2.Token, jwks jwk.Set) (*Tokens, error) {
-	idToken, err := ParseIDTokenFrom(src, jwks)
+func NewTokens(src *oauth2.Token, jwks jwk.Set, cfg openidconfig.Config) (*Tokens, error) {
+	idToken, err := ParseIDTokenFrom(src, jwks, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("parsing id_token: %w", err)
 	}
@@ -43,26 +82,185 @@ func (in *IDToken) GetSidClaim() (string, error) {
 	return in.GetStringClaim(jwt.SidClaim)
 }
 
-func (in *IDToken) Validate(cfg openidconfig.Config, nonce string) error {
+// Validate validates the id_token against the given configuration and the parameters used in the corresponding
+// authorization request, found in the login cookie. The access token returned alongside the id_token is validated
+// against the at_hash claim, if present.
+//
+// See OpenID Connect Core 1.0, section 3.1.3.7.
+func (in *IDToken) Validate(cfg openidconfig.Config, cookie *LoginCookie, accessToken string) error {
+	if err := in.validateCommon(cfg, accessToken); err != nil {
+		return err
+	}
+
+	nonce, err := in.GetStringClaim(jwt.NonceClaim)
+	if err != nil || subtle.ConstantTimeCompare([]byte(nonce), []byte(cookie.Nonce)) != 1 {
+		return validationFailure(ErrIDTokenNonce, fmt.Errorf("'%s' claim does not match nonce from authorization request", jwt.NonceClaim))
+	}
+
+	if len(cookie.MaxAge) > 0 {
+		maxAge, err := strconv.ParseInt(cookie.MaxAge, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parsing max_age: %w", err)
+		}
+
+		// auth_time is required when max_age is requested, see OpenID Connect Core 1.0, section 2.
+		authTime, err := in.GetTimeClaim(jwt.AuthTimeClaim)
+		if err != nil {
+			return validationFailure(ErrIDTokenAuthTime, err)
+		}
+
+		limit := time.Duration(maxAge) * time.Second
+		if time.Since(authTime) > limit+jwt.AcceptableClockSkew {
+			return validationFailure(ErrIDTokenAuthTime, fmt.Errorf("'%s' claim is older than max_age (%s)", jwt.AuthTimeClaim, limit))
+		}
+	}
+
+	return nil
+}
+
+// ValidateRefreshed validates an id_token returned from a refresh grant against the given configuration and the
+// id_token from the original authentication. The issuer and subject must match the original id_token.
+//
+// See OpenID Connect Core 1.0, section 12.2.
+func (in *IDToken) ValidateRefreshed(cfg openidconfig.Config, original *IDToken, accessToken string) error {
+	if err := in.validateCommon(cfg, accessToken); err != nil {
+		return err
+	}
+
+	token := in.GetToken()
+	originalToken := original.GetToken()
+
+	if token.Issuer() != originalToken.Issuer() {
+		return validationFailure(ErrIDTokenIssuer, fmt.Errorf("expected %q from original id_token, got %q", originalToken.Issuer(), token.Issuer()))
+	}
+
+	if token.Subject() != originalToken.Subject() {
+		return validationFailure(ErrIDTokenSubject, fmt.Errorf("expected %q from original id_token, got %q", originalToken.Subject(), token.Subject()))
+	}
+
+	return nil
+}
+
+func (in *IDToken) validateCommon(cfg openidconfig.Config, accessToken string) error {
 	openIDconfig := cfg.Provider()
 	clientConfig := cfg.Client()
+	token := in.GetToken()
+	clientID := clientConfig.ClientID()
+
+	if token.Issuer() != openIDconfig.Issuer() {
+		return validationFailure(ErrIDTokenIssuer, fmt.Errorf("expected %q, got %q", openIDconfig.Issuer(), token.Issuer()))
+	}
+
+	if !openidconfig.Supported(token.Audience()).Contains(clientID) {
+		return validationFailure(ErrIDTokenAudience, fmt.Errorf("expected %q to be in %q", clientID, token.Audience()))
+	}
+
+	// azp is required if the token has multiple audiences, and must match our client ID if present.
+	azp, err := in.GetStringClaim(jwt.AzpClaim)
+	switch {
+	case err != nil && len(token.Audience()) > 1:
+		return validationFailure(ErrIDTokenAzp, fmt.Errorf("'%s' claim is required for multiple audiences: %+v", jwt.AzpClaim, err))
+	case err == nil && azp != clientID:
+		return validationFailure(ErrIDTokenAzp, fmt.Errorf("expected %q, got %q", clientID, azp))
+	}
+
+	// iat is checked before the general claims validation to distinguish issuance failures from other claim failures.
+	now := time.Now()
+	if _, ok := token.Get(jwtlib.IssuedAtKey); ok {
+		iat := token.IssuedAt()
+		if iat.After(now.Add(jwt.AcceptableClockSkew)) {
+			return validationFailure(ErrIDTokenIat, fmt.Errorf("'iat' claim is in the future: %s", iat))
+		}
+
+		iatMaxAge := clientConfig.IDTokenIatMaxAge()
+		if iatMaxAge > 0 && now.Sub(iat) > iatMaxAge+jwt.AcceptableClockSkew {
+			return validationFailure(ErrIDTokenIat, fmt.Errorf("'iat' claim is older than %s: %s", iatMaxAge, iat))
+		}
+	}
 
 	opts := []jwtlib.ValidateOption{
-		jwtlib.WithAudience(clientConfig.ClientID()),
-		jwtlib.WithClaimValue("nonce", nonce),
-		jwtlib.WithIssuer(openIDconfig.Issuer()),
 		jwtlib.WithAcceptableSkew(jwt.AcceptableClockSkew),
+		jwtlib.WithRequiredClaim(jwtlib.SubjectKey),
+		jwtlib.WithRequiredClaim(jwtlib.IssuedAtKey),
+		jwtlib.WithRequiredClaim(jwtlib.ExpirationKey),
 	}
 
 	if openIDconfig.SidClaimRequired() {
-		opts = append(opts, jwtlib.WithRequiredClaim("sid"))
+		opts = append(opts, jwtlib.WithRequiredClaim(jwt.SidClaim))
 	}
 
 	if len(clientConfig.ACRValues()) > 0 {
 		opts = append(opts, jwtlib.WithRequiredClaim("acr"))
 	}
 
-	return jwtlib.Validate(in.GetToken(), opts...)
+	if err := jwtlib.Validate(token, opts...); err != nil {
+		return validationFailure(ErrIDTokenClaims, err)
+	}
+
+	if _, ok := token.Get(jwt.AuthTimeClaim); ok {
+		authTime, err := in.GetTimeClaim(jwt.AuthTimeClaim)
+		if err != nil {
+			return validationFailure(ErrIDTokenAuthTime, err)
+		}
+
+		if authTime.After(now.Add(jwt.AcceptableClockSkew)) {
+			return validationFailure(ErrIDTokenAuthTime, fmt.Errorf("'%s' claim is in the future: %s", jwt.AuthTimeClaim, authTime))
+		}
+	}
+
+	if _, ok := token.Get(jwt.AtHashClaim); ok && len(accessToken) > 0 {
+		if err := in.validateAtHash(accessToken); err != nil {
+			return validationFailure(ErrIDTokenAtHash, err)
+		}
+	}
+
+	return nil
+}
+
+// validateAtHash validates the at_hash claim against the given access token, see OpenID Connect Core 1.0, section 3.1.3.8.
+func (in *IDToken) validateAtHash(accessToken string) error {
+	atHash, err := in.GetStringClaim(jwt.AtHashClaim)
+	if err != nil {
+		return err
+	}
+
+	alg, err := in.GetAlgorithm()
+	if err != nil {
+		return err
+	}
+
+	expected, err := TokenHash(alg, accessToken)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(atHash), []byte(expected)) != 1 {
+		return fmt.Errorf("'%s' claim does not match access_token", jwt.AtHashClaim)
+	}
+
+	return nil
+}
+
+// TokenHash returns the base64url encoding of the left-most half of the hash of the given token, using the hash
+// algorithm that corresponds to the given signing algorithm.
+func TokenHash(alg jwa.SignatureAlgorithm, token string) (string, error) {
+	var hash crypto.Hash
+	switch alg {
+	case jwa.RS256, jwa.ES256, jwa.PS256, jwa.HS256:
+		hash = crypto.SHA256
+	case jwa.RS384, jwa.ES384, jwa.PS384, jwa.HS384:
+		hash = crypto.SHA384
+	case jwa.RS512, jwa.ES512, jwa.PS512, jwa.HS512, jwa.EdDSA:
+		hash = crypto.SHA512
+	default:
+		return "", fmt.Errorf("unsupported algorithm for token hash: %q", alg)
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(token))
+	sum := hasher.Sum(nil)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
 }
 
 func NewIDToken(raw string, jwtToken jwtlib.Token) *IDToken {
@@ -71,8 +269,40 @@ func NewIDToken(raw string, jwtToken jwtlib.Token) *IDToken {
 	}
 }
 
-func ParseIDToken(raw string, jwks jwk.Set) (*IDToken, error) {
-	idToken, err := jwt.Parse(raw, jwks)
+// ParseIDToken parses the given id_token and verifies its signature with the given key set. Encrypted id_tokens are
+// decrypted with the client's decryption keys first; the returned id_token then contains the decrypted, signed JWT.
+func ParseIDToken(raw string, jwks jwk.Set, cfg openidconfig.Config) (*IDToken, error) {
+	if jwt.IsEncrypted(raw) {
+		decrypted, err := jwt.Decrypt(raw, cfg.Client().IDTokenDecryptionKeys())
+		if err != nil {
+			return nil, validationFailure(ErrIDTokenDecrypt, err)
+		}
+
+		raw = decrypted
+	}
+
+	alg, err := jwt.Algorithm(raw)
+	if err != nil {
+		return nil, validationFailure(ErrIDTokenSignature, err)
+	}
+
+	allowed := cfg.Provider().IDTokenSigningAlgs()
+	if !allowed.Contains(alg.String()) {
+		return nil, validationFailure(ErrIDTokenAlgorithm, fmt.Errorf("expected one of %q, got %q", allowed, alg))
+	}
+
+	idToken, err := jwt.Parse(raw, jwks, alg)
+	if err != nil {
+		return nil, validationFailure(ErrIDTokenSignature, err)
+	}
+
+	return NewIDToken(raw, idToken), nil
+}
+
+// ParseIDTokenUnverified parses an id_token without verifying its signature or validating its claims.
+// This should only be used for id_tokens that have previously been verified, e.g. those stored in a session.
+func ParseIDTokenUnverified(raw string) (*IDToken, error) {
+	idToken, err := jwt.ParseUnverified(raw)
 	if err != nil {
 		return nil, err
 	}
@@ -80,11 +310,31 @@ func ParseIDToken(raw string, jwks jwk.Set) (*IDToken, error) {
 	return NewIDToken(raw, idToken), nil
 }
 
-func ParseIDTokenFrom(tokens *oauth2.Token, jwks jwk.Set) (*IDToken, error) {
+func ParseIDTokenFrom(tokens *oauth2.Token, jwks jwk.Set, cfg openidconfig.Config) (*IDToken, error) {
 	idToken, ok := tokens.Extra("id_token").(string)
 	if !ok {
 		return nil, fmt.Errorf("missing id_token in token response")
 	}
 
-	return ParseIDToken(idToken, jwks)
+	return ParseIDToken(idToken, jwks, cfg)
+}
+
+// IsIDTokenValidationError reports whether the given error is caused by an id_token that failed validation, as opposed
+// to e.g. a transient failure when fetching the provider's signing keys.
+func IsIDTokenValidationError(err error) bool {
+	for reason := range idTokenValidationReasons {
+		if errors.Is(err, reason) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validationFailure(reason, cause error) error {
+	if label, ok := idTokenValidationReasons[reason]; ok {
+		metrics.ObserveIDTokenValidationFailure(label)
+	}
+
+	return fmt.Errorf("%w: %+v", reason, cause)
 }
@@ -0,0 +1,184 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nais/wonderwall/pkg/config"
+	mw "github.com/nais/wonderwall/pkg/middleware"
+)
+
+const (
+	KeyPrefix      = "wonderwall.ratelimit"
+	XForwardedFor  = "X-Forwarded-For"
+	keyGlobal      = "global"
+	keyClientIPFmt = "ip:%s"
+
+	// ipv6PrefixBits is the prefix length that IPv6 clients are grouped by, as a single client is commonly assigned
+	// an entire /64 network.
+	ipv6PrefixBits = 64
+)
+
+// Store counts requests for a key within a window.
+type Store interface {
+	// Increment increments the counter for the given key and returns the new count. The counter is removed at the
+	// given expiry.
+	Increment(ctx context.Context, key string, expiresAt time.Time) (int64, error)
+}
+
+// RateLimit limits the number of requests per client IP address and across all clients within fixed windows.
+type RateLimit struct {
+	config         config.RateLimit
+	store          Store
+	trustedProxies []*net.IPNet
+}
+
+// New returns a rate limiter that counts requests with the given Redis client, e.g. the one used for sessions, or in
+// memory if the client is nil.
+func New(cfg *config.Config, redisClient *redis.Client) (*RateLimit, error) {
+	if !cfg.RateLimit.Enabled {
+		return &RateLimit{config: cfg.RateLimit}, nil
+	}
+
+	if redisClient == nil {
+		return NewWithStore(cfg.RateLimit, NewMemory())
+	}
+
+	log.Infof("Using Redis for rate limiting")
+	return NewWithStore(cfg.RateLimit, NewRedis(redisClient))
+}
+
+func NewWithStore(cfg config.RateLimit, store Store) (*RateLimit, error) {
+	trustedProxies, err := cfg.ParseTrustedProxies()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RateLimit{
+		config:         cfg,
+		store:          store,
+		trustedProxies: trustedProxies,
+	}, nil
+}
+
+// Handler responds with 429 Too Many Requests and a Retry-After header for requests that exceed any of the limits.
+// Requests are allowed if the store is unavailable.
+func (rl *RateLimit) Handler(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !rl.config.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		windowStart := now.Truncate(rl.config.Window)
+		windowEnd := windowStart.Add(rl.config.Window)
+
+		limited := false
+		if rl.config.PerIP > 0 {
+			key := fmt.Sprintf(keyClientIPFmt, clientKey(rl.ClientIP(r)))
+			limited = rl.exceeds(r, key, rl.config.PerIP, windowStart, windowEnd)
+		}
+
+		// requests limited by client are not counted towards the global limit
+		if !limited && rl.config.Global > 0 {
+			limited = rl.exceeds(r, keyGlobal, rl.config.Global, windowStart, windowEnd)
+		}
+
+		if limited {
+			retryAfter := int(math.Ceil(windowEnd.Sub(now).Seconds()))
+			mw.LogEntryFrom(r).Infof("ratelimit: rate limit exceeded for %q; retry after %ds", rl.ClientIP(r), retryAfter)
+
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (rl *RateLimit) exceeds(r *http.Request, key string, limit int, windowStart, windowEnd time.Time) bool {
+	key = fmt.Sprintf("%s:%s:%d", KeyPrefix, key, windowStart.Unix())
+
+	count, err := rl.store.Increment(r.Context(), key, windowEnd)
+	if err != nil {
+		mw.LogEntryFrom(r).Warnf("ratelimit: incrementing counter; allowing request: %+v", err)
+		return false
+	}
+
+	return count > int64(limit)
+}
+
+// ClientIP returns the IP address of the client for the given request. For requests from trusted proxies, the
+// 'X-Forwarded-For' header is read from right to left, and the first address that is not a trusted proxy is used.
+func (rl *RateLimit) ClientIP(r *http.Request) string {
+	clientIP := hostWithoutPort(r.RemoteAddr)
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil || !rl.trusted(ip) {
+		return clientIP
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values(XForwardedFor), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		candidate := strings.TrimSpace(forwarded[i])
+
+		ip := net.ParseIP(candidate)
+		if ip == nil {
+			// stop at malformed entries, as anything to the left of them cannot be trusted
+			break
+		}
+
+		clientIP = candidate
+		if !rl.trusted(ip) {
+			break
+		}
+	}
+
+	return clientIP
+}
+
+// clientKey returns the key that requests from the given client IP address are counted by. IPv6 addresses are grouped
+// by their /64 network.
+func clientKey(clientIP string) string {
+	ip := net.ParseIP(clientIP)
+	if ip == nil || ip.To4() != nil {
+		return clientIP
+	}
+
+	network := net.IPNet{
+		IP:   ip.Mask(net.CIDRMask(ipv6PrefixBits, 8*net.IPv6len)),
+		Mask: net.CIDRMask(ipv6PrefixBits, 8*net.IPv6len),
+	}
+	return network.String()
+}
+
+func (rl *RateLimit) trusted(ip net.IP) bool {
+	for _, network := range rl.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hostWithoutPort(host string) string {
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+
+	return h
+}
@@ -0,0 +1,256 @@
+package ratelimit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nais/wonderwall/pkg/config"
+	mw "github.com/nais/wonderwall/pkg/middleware"
+	"github.com/nais/wonderwall/pkg/ratelimit"
+)
+
+func TestRateLimit_Handler(t *testing.T) {
+	rl, err := ratelimit.NewWithStore(config.RateLimit{
+		Enabled: true,
+		Global:  3,
+		PerIP:   2,
+		Window:  time.Hour,
+	}, ratelimit.NewMemory())
+	assert.NoError(t, err)
+
+	logEntry := mw.LogEntry("test")
+	handler := logEntry.Handler(rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	request := func(remoteAddr string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/oauth2/login", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1234").StatusCode)
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1235").StatusCode)
+
+	// per-client limit exceeded
+	resp := request("10.0.0.1:1236")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	assert.NoError(t, err)
+	assert.True(t, retryAfter > 0 && retryAfter <= 3600)
+
+	// other clients are only limited by the global limit
+	assert.Equal(t, http.StatusOK, request("10.0.0.2:1234").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, request("10.0.0.3:1234").StatusCode)
+}
+
+func TestRateLimit_Handler_IPv6(t *testing.T) {
+	rl, err := ratelimit.NewWithStore(config.RateLimit{
+		Enabled: true,
+		PerIP:   2,
+		Window:  time.Hour,
+	}, ratelimit.NewMemory())
+	assert.NoError(t, err)
+
+	logEntry := mw.LogEntry("test")
+	handler := logEntry.Handler(rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	request := func(remoteAddr string) int {
+		r := httptest.NewRequest(http.MethodGet, "/oauth2/login", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// addresses within the same /64 network share a limit
+	assert.Equal(t, http.StatusOK, request("[2001:db8:0:1::1]:1234"))
+	assert.Equal(t, http.StatusOK, request("[2001:db8:0:1::2]:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, request("[2001:db8:0:1:ffff::3]:1234"))
+
+	// other networks are counted separately
+	assert.Equal(t, http.StatusOK, request("[2001:db8:0:2::1]:1234"))
+}
+
+func TestRateLimit_Disabled(t *testing.T) {
+	rl, err := ratelimit.New(&config.Config{}, nil)
+	assert.NoError(t, err)
+
+	handler := rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 100; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oauth2/login", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+func TestRateLimit_ClientIP(t *testing.T) {
+	rl, err := ratelimit.NewWithStore(config.RateLimit{
+		TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"},
+	}, ratelimit.NewMemory())
+	assert.NoError(t, err)
+
+	for _, test := range []struct {
+		name          string
+		remoteAddr    string
+		xForwardedFor []string
+		want          string
+	}{
+		{
+			name:       "no proxy",
+			remoteAddr: "203.0.113.1:1234",
+			want:       "203.0.113.1",
+		},
+		{
+			name:          "untrusted proxy",
+			remoteAddr:    "203.0.113.1:1234",
+			xForwardedFor: []string{"198.51.100.1"},
+			want:          "203.0.113.1",
+		},
+		{
+			name:          "trusted proxy",
+			remoteAddr:    "10.0.0.1:1234",
+			xForwardedFor: []string{"198.51.100.1"},
+			want:          "198.51.100.1",
+		},
+		{
+			name:          "spoofed entries left of the client are ignored",
+			remoteAddr:    "10.0.0.1:1234",
+			xForwardedFor: []string{"1.2.3.4, 198.51.100.1, 192.168.1.1"},
+			want:          "198.51.100.1",
+		},
+		{
+			name:          "multiple headers",
+			remoteAddr:    "10.0.0.1:1234",
+			xForwardedFor: []string{"1.2.3.4", "198.51.100.1"},
+			want:          "198.51.100.1",
+		},
+		{
+			name:          "malformed entry",
+			remoteAddr:    "10.0.0.1:1234",
+			xForwardedFor: []string{"198.51.100.1, garbage, 10.0.0.2"},
+			want:          "10.0.0.2",
+		},
+		{
+			name:       "trusted proxy without header",
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			for _, value := range test.xForwardedFor {
+				r.Header.Add(ratelimit.XForwardedFor, value)
+			}
+
+			assert.Equal(t, test.want, rl.ClientIP(r))
+		})
+	}
+}
+
+func TestConfig_InvalidTrustedProxies(t *testing.T) {
+	_, err := ratelimit.NewWithStore(config.RateLimit{
+		TrustedProxies: []string{"not-an-ip"},
+	}, ratelimit.NewMemory())
+	assert.Error(t, err)
+}
+
+func TestStores(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	defer s.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Network: "tcp",
+		Addr:    s.Addr(),
+	})
+
+	for name, store := range map[string]ratelimit.Store{
+		"memory": ratelimit.NewMemory(),
+		"redis":  ratelimit.NewRedis(client),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			expiresAt := time.Now().Add(time.Minute)
+
+			for i := int64(1); i <= 3; i++ {
+				count, err := store.Increment(ctx, "key", expiresAt)
+				assert.NoError(t, err)
+				assert.Equal(t, i, count)
+			}
+
+			count, err := store.Increment(ctx, "other", expiresAt)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), count)
+		})
+	}
+
+	t.Run("memory counters expire", func(t *testing.T) {
+		ctx := context.Background()
+		store := ratelimit.NewMemory()
+
+		_, err := store.Increment(ctx, "expiring", time.Now().Add(-time.Second))
+		assert.NoError(t, err)
+
+		count, err := store.Increment(ctx, "expiring", time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("memory evicts least recently used counters when full", func(t *testing.T) {
+		ctx := context.Background()
+		store := ratelimit.NewMemoryWithCapacity(2)
+		expiresAt := time.Now().Add(time.Minute)
+
+		for _, key := range []string{"a", "b", "a", "c"} {
+			_, err := store.Increment(ctx, key, expiresAt)
+			assert.NoError(t, err)
+		}
+
+		// "b" was least recently used when "c" was added
+		count, err := store.Increment(ctx, "b", expiresAt)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		// "a" was evicted when "b" was added again
+		count, err = store.Increment(ctx, "c", expiresAt)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+
+		count, err = store.Increment(ctx, "a", expiresAt)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("redis counters expire", func(t *testing.T) {
+		ctx := context.Background()
+		store := ratelimit.NewRedis(client)
+
+		_, err := store.Increment(ctx, "expiring", time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+
+		s.FastForward(2 * time.Minute)
+
+		count, err := store.Increment(ctx, "expiring", time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DefaultMemoryCapacity is the default maximum number of counters in the in-memory store.
+const DefaultMemoryCapacity = 10_000
+
+var _ Store = &Memory{}
+
+type memoryEntry struct {
+	key       string
+	count     int64
+	expiresAt time.Time
+}
+
+// Memory stores counters in memory. The number of counters is bounded; when full, the least recently used counter is
+// evicted to make room for new ones.
+type Memory struct {
+	capacity int
+	lock     sync.Mutex
+	entries  map[string]*list.Element
+	order    *list.List // order contains entries from most to least recently used.
+}
+
+func NewMemory() *Memory {
+	return NewMemoryWithCapacity(DefaultMemoryCapacity)
+}
+
+// NewMemoryWithCapacity returns an in-memory store that holds at most the given number of counters, and at least one.
+func NewMemoryWithCapacity(capacity int) *Memory {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &Memory{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (m *Memory) Increment(_ context.Context, key string, expiresAt time.Time) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	if element, found := m.entries[key]; found {
+		entry := element.Value.(*memoryEntry)
+		if !now.Before(entry.expiresAt) {
+			entry.count = 0
+			entry.expiresAt = expiresAt
+		}
+
+		entry.count++
+		m.order.MoveToFront(element)
+		return entry.count, nil
+	}
+
+	m.removeExpired(now)
+	if m.order.Len() >= m.capacity {
+		m.remove(m.order.Back())
+	}
+
+	entry := &memoryEntry{key: key, count: 1, expiresAt: expiresAt}
+	m.entries[key] = m.order.PushFront(entry)
+	return entry.count, nil
+}
+
+// removeExpired removes expired counters from the least recently used end until it finds one that has not expired.
+func (m *Memory) removeExpired(now time.Time) {
+	for element := m.order.Back(); element != nil; element = m.order.Back() {
+		if now.Before(element.Value.(*memoryEntry).expiresAt) {
+			return
+		}
+
+		m.remove(element)
+	}
+}
+
+func (m *Memory) remove(element *list.Element) {
+	entry := m.order.Remove(element).(*memoryEntry)
+	delete(m.entries, entry.key)
+}
+
+var _ Store = &Redis{}
+
+type Redis struct {
+	client redis.Cmdable
+}
+
+func NewRedis(client redis.Cmdable) *Redis {
+	return &Redis{
+		client: client,
+	}
+}
+
+func (r *Redis) Increment(ctx context.Context, key string, expiresAt time.Time) (int64, error) {
+	pipe := r.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.PExpireAt(ctx, key, expiresAt)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
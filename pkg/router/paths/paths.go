@@ -2,6 +2,7 @@ package paths
 
 const (
 	OAuth2             = "/oauth2"
+	Jwks               = "/jwks"
 	Login              = "/login"
 	LoginCallback      = "/callback"
 	Logout             = "/logout"
@@ -9,5 +10,8 @@ const (
 	LogoutFrontChannel = "/logout/frontchannel"
 	LogoutLocal        = "/logout/local"
 	Session            = "/session"
+	SessionClaims      = "/session/claims"
 	SessionRefresh     = "/session/refresh"
+	SessionCheck       = "/session/check"
+	SessionStatus      = "/session/status"
 )
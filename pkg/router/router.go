@@ -6,8 +6,11 @@ import (
 	"github.com/go-chi/chi/v5"
 	chi_middleware "github.com/go-chi/chi/v5/middleware"
 
+	"github.com/nais/wonderwall/pkg/config"
 	"github.com/nais/wonderwall/pkg/ingress"
 	"github.com/nais/wonderwall/pkg/middleware"
+	openidconfig "github.com/nais/wonderwall/pkg/openid/config"
+	"github.com/nais/wonderwall/pkg/ratelimit"
 	"github.com/nais/wonderwall/pkg/router/paths"
 )
 
@@ -31,21 +34,35 @@ type Handlers interface {
 	LogoutLocal(http.ResponseWriter, *http.Request)
 	// Session returns metadata for the current user's session.
 	Session(http.ResponseWriter, *http.Request)
+	// SessionClaims returns the allow-listed claims for the current user's session.
+	SessionClaims(http.ResponseWriter, *http.Request)
 	// SessionRefresh refreshes current user's session and returns the associated updated metadata.
 	SessionRefresh(http.ResponseWriter, *http.Request)
+	// SessionCheck serves the relying party iframe for detecting session changes at the identity provider.
+	SessionCheck(http.ResponseWriter, *http.Request)
+	// SessionStatus returns the parameters needed to check the current user's session at the identity provider.
+	SessionStatus(http.ResponseWriter, *http.Request)
+	// Jwks publishes the client's public keys for encrypting id_tokens.
+	Jwks(http.ResponseWriter, *http.Request)
 	// ReverseProxy proxies all requests upstream.
 	ReverseProxy(http.ResponseWriter, *http.Request)
 }
 
 type Config interface {
+	GetCORSConfig() config.CORS
 	GetIngresses() *ingress.Ingresses
+	GetOpenIDConfig() openidconfig.Config
 	GetProviderName() string
+	GetRateLimit() *ratelimit.RateLimit
 }
 
 func New(src Source) chi.Router {
 	ingressMw := middleware.Ingress(src)
 	prometheus := middleware.Prometheus(src.GetProviderName())
 	logentry := middleware.LogEntry(src.GetProviderName())
+	cors := middleware.CORS(src.GetCORSConfig(), src)
+	upstreamCors := cors.ForUpstream()
+	rateLimit := src.GetRateLimit()
 
 	r := chi.NewRouter()
 	r.Use(middleware.CorrelationIDHandler)
@@ -61,19 +78,38 @@ func New(src Source) chi.Router {
 
 		for _, prefix := range prefixes {
 			r.Route(prefix+paths.OAuth2, func(r chi.Router) {
-				r.Get(paths.Login, src.Login)
-				r.Get(paths.LoginCallback, src.LoginCallback)
+				r.Use(cors.Handler)
+
+				// unauthenticated endpoints that call the identity provider or write to the session store
+				r.Group(func(r chi.Router) {
+					r.Use(rateLimit.Handler)
+					r.Get(paths.Login, src.Login)
+					r.Get(paths.LoginCallback, src.LoginCallback)
+					r.Post(paths.LoginCallback, src.LoginCallback)
+					r.Get(paths.LogoutFrontChannel, src.LogoutFrontChannel)
+				})
+
 				r.Get(paths.Logout, src.Logout)
 				r.Get(paths.LogoutCallback, src.LogoutCallback)
-				r.Get(paths.LogoutFrontChannel, src.LogoutFrontChannel)
 				r.Get(paths.LogoutLocal, src.LogoutLocal)
 				r.Get(paths.Session, src.Session)
+				r.Get(paths.SessionClaims, src.SessionClaims)
 				r.Get(paths.SessionRefresh, src.SessionRefresh) // TODO: for legacy purposes, remove after grace period
 				r.Post(paths.SessionRefresh, src.SessionRefresh)
+				r.Get(paths.SessionCheck, src.SessionCheck)
+				r.Get(paths.SessionStatus, src.SessionStatus)
+
+				if src.GetOpenIDConfig().Client().IDTokenDecryptionKeys().Len() > 0 {
+					r.Get(paths.Jwks, src.Jwks)
+				}
 			})
 		}
 	})
 
-	r.HandleFunc("/*", src.ReverseProxy)
+	if src.GetCORSConfig().Upstream {
+		r.With(upstreamCors.Handler).HandleFunc("/*", src.ReverseProxy)
+	} else {
+		r.HandleFunc("/*", src.ReverseProxy)
+	}
 	return r
 }
@@ -0,0 +1,144 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	mw "github.com/nais/wonderwall/pkg/middleware"
+)
+
+// connections holds long-lived connections, e.g. upgraded WebSocket connections, for each session key.
+type connections struct {
+	lock  sync.Mutex
+	conns map[string]map[io.Closer]struct{}
+}
+
+func newConnections() *connections {
+	return &connections{
+		conns: make(map[string]map[io.Closer]struct{}),
+	}
+}
+
+func (c *connections) add(key string, conn io.Closer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.conns[key]; !ok {
+		c.conns[key] = make(map[io.Closer]struct{})
+	}
+
+	c.conns[key][conn] = struct{}{}
+}
+
+func (c *connections) remove(key string, conn io.Closer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.conns[key], conn)
+	if len(c.conns[key]) == 0 {
+		delete(c.conns, key)
+	}
+}
+
+// closeAll closes and removes all connections for the given session key.
+func (c *connections) closeAll(key string) {
+	c.lock.Lock()
+	conns := c.conns[key]
+	delete(c.conns, key)
+	c.lock.Unlock()
+
+	// connections may remove themselves when closed, so we must not hold the lock here
+	for conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+// TrackConnection tracks a long-lived connection, e.g. an upgraded WebSocket connection, for the session with the
+// given key and data. The connection is closed when the session is destroyed by this instance, or when the session is
+// found to have ended, timed out or been removed from the store. The session is checked at its end or timeout, and
+// otherwise at the configured interval to detect sessions destroyed by other instances.
+//
+// The returned function stops tracking the connection, and must be called when the connection is closed.
+func (h *Handler) TrackConnection(r *http.Request, key string, data *Data, conn io.Closer) func() {
+	logger := mw.LogEntryFrom(r)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	h.connections.add(key, conn)
+
+	go func() {
+		timer := time.NewTimer(h.nextConnectionCheck(data))
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+
+			current, err := h.getForKey(ctx, key)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case errors.Is(err, ErrKeyNotFound):
+				logger.Info("session: session not found; closing upgraded connection")
+				_ = conn.Close()
+				return
+			case err != nil:
+				// keep the connection on transient errors, e.g. if the store is unavailable
+				logger.Warnf("session: checking session for upgraded connection: %+v", err)
+			case h.hasEnded(current):
+				logger.Info("session: session has ended; closing upgraded connection")
+				_ = conn.Close()
+				return
+			default:
+				data = current
+			}
+
+			timer.Reset(h.nextConnectionCheck(data))
+		}
+	}()
+
+	return func() {
+		cancel()
+		h.connections.remove(key, conn)
+	}
+}
+
+// hasEnded returns true if the session has reached its end, has timed out due to inactivity, or has an expired
+// access token that will not be refreshed.
+func (h *Handler) hasEnded(data *Data) bool {
+	if time.Now().After(data.Metadata.Session.EndsAt) || h.isTimedOut(data) {
+		return true
+	}
+
+	return !h.cfg.Refresh && data.Metadata.IsExpired()
+}
+
+func (h *Handler) nextConnectionCheck(data *Data) time.Duration {
+	next := h.cfg.WebSocketCheckInterval
+
+	deadlines := []time.Time{data.Metadata.Session.EndsAt}
+	if h.cfg.Inactivity {
+		deadlines = append(deadlines, data.Metadata.Session.TimeoutAt)
+	}
+	if !h.cfg.Refresh {
+		deadlines = append(deadlines, data.Metadata.Tokens.ExpireAt)
+	}
+
+	for _, deadline := range deadlines {
+		if until := time.Until(deadline); !deadline.IsZero() && until < next {
+			next = until
+		}
+	}
+
+	if next < 0 {
+		return 0
+	}
+
+	return next
+}
@@ -1,9 +1,11 @@
 package session
 
 import (
+	"context"
 	"encoding"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/nais/wonderwall/pkg/crypto"
@@ -51,12 +53,14 @@ func (in *EncryptedData) Decrypt(crypter crypto.Crypter) (*Data, error) {
 }
 
 type Data struct {
-	ExternalSessionID string   `json:"external_session_id"`
-	AccessToken       string   `json:"access_token"`
-	IDToken           string   `json:"id_token"`
-	RefreshToken      string   `json:"refresh_token"`
-	IDTokenJwtID      string   `json:"id_token_jwt_id"`
-	Metadata          Metadata `json:"metadata"`
+	ExternalSessionID string         `json:"external_session_id"`
+	AccessToken       string         `json:"access_token"`
+	IDToken           string         `json:"id_token"`
+	RefreshToken      string         `json:"refresh_token"`
+	IDTokenJwtID      string         `json:"id_token_jwt_id"`
+	SessionState      string         `json:"session_state,omitempty"`
+	UserInfo          map[string]any `json:"userinfo,omitempty"`
+	Metadata          Metadata       `json:"metadata"`
 }
 
 func NewData(externalSessionID string, tokens *openid.Tokens, metadata *Metadata) *Data {
@@ -91,6 +95,28 @@ func (in *Data) Encrypt(crypter crypto.Crypter) (*EncryptedData, error) {
 	}, nil
 }
 
+// Claims returns the claims from the id_token, together with any claims from the userinfo endpoint that are not
+// present in the id_token.
+func (in *Data) Claims(ctx context.Context) (map[string]any, error) {
+	idToken, err := openid.ParseIDTokenUnverified(in.IDToken)
+	if err != nil {
+		return nil, fmt.Errorf("parsing id_token: %w", err)
+	}
+
+	claims, err := idToken.GetToken().AsMap(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting id_token claims: %w", err)
+	}
+
+	for claim, value := range in.UserInfo {
+		if _, found := claims[claim]; !found {
+			claims[claim] = value
+		}
+	}
+
+	return claims, nil
+}
+
 func (in *Data) HasAccessToken() bool {
 	return len(in.AccessToken) > 0
 }
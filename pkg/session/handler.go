@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/sethvargo/go-retry"
 
 	"github.com/nais/wonderwall/pkg/config"
@@ -37,31 +38,36 @@ const (
 )
 
 type Handler struct {
-	cfg       config.Session
-	client    *openidclient.Client
-	crypter   crypto.Crypter
-	openidCfg openidconfig.Config
-	store     Store
+	cfg         config.Session
+	client      *openidclient.Client
+	connections *connections
+	crypter     crypto.Crypter
+	openidCfg   openidconfig.Config
+	store       Store
+	userInfo    bool
 }
 
-func NewHandler(cfg *config.Config, openidCfg openidconfig.Config, crypter crypto.Crypter, openidClient *openidclient.Client) (*Handler, error) {
-	store, err := NewStore(cfg)
+func NewHandler(cfg *config.Config, openidCfg openidconfig.Config, crypter crypto.Crypter, openidClient *openidclient.Client, redisClient *redis.Client) (*Handler, error) {
+	store, err := NewStore(redisClient)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Handler{
-		crypter:   crypter,
-		client:    openidClient,
-		openidCfg: openidCfg,
-		store:     store,
-		cfg:       cfg.Session,
+		crypter:     crypter,
+		client:      openidClient,
+		connections: newConnections(),
+		openidCfg:   openidCfg,
+		store:       store,
+		cfg:         cfg.Session,
+		userInfo:    cfg.OpenID.UserInfo,
 	}, nil
 }
 
 // Create creates and stores a session in the Store, and returns the session's key.
-func (h *Handler) Create(r *http.Request, tokens *openid.Tokens, sessionLifetime time.Duration) (string, error) {
-	externalSessionID, err := h.IDOrGenerate(r, tokens)
+// The given params are the parameters from the authorization response.
+func (h *Handler) Create(r *http.Request, tokens *openid.Tokens, params url.Values, sessionLifetime time.Duration) (string, error) {
+	externalSessionID, err := h.IDOrGenerate(tokens, params)
 	if err != nil {
 		return "", fmt.Errorf("generating session ID: %w", err)
 	}
@@ -74,7 +80,18 @@ func (h *Handler) Create(r *http.Request, tokens *openid.Tokens, sessionLifetime
 		metadata.WithTimeout(h.cfg.InactivityTimeout)
 	}
 
-	encrypted, err := NewData(externalSessionID, tokens, metadata).Encrypt(h.crypter)
+	data := NewData(externalSessionID, tokens, metadata)
+	// session_state is only returned in the authentication response, see OpenID Connect Session Management 1.0, section 2.
+	data.SessionState = params.Get(openid.SessionState)
+
+	if h.userInfo {
+		data.UserInfo, err = h.client.UserInfo(r.Context(), tokens.AccessToken, tokens.IDToken.GetToken().Subject())
+		if err != nil {
+			return "", fmt.Errorf("fetching userinfo: %w", err)
+		}
+	}
+
+	encrypted, err := data.Encrypt(h.crypter)
 	if err != nil {
 		return "", fmt.Errorf("encrypting session data: %w", err)
 	}
@@ -98,6 +115,8 @@ func (h *Handler) DestroyForID(r *http.Request, id string) error {
 }
 
 func (h *Handler) destroyForKey(r *http.Request, key string) error {
+	defer h.connections.closeAll(key)
+
 	retryable := func(ctx context.Context) error {
 		err := h.store.Delete(r.Context(), key)
 		if err == nil {
@@ -130,24 +149,35 @@ func (h *Handler) Get(r *http.Request) (*Data, error) {
 
 // GetAccessToken returns an access token from the session. If the token is empty or expired, an error is returned.
 func (h *Handler) GetAccessToken(r *http.Request) (string, error) {
-	sessionData, err := h.GetOrRefresh(r)
+	sessionData, err := h.GetAuthenticated(r)
 	if err != nil {
 		return "", err
 	}
 
+	return sessionData.AccessToken, nil
+}
+
+// GetAuthenticated returns the session data, performing refreshes if enabled and necessary. If the access token is
+// empty or expired, an error is returned.
+func (h *Handler) GetAuthenticated(r *http.Request) (*Data, error) {
+	sessionData, err := h.GetOrRefresh(r)
+	if err != nil {
+		return nil, err
+	}
+
 	if sessionData == nil {
-		return "", ErrNoSessionData
+		return nil, ErrNoSessionData
 	}
 
 	if !sessionData.HasAccessToken() {
-		return "", ErrNoAccessToken
+		return nil, ErrNoAccessToken
 	}
 
 	if sessionData.Metadata.IsExpired() {
-		return "", ErrExpiredAccessToken
+		return nil, ErrExpiredAccessToken
 	}
 
-	return sessionData.AccessToken, nil
+	return sessionData, nil
 }
 
 // GetForID returns the session data for a given session ID.
@@ -158,6 +188,10 @@ func (h *Handler) GetForID(r *http.Request, id string) (*Data, error) {
 
 // GetForKey returns the session data for a given session Key.
 func (h *Handler) GetForKey(r *http.Request, key string) (*Data, error) {
+	return h.getForKey(r.Context(), key)
+}
+
+func (h *Handler) getForKey(ctx context.Context, key string) (*Data, error) {
 	var encryptedSessionData *EncryptedData
 	var err error
 
@@ -174,7 +208,7 @@ func (h *Handler) GetForKey(r *http.Request, key string) (*Data, error) {
 		return retry.RetryableError(err)
 	}
 
-	if err := retry.Do(r.Context(), retrypkg.DefaultBackoff, retryable); err != nil {
+	if err := retry.Do(ctx, retrypkg.DefaultBackoff, retryable); err != nil {
 		return nil, fmt.Errorf("reading from store: %w", err)
 	}
 
@@ -228,10 +262,10 @@ func (h *Handler) GetOrRefresh(r *http.Request) (*Data, error) {
 	return sessionData, nil
 }
 
-// IDOrGenerate returns the session ID, derived from the given request or id_token; e.g. `sid` or `session_state`.
+// IDOrGenerate returns the session ID, derived from the given authorization response parameters or id_token; e.g. `sid` or `session_state`.
 // If none are present, a generated ID is returned.
-func (h *Handler) IDOrGenerate(r *http.Request, tokens *openid.Tokens) (string, error) {
-	return NewSessionID(h.openidCfg.Provider(), tokens.IDToken, r.URL.Query())
+func (h *Handler) IDOrGenerate(tokens *openid.Tokens, params url.Values) (string, error) {
+	return NewSessionID(h.openidCfg.Provider(), tokens.IDToken, params)
 }
 
 // Key prefixes the session ID, e.g. the `sid` or the `session_state` properties from the OpenID provider to prevent key
@@ -328,10 +362,49 @@ func (h *Handler) Refresh(r *http.Request, key string, data *Data) (*Data, error
 		return nil, fmt.Errorf("performing refresh: %w", err)
 	}
 
+	// The id_token is optional in refresh grant responses; the previous id_token is kept if none is returned.
+	// The previous refresh token is spent at this point, so the session is destroyed if the new id_token is invalid
+	// rather than leaving it with tokens that cannot be refreshed. Other failures, e.g. when fetching the provider's
+	// signing keys, are retried and otherwise leave the session intact.
+	if len(resp.IDToken) > 0 {
+		var idToken *openid.IDToken
+		parse := func(ctx context.Context) error {
+			idToken, err = h.client.RefreshedIDToken(ctx, resp, data.IDToken)
+			if err != nil && !openid.IsIDTokenValidationError(err) {
+				return retry.RetryableError(err)
+			}
+
+			return err
+		}
+		if err := retry.Do(ctx, retrypkg.DefaultBackoff, parse); err != nil {
+			if !openid.IsIDTokenValidationError(err) {
+				return nil, fmt.Errorf("refreshed id_token: %w", err)
+			}
+
+			if err := h.destroyForKey(r, key); err != nil {
+				logger.Warnf("session: destroying session after invalid refreshed id_token: %+v", err)
+			}
+
+			if errors.Is(err, openid.ErrIDTokenSubject) {
+				return nil, invalidState(fmt.Errorf("refreshed id_token has different subject: %w", err))
+			}
+			return nil, invalidState(fmt.Errorf("refreshed id_token: %w", err))
+		}
+
+		data.IDToken = idToken.GetSerialized()
+		data.IDTokenJwtID = idToken.GetJwtID()
+	}
+
 	data.AccessToken = resp.AccessToken
 	data.RefreshToken = resp.RefreshToken
 	data.Metadata.Refresh(resp.ExpiresIn)
 
+	if h.userInfo {
+		if err := h.refreshUserInfo(ctx, data); err != nil {
+			logger.Warnf("session: could not refresh userinfo; keeping existing claims: %+v", err)
+		}
+	}
+
 	if h.cfg.Inactivity {
 		data.Metadata.ExtendTimeout(h.cfg.InactivityTimeout)
 	}
@@ -345,6 +418,21 @@ func (h *Handler) Refresh(r *http.Request, key string, data *Data) (*Data, error
 	return data, nil
 }
 
+func (h *Handler) refreshUserInfo(ctx context.Context, data *Data) error {
+	idToken, err := openid.ParseIDTokenUnverified(data.IDToken)
+	if err != nil {
+		return fmt.Errorf("parsing id_token: %w", err)
+	}
+
+	userInfo, err := h.client.UserInfo(ctx, data.AccessToken, idToken.GetToken().Subject())
+	if err != nil {
+		return err
+	}
+
+	data.UserInfo = userInfo
+	return nil
+}
+
 func (h *Handler) Update(ctx context.Context, key string, data *Data) error {
 	encrypted, err := data.Encrypt(h.crypter)
 	if err != nil {
@@ -414,3 +502,24 @@ func getSessionStateFrom(params url.Values) (string, error) {
 	}
 	return sessionState, nil
 }
+
+// invalidStateError matches ErrInvalidState while keeping its cause available to errors.Is and errors.As.
+type invalidStateError struct {
+	cause error
+}
+
+func invalidState(cause error) error {
+	return invalidStateError{cause: cause}
+}
+
+func (e invalidStateError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrInvalidState, e.cause)
+}
+
+func (e invalidStateError) Is(target error) bool {
+	return target == ErrInvalidState
+}
+
+func (e invalidStateError) Unwrap() error {
+	return e.cause
+}
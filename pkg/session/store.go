@@ -6,9 +6,8 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	log "github.com/sirupsen/logrus"
-
-	"github.com/nais/wonderwall/pkg/config"
 )
 
 var (
@@ -25,21 +24,17 @@ type Store interface {
 	MakeLock(key string) Lock
 }
 
-func NewStore(cfg *config.Config) (Store, error) {
-	if len(cfg.Redis.Address) == 0 {
+// NewStore returns a store backed by the given Redis client, or an in-memory store if the client is nil.
+func NewStore(redisClient *redis.Client) (Store, error) {
+	if redisClient == nil {
 		log.Warnf("Redis not configured, using in-memory session backing store; not suitable for multi-pod deployments!")
 		return NewMemory(), nil
 	}
 
-	redisClient, err := cfg.Redis.Client()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Redis Client: %w", err)
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer cancel()
 
-	err = redisClient.Ping(ctx).Err()
+	err := redisClient.Ping(ctx).Err()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to configured Redis: %w", err)
 	} else {